@@ -4,7 +4,6 @@ import (
 	"fmt"
 
 	"github.com/spf13/cobra"
-	"tincan/pkg/s3client"
 )
 
 var cleanCmd = &cobra.Command{
@@ -14,12 +13,12 @@ var cleanCmd = &cobra.Command{
 }
 
 func runClean(cmd *cobra.Command, args []string) error {
-	client, err := s3client.New()
+	client, err := newStorage()
 	if err != nil {
-		return fmt.Errorf("failed to create S3 client: %w", err)
+		return fmt.Errorf("failed to open storage: %w", err)
 	}
 
-	files, err := client.List()
+	files, err := client.ListContext(cmd.Context())
 	if err != nil {
 		return fmt.Errorf("failed to list files: %w", err)
 	}
@@ -44,7 +43,10 @@ func runClean(cmd *cobra.Command, args []string) error {
 	}
 
 	for _, file := range files {
-		if err := client.Delete(file.Name); err != nil {
+		if err := cmd.Context().Err(); err != nil {
+			return err
+		}
+		if err := client.DeleteContext(cmd.Context(), file.Name); err != nil {
 			fmt.Printf("Failed to delete %s: %v\n", file.Name, err)
 		} else {
 			fmt.Printf("Deleted %s\n", file.Name)
@@ -54,4 +56,3 @@ func runClean(cmd *cobra.Command, args []string) error {
 	fmt.Println("Clean completed")
 	return nil
 }
-
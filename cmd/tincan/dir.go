@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"tincan/pkg/storage"
+)
+
+// dirEntry is one file of a directory transfer.
+type dirEntry struct {
+	key        string
+	local      string
+	size       int64
+	modTime    time.Time
+	encryption string // method a stored file was encrypted with
+}
+
+// collectUploads walks dir and returns the files that pass filter, keyed by
+// prefix followed by their slash-separated path relative to dir.
+func collectUploads(dir, prefix string, filter *pathFilter) ([]dirEntry, error) {
+	var entries []dirEntry
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if rel != "." && filter.excluded(rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !filter.match(rel) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, dirEntry{key: prefix + rel, local: p, size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read directory %q: %w", dir, err)
+	}
+	return entries, nil
+}
+
+// collectDownloads lists the files below prefix that pass filter. Each one
+// is placed below dest at its key relative to the folder containing prefix,
+// so downloading "project/" recreates a "project" directory.
+func collectDownloads(ctx context.Context, store storage.Storage, prefix, dest string, filter *pathFilter) ([]dirEntry, error) {
+	base := path.Dir(strings.TrimSuffix(prefix, "/")) + "/"
+	if base == "./" {
+		base = ""
+	}
+
+	var files []storage.FileInfo
+	err := storage.Walk(ctx, store, prefix, func(f storage.FileInfo) error {
+		if strings.HasSuffix(f.Name, "/") {
+			return nil // folder placeholder
+		}
+		if filter.match(strings.TrimPrefix(f.Name, prefix)) {
+			files = append(files, f)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	fillMetadata(ctx, store, files)
+
+	entries := make([]dirEntry, 0, len(files))
+	for _, f := range files {
+		rel := filepath.FromSlash(strings.TrimPrefix(f.Name, base))
+		if !filepath.IsLocal(rel) {
+			return nil, fmt.Errorf("refusing to download %q outside of %q", f.Name, dest)
+		}
+		entries = append(entries, dirEntry{
+			key:        f.Name,
+			local:      filepath.Join(dest, rel),
+			size:       f.Size,
+			modTime:    f.ModTime(),
+			encryption: f.Metadata[storage.MetaEncryption],
+		})
+	}
+	return entries, nil
+}
+
+// transferResult counts the outcome of transferAll.
+type transferResult struct {
+	files  int
+	bytes  int64
+	failed int
+}
+
+// transferAll runs fn for every entry, jobs at a time, reporting each result
+// as it finishes below a progress line. Failed transfers are counted rather
+// than stopping the others; an error is only returned when ctx is cancelled.
+func transferAll(ctx context.Context, entries []dirEntry, jobs int, verb string, fn func(context.Context, dirEntry) error) (transferResult, error) {
+	var (
+		mu       sync.Mutex
+		result   transferResult
+		wg       sync.WaitGroup
+		sem      = make(chan struct{}, max(jobs, 1))
+		progress = newFolderProgress(entries)
+	)
+	defer progress.close()
+
+	for _, e := range entries {
+		if ctx.Err() != nil {
+			break
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(e dirEntry) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			err := fn(progress.track(ctx), e)
+
+			mu.Lock()
+			defer mu.Unlock()
+			defer progress.finish(e.key, e.size)
+			if err != nil {
+				result.failed++
+				progress.printf("  failed %s: %v\n", e.key, err)
+				return
+			}
+			result.files++
+			result.bytes += e.size
+			progress.printf("  %s %s (%s)\n", verb, e.key, formatBytes(e.size))
+		}(e)
+	}
+	wg.Wait()
+
+	return result, ctx.Err()
+}
+
+// report prints a one-line summary, or returns an error if any transfer
+// failed.
+func (r transferResult) report(verb string) error {
+	if r.failed > 0 {
+		return fmt.Errorf("%d of %d files failed", r.failed, r.files+r.failed)
+	}
+	fmt.Printf("Successfully %s %d files (%s)\n", verb, r.files, formatBytes(r.bytes))
+	return nil
+}
+
+// existingFiles returns the entries whose local file is already present.
+func existingFiles(entries []dirEntry) []dirEntry {
+	var existing []dirEntry
+	for _, e := range entries {
+		if _, err := os.Stat(e.local); err == nil {
+			existing = append(existing, e)
+		}
+	}
+	return existing
+}
@@ -1,31 +1,93 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
-	"tincan/pkg/s3client"
+	"tincan/pkg/encrypt"
+	"tincan/pkg/storage"
+)
+
+var (
+	downloadIdentity  string
+	downloadRecursive bool
+	downloadOutput    string
+	downloadInclude   []string
+	downloadExclude   []string
+	downloadJobs      int
 )
 
 var downloadCmd = &cobra.Command{
-	Use:   "download [filename]",
-	Short: "Download a file from S3",
-	Args:  cobra.ExactArgs(1),
-	RunE:  runDownload,
+	Use:   "download [filename or folder/] [-]",
+	Short: "Download a file or folder from S3",
+	Long: `Download a file or folder from S3.
+
+A name ending in "/", or any name with --recursive, downloads every file below
+it, recreating the folder structure locally. --include and --exclude select
+files by glob, matched against their path inside the folder.
+
+Encrypted files are decrypted automatically, using TINCAN_PASSPHRASE (or a
+prompt) for passphrase-encrypted files and the secret key from "tincan keygen"
+for files encrypted to a public key.
+
+With "-" after the filename, the file is written to standard output instead,
+for example:
+
+  tincan download backup.tgz - | tar xz
+
+As the content is written while it arrives, a corrupted download is only
+reported at its end, after it was written. Decrypting it needs
+TINCAN_PASSPHRASE or a secret key, as there is no prompt for a passphrase.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if err := cobra.RangeArgs(1, 2)(cmd, args); err != nil {
+			return err
+		}
+		if len(args) == 2 && args[1] != "-" {
+			return fmt.Errorf("unexpected argument %q: use --output to choose a directory, or - for standard output", args[1])
+		}
+		return nil
+	},
+	RunE: runDownload,
+}
+
+func init() {
+	downloadCmd.Flags().StringVar(&downloadIdentity, "identity", "", "secret key file for decryption (default identity_file from config)")
+	downloadCmd.Flags().BoolVarP(&downloadRecursive, "recursive", "r", false, "download every file below the given folder")
+	downloadCmd.Flags().StringVarP(&downloadOutput, "output", "o", "", "directory to download into (default: current directory)")
+	downloadCmd.Flags().StringSliceVar(&downloadInclude, "include", nil, "only download files matching this glob (repeatable)")
+	downloadCmd.Flags().StringSliceVar(&downloadExclude, "exclude", nil, "skip files matching this glob (repeatable)")
+	downloadCmd.Flags().IntVarP(&downloadJobs, "jobs", "j", 4, "files to download in parallel")
 }
 
 func runDownload(cmd *cobra.Command, args []string) error {
 	fileName := args[0]
 
-	client, err := s3client.New()
+	client, err := newStorage()
 	if err != nil {
-		return fmt.Errorf("failed to create S3 client: %w", err)
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+
+	opts := transferOptions{identityFile: downloadIdentity, interactive: true}
+
+	if downloadRecursive || strings.HasSuffix(fileName, "/") {
+		if len(args) == 2 {
+			return fmt.Errorf("a folder cannot be written to standard output")
+		}
+		return downloadDir(cmd, client, fileName, opts)
 	}
+	if len(args) == 2 {
+		return downloadStdout(cmd, client, fileName, opts)
+	}
+
+	filePath := filepath.Join(downloadOutput, fileName)
 
 	// Check if file already exists locally
-	if _, err := os.Stat(fileName); err == nil {
-		fmt.Printf("File %s already exists. Overwrite? (y/N): ", fileName)
+	if _, err := os.Stat(filePath); err == nil {
+		fmt.Printf("File %s already exists. Overwrite? (y/N): ", filePath)
 		var response string
 		fmt.Scanln(&response)
 		if response != "y" && response != "Y" {
@@ -36,10 +98,94 @@ func runDownload(cmd *cobra.Command, args []string) error {
 
 	fmt.Printf("Downloading %s...\n", fileName)
 
-	if err := client.Download(fileName, fileName); err != nil {
+	info, err := client.StatContext(cmd.Context(), fileName)
+	if err != nil {
+		return fmt.Errorf("failed to download file: %w", err)
+	}
+	progress := newProgressDisplay(fileName)
+	err = downloadFile(progress.track(cmd.Context()), client, fileName, filePath, opts)
+	progress.close()
+	if err != nil {
 		return fmt.Errorf("failed to download file: %w", err)
 	}
 
 	fmt.Printf("Successfully downloaded %s\n", fileName)
+	if info.BurnAfterReading() {
+		fmt.Println("It was a single-download file and has been deleted from the bucket")
+	}
 	return nil
-}
\ No newline at end of file
+}
+
+// downloadStdout writes key to standard output, keeping its own messages on
+// standard error.
+func downloadStdout(cmd *cobra.Command, client storage.Storage, key string, opts transferOptions) error {
+	// Run in a pipeline, there is nobody to answer a prompt
+	opts.interactive = false
+	info, err := client.StatContext(cmd.Context(), key)
+	if err != nil {
+		return fmt.Errorf("failed to download file: %w", err)
+	}
+	progress := newProgressDisplay(key)
+	err = downloadStream(progress.track(cmd.Context()), client, key, os.Stdout, opts)
+	progress.close()
+	if err != nil {
+		return fmt.Errorf("failed to download file: %w", err)
+	}
+
+	if info.BurnAfterReading() {
+		fmt.Fprintf(os.Stderr, "%s was a single-download file and has been deleted from the bucket\n", key)
+	}
+	return nil
+}
+
+func downloadDir(cmd *cobra.Command, client storage.Storage, prefix string, opts transferOptions) error {
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	filter, err := newPathFilter(downloadInclude, downloadExclude)
+	if err != nil {
+		return err
+	}
+
+	entries, err := collectDownloads(cmd.Context(), client, prefix, downloadOutput, filter)
+	if err != nil {
+		return fmt.Errorf("failed to list files: %w", err)
+	}
+	if len(entries) == 0 {
+		fmt.Printf("No files found in %s\n", prefix)
+		return nil
+	}
+
+	if existing := existingFiles(entries); len(existing) > 0 {
+		fmt.Printf("%d of %d files already exist locally. Overwrite? (y/N): ", len(existing), len(entries))
+		var response string
+		fmt.Scanln(&response)
+		if response != "y" && response != "Y" {
+			fmt.Println("Download cancelled")
+			return nil
+		}
+	}
+
+	// Ask for the passphrase once rather than for every file
+	for _, e := range entries {
+		if e.encryption == encrypt.MethodPassphrase {
+			if opts.secret, err = opts.passphrase(false); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	fmt.Printf("Downloading %d files from %s...\n", len(entries), prefix)
+	result, err := transferAll(cmd.Context(), entries, downloadJobs, "downloaded", func(ctx context.Context, e dirEntry) error {
+		if err := os.MkdirAll(filepath.Dir(e.local), 0o755); err != nil {
+			return err
+		}
+		return downloadFile(ctx, client, e.key, e.local, opts)
+	})
+	if err != nil {
+		return err
+	}
+	return result.report("downloaded")
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"tincan/pkg/storage"
+)
+
+func TestDownloadStdout(t *testing.T) {
+	store := useMemoryBackend(t)
+	ctx := context.Background()
+	data := randomData(200 << 10)
+	src := writeTestFile(t, t.TempDir(), "file", data)
+
+	for _, opts := range []transferOptions{
+		{},
+		{compress: "zstd"},
+		{compress: "gzip", encrypt: true, secret: "pw"},
+	} {
+		t.Setenv("TINCAN_PASSPHRASE", opts.secret)
+		if err := uploadFile(ctx, store, src, "out.bin", opts); err != nil {
+			t.Fatal(err)
+		}
+
+		// Only the content goes to standard output
+		stdout := captureStdout(t)
+		err := runCommand(t, runDownload, "out.bin", "-")
+		if got := stdout(); err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("download - of %+v wrote %d bytes of %d, %v", opts, len(got), len(data), err)
+		}
+		if names := dirNames(t, "."); len(names) != 0 {
+			t.Fatalf("download - of %+v wrote %v", opts, names)
+		}
+	}
+
+	// There is no prompt for a missing passphrase, even with one on stdin
+	t.Setenv("TINCAN_PASSPHRASE", "")
+	setStdin(t, []byte("pw\n"), false)
+	stdout := captureStdout(t)
+	err := runCommand(t, runDownload, "out.bin", "-")
+	if got := stdout(); err == nil || !strings.Contains(err.Error(), "TINCAN_PASSPHRASE") || len(got) != 0 {
+		t.Fatalf("download - without TINCAN_PASSPHRASE = %v, wrote %d bytes", err, len(got))
+	}
+
+	// A single-download file is gone afterwards
+	store.UploadStream(ctx, bytes.NewReader(data), int64(len(data)), "once.bin",
+		storage.WithMetadata(map[string]string{storage.MetaBurn: "true"}))
+	stdout = captureStdout(t)
+	err = runCommand(t, runDownload, "once.bin", "-")
+	if got := stdout(); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("download - of a single-download file wrote %d bytes, %v", len(got), err)
+	}
+	if _, err := store.StatContext(ctx, "once.bin"); err == nil {
+		t.Fatal("single-download file is still there")
+	}
+}
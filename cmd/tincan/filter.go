@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFile lists patterns to exclude, one per line, at the top of an
+// uploaded directory.
+const ignoreFile = ".tincanignore"
+
+// pathFilter decides which files of a directory tree are transferred. Paths
+// are slash-separated and relative to the top of the tree. Patterns use
+// path.Match syntax, plus "**" elements that match any number of
+// directories; one without a slash matches a name at any depth, one with a
+// slash matches the whole relative path. A pattern matching a directory
+// applies to everything below it.
+type pathFilter struct {
+	include []string
+	exclude []string
+}
+
+func newPathFilter(include, exclude []string) (*pathFilter, error) {
+	f := &pathFilter{}
+	for _, p := range include {
+		if err := f.add(&f.include, p); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range exclude {
+		if err := f.add(&f.exclude, p); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (f *pathFilter) add(patterns *[]string, pattern string) error {
+	pattern = strings.Trim(pattern, "/")
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	*patterns = append(*patterns, pattern)
+	return nil
+}
+
+// loadIgnoreFile adds the exclude patterns of dir's .tincanignore, if any.
+// Blank lines and lines starting with # are skipped.
+func (f *pathFilter) loadIgnoreFile(dir string) error {
+	file, err := os.Open(filepath.Join(dir, ignoreFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %w", ignoreFile, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := f.add(&f.exclude, line); err != nil {
+			return fmt.Errorf("%s: %w", ignoreFile, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// excluded reports whether rel, or a directory containing it, is excluded.
+func (f *pathFilter) excluded(rel string) bool {
+	return matchesAny(f.exclude, rel)
+}
+
+// match reports whether the file rel should be transferred.
+func (f *pathFilter) match(rel string) bool {
+	if f.excluded(rel) {
+		return false
+	}
+	return len(f.include) == 0 || matchesAny(f.include, rel)
+}
+
+func matchesAny(patterns []string, rel string) bool {
+	for p := rel; p != "." && p != "/"; p = path.Dir(p) {
+		for _, pattern := range patterns {
+			name := p
+			if !strings.Contains(pattern, "/") {
+				name = path.Base(p)
+			}
+			if matchPath(pattern, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchPath is path.Match with "**" elements matching zero or more path
+// elements.
+func matchPath(pattern, name string) bool {
+	if !strings.Contains(pattern, "**") {
+		ok, _ := path.Match(pattern, name)
+		return ok
+	}
+	return matchElems(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchElems(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchElems(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
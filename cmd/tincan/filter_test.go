@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestPathFilter(t *testing.T) {
+	for _, tc := range []struct {
+		include, exclude []string
+		rel              string
+		want             bool
+	}{
+		// Names match at any depth
+		{nil, nil, "a/b/c.txt", true},
+		{nil, []string{"*.log"}, "app.log", false},
+		{nil, []string{"*.log"}, "logs/deep/app.log", false},
+		{nil, []string{"*.log"}, "app.log.txt", true},
+		{[]string{"*.go"}, nil, "cmd/main.go", true},
+		{[]string{"*.go"}, nil, "README.md", false},
+		{[]string{"*.go", "*.mod"}, nil, "go.mod", true},
+
+		// Exclusion wins over inclusion
+		{[]string{"*.go"}, []string{"*_test.go"}, "pkg/x_test.go", false},
+		{[]string{"*.go"}, []string{"vendor"}, "vendor/lib/x.go", false},
+
+		// Directory names exclude everything below them
+		{nil, []string{"node_modules"}, "web/node_modules/pkg/index.js", false},
+		{nil, []string{"node_modules/"}, "node_modules/index.js", false},
+		{nil, []string{"node_modules"}, "web/node_modules_old/index.js", true},
+		{[]string{"src"}, nil, "src/a/b.c", true},
+
+		// Patterns with a slash match from the top
+		{nil, []string{"build/out"}, "build/out/app", false},
+		{nil, []string{"build/out"}, "sub/build/out/app", true},
+		{nil, []string{"/build"}, "build/app", false},
+		{nil, []string{"docs/*.md"}, "docs/a.md", false},
+		{nil, []string{"docs/*.md"}, "docs/sub/a.md", true},
+
+		// ** spans any number of directories, including none
+		{nil, []string{"docs/**/*.md"}, "docs/a.md", false},
+		{nil, []string{"docs/**/*.md"}, "docs/x/y/z/a.md", false},
+		{nil, []string{"docs/**/*.md"}, "docs/x/a.txt", true},
+		{nil, []string{"docs/**/*.md"}, "other/docs/a.md", true},
+		{nil, []string{"**/tmp"}, "tmp/x", false},
+		{nil, []string{"**/tmp"}, "a/b/tmp/x", false},
+		{nil, []string{"cache/**"}, "cache/a/b", false},
+		{nil, []string{"cache/**"}, "cached/a", true},
+		{[]string{"src/**/*.go"}, nil, "src/a/b/c.go", true},
+		{[]string{"src/**/*.go"}, nil, "lib/a/c.go", false},
+		{nil, []string{"**"}, "anything/at/all", false},
+	} {
+		f, err := newPathFilter(tc.include, tc.exclude)
+		if err != nil {
+			t.Fatalf("newPathFilter(%q, %q): %v", tc.include, tc.exclude, err)
+		}
+		if got := f.match(tc.rel); got != tc.want {
+			t.Errorf("include %q, exclude %q: match(%q) = %v, want %v", tc.include, tc.exclude, tc.rel, got, tc.want)
+		}
+	}
+
+	for _, pattern := range []string{"[", "a/[b", "\\"} {
+		if _, err := newPathFilter(nil, []string{pattern}); err == nil {
+			t.Errorf("pattern %q was accepted", pattern)
+		}
+	}
+}
+
+func TestIgnoreFile(t *testing.T) {
+	dir := t.TempDir()
+	f, _ := newPathFilter(nil, nil)
+	if err := f.loadIgnoreFile(dir); err != nil {
+		t.Fatalf("folder without %s: %v", ignoreFile, err)
+	}
+
+	writeTestFile(t, dir, ignoreFile, []byte("# build output\n\n  *.o  \n\tdist/\n# *.c\nsecrets/**/*.key\n"))
+	if err := f.loadIgnoreFile(dir); err != nil {
+		t.Fatalf("loadIgnoreFile: %v", err)
+	}
+	if want := []string{"*.o", "dist", "secrets/**/*.key"}; !slices.Equal(f.exclude, want) {
+		t.Fatalf("patterns = %q, want %q", f.exclude, want)
+	}
+
+	writeTestFile(t, dir, ignoreFile, []byte("ok\n[broken\n"))
+	if err := f.loadIgnoreFile(dir); err == nil {
+		t.Fatal("invalid pattern in the ignore file was accepted")
+	}
+}
+
+func TestCollectUploads(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"main.go", "main.o", "dist/app", "src/lib/x.go", "src/lib/x.o", "secrets/a/id.key", "secrets/readme"} {
+		writeTestFile(t, dir, name, []byte(name))
+	}
+	writeTestFile(t, dir, ignoreFile, []byte("*.o\ndist\nsecrets/**/*.key\n"))
+	os.Symlink(filepath.Join(dir, "main.go"), filepath.Join(dir, "link.go"))
+
+	f, _ := newPathFilter(nil, []string{ignoreFile})
+	if err := f.loadIgnoreFile(dir); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := collectUploads(dir, "backup/", f)
+	if err != nil {
+		t.Fatalf("collectUploads: %v", err)
+	}
+	want := []string{"backup/main.go", "backup/secrets/readme", "backup/src/lib/x.go"}
+	if got := keys(entries); !slices.Equal(got, want) {
+		t.Fatalf("collected %v, want %v", got, want)
+	}
+	if entries[2].local != filepath.Join(dir, "src", "lib", "x.go") || entries[2].size != int64(len("src/lib/x.go")) {
+		t.Fatalf("entry = %+v", entries[2])
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"tincan/internal/config"
+	"tincan/pkg/encrypt"
+)
+
+var keygenOutput string
+
+var keygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "Generate a key pair for encrypted transfers",
+	Long: `Generate a key pair for encrypted transfers.
+
+The secret key is written to the identity file (identity_file in the config,
+~/.tincan/identity by default) and the public key is printed. Give the public
+key to anyone who should send you files with "tincan upload --recipient".`,
+	Args: cobra.NoArgs,
+	RunE: runKeygen,
+}
+
+func init() {
+	keygenCmd.Flags().StringVarP(&keygenOutput, "output", "o", "", "file to write the secret key to")
+}
+
+func runKeygen(cmd *cobra.Command, args []string) error {
+	path := keygenOutput
+	if path == "" {
+		cfg, err := config.Load()
+		if err != nil {
+			return err
+		}
+		path = cfg.IdentityFile
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists; remove it first or use --output", path)
+	}
+
+	identity, err := encrypt.GenerateIdentity()
+	if err != nil {
+		return fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	content := fmt.Sprintf("# public key: %s\n%s\n", identity.Recipient(), identity)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		return fmt.Errorf("failed to write secret key: %w", err)
+	}
+
+	fmt.Printf("Secret key written to %s\n", path)
+	fmt.Printf("Public key: %s\n", identity.Recipient())
+	return nil
+}
@@ -2,43 +2,110 @@ package main
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/spf13/cobra"
-	"tincan/pkg/s3client"
+	"tincan/pkg/storage"
 )
 
+// listPageSize is how many files are listed per request when streaming.
+const listPageSize = 1000
+
+var listLong bool
+
 var listCmd = &cobra.Command{
-	Use:   "list",
+	Use:   "list [prefix]",
 	Short: "List files in S3 bucket",
-	RunE:  runList,
+	Long: `List files in S3 bucket.
+
+With a prefix such as "photos/2024/", only the files directly below it are
+listed, along with the folders it contains.
+
+With --long, each file is also marked as encrypted, compressed, deleted
+after the first download or expiring. On S3 this takes a request per file.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runList,
+}
+
+func init() {
+	listCmd.Flags().BoolVarP(&listLong, "long", "l", false, "show how each file is stored")
 }
 
 func runList(cmd *cobra.Command, args []string) error {
-	client, err := s3client.New()
+	client, err := newStorage()
 	if err != nil {
-		return fmt.Errorf("failed to create S3 client: %w", err)
+		return fmt.Errorf("failed to open storage: %w", err)
 	}
 
-	files, err := client.List()
-	if err != nil {
-		return fmt.Errorf("failed to list files: %w", err)
+	opts := storage.ListOptions{Limit: listPageSize}
+	heading := "Files in bucket:"
+	if len(args) > 0 {
+		opts.Prefix, opts.Delimiter = args[0], "/"
+		heading = fmt.Sprintf("Files in %s:", args[0])
 	}
 
-	if len(files) == 0 {
-		fmt.Println("No files found in bucket")
-		return nil
+	// Print a page at a time so huge buckets start showing up immediately
+	var count int
+	for {
+		page, err := client.ListPage(cmd.Context(), opts)
+		if err != nil {
+			return fmt.Errorf("failed to list files: %w", err)
+		}
+		if listLong {
+			fillMetadata(cmd.Context(), client, page.Files)
+		}
+
+		if count == 0 && len(page.Files)+len(page.Prefixes) > 0 {
+			fmt.Println(heading)
+		}
+		for _, prefix := range page.Prefixes {
+			fmt.Printf("  %-40s %10s\n", prefix, "<dir>")
+		}
+		for _, file := range page.Files {
+			size := formatBytes(file.Size)
+			date := file.LastModified.Format("2006-01-02 15:04:05")
+			fmt.Printf("  %-40s %10s  %s%s\n", file.Name, size, date, fileFlags(file))
+		}
+		count += len(page.Files) + len(page.Prefixes)
+
+		if page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
 	}
 
-	fmt.Println("Files in bucket:")
-	for _, file := range files {
-		size := formatBytes(file.Size)
-		date := file.LastModified.Format("2006-01-02 15:04:05")
-		fmt.Printf("  %-40s %10s  %s\n", file.Name, size, date)
+	if count == 0 {
+		fmt.Println("No files found in bucket")
 	}
 
 	return nil
 }
 
+// fileFlags describes how a file was stored, for the end of a listing line.
+func fileFlags(file storage.FileInfo) string {
+	var flags []string
+	if file.Encrypted() {
+		flags = append(flags, "encrypted")
+	}
+	if codec := file.Compression(); codec != "" {
+		flags = append(flags, codec)
+	}
+	if file.BurnAfterReading() {
+		flags = append(flags, "once")
+	}
+	if t, ok := file.Expires(); ok {
+		if remaining := formatRemaining(t); remaining == "expired" {
+			flags = append(flags, remaining)
+		} else {
+			flags = append(flags, "expires in "+remaining)
+		}
+	}
+	if len(flags) == 0 {
+		return ""
+	}
+	return "  [" + strings.Join(flags, ", ") + "]"
+}
+
 func formatBytes(bytes int64) string {
 	const unit = 1024
 	if bytes < unit {
@@ -50,4 +117,4 @@ func formatBytes(bytes int64) string {
 		exp++
 	}
 	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
-}
\ No newline at end of file
+}
@@ -1,10 +1,17 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"tincan/pkg/storage"
 )
 
 var (
@@ -20,21 +27,86 @@ var rootCmd = &cobra.Command{
 	CompletionOptions: cobra.CompletionOptions{
 		DisableDefaultCmd: true,
 	},
+	// Arguments and flags are checked before this runs, so usage is only
+	// printed for mistakes in them and not when the command itself fails
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		cmd.SilenceUsage = true
+	},
+	SilenceErrors: true,
+}
+
+// Exit codes, so that scripts can tell failures apart
+const (
+	exitError        = 1 // any other failure
+	exitUsage        = 2 // invalid arguments or flags
+	exitNotFound     = 3 // the file does not exist
+	exitAccessDenied = 4 // the credentials do not allow the operation
+	exitNoBucket     = 5 // the bucket does not exist
+	exitThrottled    = 6 // the backend kept asking to slow down
+	exitChecksum     = 7 // a transfer or verify found corrupted content
+	exitInterrupted  = 130
+)
+
+// exitCode returns the exit code for the error cmd failed with.
+func exitCode(cmd *cobra.Command, err error, interrupted bool) int {
+	switch {
+	case !cmd.SilenceUsage:
+		return exitUsage
+	case interrupted:
+		return exitInterrupted
+	case errors.Is(err, storage.ErrNotExist):
+		return exitNotFound
+	case errors.Is(err, storage.ErrAccessDenied), errors.Is(err, fs.ErrPermission):
+		return exitAccessDenied
+	case errors.Is(err, storage.ErrNoBucket):
+		return exitNoBucket
+	case errors.Is(err, storage.ErrThrottled):
+		return exitThrottled
+	case errors.Is(err, storage.ErrChecksum):
+		return exitChecksum
+	default:
+		return exitError
+	}
 }
 
 func main() {
-	if err := rootCmd.Execute(); err != nil {
+	// The first Ctrl-C cancels the running command so it can clean up and
+	// leave resumable state behind; a second one kills the process.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	cmd, err := rootCmd.ExecuteContextC(ctx)
+	interrupted := ctx.Err() != nil
+	stop()
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitCode(cmd, err, interrupted))
 	}
 }
 
 func init() {
+	rootCmd.PersistentFlags().String("profile", "", "config profile to use (overrides TINCAN_PROFILE)")
+	viper.BindPFlag("profile", rootCmd.PersistentFlags().Lookup("profile"))
+	rootCmd.PersistentFlags().Var(&limitRate, "limit-rate", "bandwidth limit for all transfers together, e.g. 5MB/s (overrides limit_rate)")
+
 	rootCmd.AddCommand(uploadCmd)
 	rootCmd.AddCommand(downloadCmd)
 	rootCmd.AddCommand(listCmd)
+	rootCmd.AddCommand(treeCmd)
+	rootCmd.AddCommand(syncCmd)
+	rootCmd.AddCommand(watchCmd)
+	rootCmd.AddCommand(receiveCmd)
+	rootCmd.AddCommand(sendCmd)
+	rootCmd.AddCommand(getCmd)
+	rootCmd.AddCommand(shareCmd)
+	rootCmd.AddCommand(verifyCmd)
 	rootCmd.AddCommand(deleteCmd)
 	rootCmd.AddCommand(cleanCmd)
+	rootCmd.AddCommand(pruneCmd)
 	rootCmd.AddCommand(webCmd)
+	rootCmd.AddCommand(keygenCmd)
 	rootCmd.AddCommand(versionCmd)
-}
\ No newline at end of file
+}
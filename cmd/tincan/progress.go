@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+	"tincan/pkg/storage"
+)
+
+// redrawInterval is the shortest time between two redraws of the progress
+// line.
+const redrawInterval = 200 * time.Millisecond
+
+// progressDisplay keeps a progress line at the bottom of the terminal while
+// files are transferred: one file's bytes, or a folder's files and bytes
+// together. It is nil, and draws nothing, when standard error is not a
+// terminal.
+type progressDisplay struct {
+	mu        sync.Mutex
+	label     string // the file of a single transfer
+	files     int    // files of a folder transfer, 0 for a single one
+	filesDone int
+	total     int64 // bytes of a folder transfer
+	done      int64 // bytes of the finished files
+	active    map[string]storage.Progress
+	drawn     time.Time
+}
+
+// newProgressDisplay returns a display for the transfer of label, whose size
+// is taken from the transfer itself.
+func newProgressDisplay(label string) *progressDisplay {
+	if !term.IsTerminal(int(os.Stderr.Fd())) {
+		return nil
+	}
+	return &progressDisplay{label: label, active: make(map[string]storage.Progress)}
+}
+
+// newFolderProgress returns a display for the transfer of entries.
+func newFolderProgress(entries []dirEntry) *progressDisplay {
+	if len(entries) == 0 {
+		return nil
+	}
+	d := newProgressDisplay("")
+	if d != nil {
+		d.files = len(entries)
+		d.total = entriesSize(entries)
+	}
+	return d
+}
+
+// track returns a context whose transfers report to d.
+func (d *progressDisplay) track(ctx context.Context) context.Context {
+	if d == nil {
+		return ctx
+	}
+	return storage.WithProgress(ctx, d.update)
+}
+
+func (d *progressDisplay) update(p storage.Progress) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.active[p.Key] = p
+	if time.Since(d.drawn) >= redrawInterval {
+		d.draw()
+	}
+}
+
+// finish counts the file key of a folder transfer as done, whether it was
+// transferred or failed.
+func (d *progressDisplay) finish(key string, size int64) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.active, key)
+	d.filesDone++
+	d.done += size
+	d.draw()
+}
+
+// printf prints a line to standard output above the progress line.
+func (d *progressDisplay) printf(format string, args ...any) {
+	if d == nil {
+		fmt.Printf(format, args...)
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fmt.Fprint(os.Stderr, "\r\033[K")
+	fmt.Printf(format, args...)
+	d.draw()
+}
+
+// close removes the progress line.
+func (d *progressDisplay) close() {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fmt.Fprint(os.Stderr, "\r\033[K")
+}
+
+func (d *progressDisplay) draw() {
+	d.drawn = time.Now()
+
+	bytes, total := d.done, d.total
+	var rate float64
+	for _, p := range d.active {
+		bytes += p.Bytes
+		rate += p.Rate
+		if d.files == 0 {
+			total = p.Total
+		}
+	}
+	if d.files == 0 && len(d.active) == 0 {
+		return // nothing to show until the transfer starts
+	}
+
+	var fields []string
+	if d.files > 0 {
+		fields = append(fields, fmt.Sprintf("%d/%d files", d.filesDone, d.files))
+	} else {
+		fields = append(fields, d.label)
+	}
+	if total > 0 {
+		fraction := min(max(float64(bytes)/float64(total), 0), 1)
+		const width = 20
+		filled := int(fraction * width)
+		fields = append(fields,
+			"["+strings.Repeat("#", filled)+strings.Repeat("-", width-filled)+"]",
+			fmt.Sprintf("%3.0f%%", fraction*100),
+			formatBytes(bytes)+"/"+formatBytes(total))
+	} else {
+		fields = append(fields, formatBytes(bytes))
+	}
+	if rate > 0 {
+		fields = append(fields, formatBytes(int64(rate))+"/s")
+		if total > bytes {
+			eta := time.Duration(float64(total-bytes) / rate * float64(time.Second))
+			fields = append(fields, "ETA "+eta.Round(time.Second).String())
+		}
+	}
+
+	line := strings.Join(fields, "  ")
+	if width, _, err := term.GetSize(int(os.Stderr.Fd())); err == nil && width > 0 && len(line) >= width {
+		line = line[:width-1]
+	}
+	fmt.Fprintf(os.Stderr, "\r%s\033[K", line)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"tincan/pkg/storage"
+)
+
+var (
+	pruneDryRun    bool
+	pruneLifecycle []string
+)
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune [prefix]",
+	Short: "Delete files whose time to live has passed",
+	Long: `Delete every file (below prefix, if given) that was uploaded with --ttl
+and has expired, along with expired share codes. Run it from cron, or let
+"tincan web --prune-every 1h" do it.
+
+With --install-lifecycle, S3 deletes expired files by itself instead: one
+lifecycle rule is installed per time to live given, matching the tag that
+uploads with that --ttl carry. S3 expires files at day granularity, so a TTL
+is rounded up to whole days, and rules only apply to files uploaded with a
+TTL from the list:
+
+  tincan prune --install-lifecycle 1d,7d,30d`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runPrune,
+}
+
+func init() {
+	pruneCmd.Flags().BoolVarP(&pruneDryRun, "dry-run", "n", false, "show what would be deleted without deleting anything")
+	pruneCmd.Flags().StringSliceVar(&pruneLifecycle, "install-lifecycle", nil, "install S3 lifecycle rules expiring files uploaded with these TTLs, e.g. 1d,7d")
+}
+
+func runPrune(cmd *cobra.Command, args []string) error {
+	client, err := newStorage()
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+
+	if len(pruneLifecycle) > 0 {
+		return installLifecycle(cmd.Context(), client)
+	}
+
+	prefix := ""
+	if len(args) > 0 {
+		prefix = args[0]
+	}
+	expired, err := pruneExpired(cmd.Context(), client, prefix, pruneDryRun)
+	if err != nil {
+		return err
+	}
+
+	verb := "Deleted"
+	if pruneDryRun {
+		verb = "Would delete"
+	}
+	for _, f := range expired {
+		fmt.Printf("  %s %s (%s)\n", strings.ToLower(verb), f.Name, formatBytes(f.Size))
+	}
+	if len(expired) == 0 {
+		fmt.Println("No expired files")
+		return nil
+	}
+	fmt.Printf("%s %d expired files (%s)\n", verb, len(expired), formatBytes(filesSize(expired)))
+	return nil
+}
+
+func installLifecycle(ctx context.Context, client storage.Storage) error {
+	rules, ok := client.(storage.ExpiryRules)
+	if !ok {
+		return fmt.Errorf("lifecycle rules are not supported by this storage backend")
+	}
+
+	var days []int
+	for _, v := range pruneLifecycle {
+		ttl, err := parseTTL(v)
+		if err != nil {
+			return err
+		}
+		days = append(days, ttlDays(ttl))
+	}
+	if err := rules.InstallExpiryRules(ctx, days); err != nil {
+		return fmt.Errorf("failed to install lifecycle rules: %w", err)
+	}
+	for _, d := range days {
+		fmt.Printf("Installed lifecycle rule deleting files uploaded with a %d-day TTL\n", d)
+	}
+	return nil
+}
+
+// pruneExpired deletes the files below prefix that have expired and returns
+// them. When prefix is empty, expired share codes and the files sent with
+// them are pruned too.
+func pruneExpired(ctx context.Context, store storage.Storage, prefix string, dryRun bool) ([]storage.FileInfo, error) {
+	prefixes := []string{prefix}
+	if prefix == "" {
+		prefixes = append(prefixes, storage.InternalPrefix)
+	}
+
+	var files []storage.FileInfo
+	for _, p := range prefixes {
+		err := storage.Walk(ctx, store, p, func(f storage.FileInfo) error {
+			files = append(files, f)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list files: %w", err)
+		}
+	}
+	fillMetadata(ctx, store, files)
+
+	now := time.Now()
+	var expired []storage.FileInfo
+	for _, f := range files {
+		if t, ok := f.Expires(); !ok || t.After(now) {
+			continue
+		}
+		if !dryRun {
+			if err := store.DeleteContext(ctx, f.Name); err != nil {
+				return expired, fmt.Errorf("failed to delete %s: %w", f.Name, err)
+			}
+		}
+		expired = append(expired, f)
+	}
+	return expired, nil
+}
+
+// parseTTL parses a duration that may also be given in days, such as "7d".
+func parseTTL(s string) (time.Duration, error) {
+	var ttl time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid time to live %q", s)
+		}
+		ttl = time.Duration(n * float64(24*time.Hour))
+	} else {
+		var err error
+		if ttl, err = time.ParseDuration(s); err != nil {
+			return 0, fmt.Errorf("invalid time to live %q (expected e.g. 12h or 7d)", s)
+		}
+	}
+	if ttl <= 0 {
+		return 0, fmt.Errorf("time to live must be positive")
+	}
+	return ttl, nil
+}
+
+// ttlDays rounds ttl up to whole days, the granularity of lifecycle rules.
+func ttlDays(ttl time.Duration) int {
+	return int(math.Ceil(ttl.Hours() / 24))
+}
+
+// expiryOptions records when a file uploaded now expires after ttl, both
+// for prune and for lifecycle rules. It returns nil for a zero ttl.
+func expiryOptions(ttl time.Duration) []storage.UploadOption {
+	if ttl <= 0 {
+		return nil
+	}
+	return []storage.UploadOption{
+		storage.WithMetadata(map[string]string{storage.MetaExpires: time.Now().Add(ttl).UTC().Format(time.RFC3339Nano)}),
+		storage.WithTags(map[string]string{storage.TagExpireDays: strconv.Itoa(ttlDays(ttl))}),
+	}
+}
+
+// formatRemaining describes the time left until t, such as "6d 23h".
+func formatRemaining(t time.Time) string {
+	d := time.Until(t)
+	switch {
+	case d <= 0:
+		return "expired"
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%dd %dh", int(d.Hours())/24, int(d.Hours())%24)
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh %dm", int(d.Hours()), int(d.Minutes())%60)
+	default:
+		return fmt.Sprintf("%dm", int(math.Ceil(d.Minutes())))
+	}
+}
+
+func filesSize(files []storage.FileInfo) int64 {
+	var size int64
+	for _, f := range files {
+		size += f.Size
+	}
+	return size
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"tincan/pkg/storage"
+)
+
+func TestParseTTL(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want time.Duration
+	}{
+		{"7d", 7 * 24 * time.Hour},
+		{"1d", 24 * time.Hour},
+		{"0.5d", 12 * time.Hour},
+		{"12h", 12 * time.Hour},
+		{"90m", 90 * time.Minute},
+		{"1h30m", 90 * time.Minute},
+	} {
+		if got, err := parseTTL(tc.in); err != nil || got != tc.want {
+			t.Errorf("parseTTL(%q) = %v, %v; want %v", tc.in, got, err, tc.want)
+		}
+	}
+
+	for _, in := range []string{"", "d", "7", "seven days", "7days", "1w", "0", "0d", "-1h", "-2d", "1d12h"} {
+		if got, err := parseTTL(in); err == nil {
+			t.Errorf("parseTTL(%q) = %v, want an error", in, got)
+		}
+	}
+}
+
+func TestTTLDays(t *testing.T) {
+	for ttl, want := range map[time.Duration]int{
+		time.Minute:                   1,
+		12 * time.Hour:                1,
+		24 * time.Hour:                1,
+		25 * time.Hour:                2,
+		36 * time.Hour:                2,
+		7 * 24 * time.Hour:            7,
+		7*24*time.Hour + 1:            8,
+		30 * 24 * time.Hour:           30,
+		30*24*time.Hour - 1*time.Hour: 30,
+	} {
+		if got := ttlDays(ttl); got != want {
+			t.Errorf("ttlDays(%v) = %d, want %d", ttl, got, want)
+		}
+	}
+}
+
+func TestExpiryOptions(t *testing.T) {
+	if opts := expiryOptions(0); opts != nil {
+		t.Fatalf("expiryOptions(0) = %v", opts)
+	}
+
+	before := time.Now()
+	options := storage.NewUploadOptions(expiryOptions(36 * time.Hour)...)
+	expires, err := time.Parse(time.RFC3339Nano, options.Metadata[storage.MetaExpires])
+	if err != nil {
+		t.Fatalf("expiry metadata %q: %v", options.Metadata[storage.MetaExpires], err)
+	}
+	if expires.Before(before.Add(36*time.Hour)) || expires.After(time.Now().Add(36*time.Hour)) {
+		t.Errorf("expires %v, want 36h from now", expires)
+	}
+	if got := options.Tags[storage.TagExpireDays]; got != "2" {
+		t.Errorf("expiry tag = %q, want the TTL rounded up to 2 days", got)
+	}
+}
+
+// expiryRecorder records the days of the lifecycle rules it is asked to
+// install.
+type expiryRecorder struct {
+	storage.Storage
+	days []int
+}
+
+func (r *expiryRecorder) InstallExpiryRules(ctx context.Context, days []int) error {
+	r.days = days
+	return nil
+}
+
+func TestInstallLifecycle(t *testing.T) {
+	store := &expiryRecorder{Storage: useMemoryBackend(t)}
+	pruneLifecycle = []string{"1d", "36h", "7d", "90m"}
+	t.Cleanup(func() { pruneLifecycle = nil })
+
+	if err := installLifecycle(context.Background(), store); err != nil {
+		t.Fatalf("installLifecycle: %v", err)
+	}
+	if want := []int{1, 2, 7, 1}; !slices.Equal(store.days, want) {
+		t.Fatalf("installed rules for %v days, want %v", store.days, want)
+	}
+
+	pruneLifecycle = []string{"7d", "soon"}
+	if err := installLifecycle(context.Background(), store); err == nil {
+		t.Fatal("installLifecycle accepted an invalid TTL")
+	}
+	if err := installLifecycle(context.Background(), store.Storage); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Fatalf("installLifecycle on the memory backend = %v", err)
+	}
+}
+
+func TestPruneExpired(t *testing.T) {
+	store := useMemoryBackend(t)
+	ctx := context.Background()
+	now := time.Now()
+	put := func(key string, expires time.Time) {
+		var opts []storage.UploadOption
+		if !expires.IsZero() {
+			opts = append(opts, storage.WithMetadata(map[string]string{storage.MetaExpires: expires.Format(time.RFC3339Nano)}))
+		}
+		if err := store.UploadStream(ctx, strings.NewReader(key), int64(len(key)), key, opts...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put("prune/expired.txt", now.Add(-time.Minute))
+	put("prune/sub/expired.txt", now.Add(-48*time.Hour))
+	put("prune/later.txt", now.Add(time.Hour))
+	put("prune/forever.txt", time.Time{})
+	put("elsewhere/expired.txt", now.Add(-time.Minute))
+	put(codePrefix+"9-expired-code.json", now.Add(-time.Minute))
+	put(codePrefix+"9-valid-code.json", now.Add(time.Hour))
+
+	names := func(files []storage.FileInfo) []string {
+		var names []string
+		for _, f := range files {
+			names = append(names, f.Name)
+		}
+		slices.Sort(names)
+		return names
+	}
+	exists := func(key string) bool {
+		_, err := store.StatContext(ctx, key)
+		return err == nil
+	}
+
+	// A dry run only reports
+	expired, err := pruneExpired(ctx, store, "prune/", true)
+	if err != nil {
+		t.Fatalf("pruneExpired: %v", err)
+	}
+	if want := []string{"prune/expired.txt", "prune/sub/expired.txt"}; !slices.Equal(names(expired), want) {
+		t.Fatalf("dry run found %v, want %v", names(expired), want)
+	}
+	if !exists("prune/expired.txt") {
+		t.Fatal("dry run deleted a file")
+	}
+
+	// A prefix limits the prune to the files below it
+	if _, err := pruneExpired(ctx, store, "prune/", false); err != nil {
+		t.Fatalf("pruneExpired: %v", err)
+	}
+	for key, want := range map[string]bool{
+		"prune/expired.txt": false, "prune/sub/expired.txt": false, "prune/later.txt": true, "prune/forever.txt": true,
+		"elsewhere/expired.txt": true, codePrefix + "9-expired-code.json": true,
+	} {
+		if exists(key) != want {
+			t.Errorf("after pruning prune/, %s exists: %v", key, !want)
+		}
+	}
+
+	// Without one, expired share codes go too
+	expired, err = pruneExpired(ctx, store, "", false)
+	if err != nil {
+		t.Fatalf("pruneExpired: %v", err)
+	}
+	if want := []string{codePrefix + "9-expired-code.json", "elsewhere/expired.txt"}; !slices.Equal(names(expired), want) {
+		t.Fatalf("pruned %v, want %v", names(expired), want)
+	}
+	if !exists(codePrefix + "9-valid-code.json") {
+		t.Fatal("a valid share code was pruned")
+	}
+}
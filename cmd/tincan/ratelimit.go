@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"tincan/internal/config"
+	"tincan/pkg/storage"
+)
+
+// transferLimiter is shared by every store the process opens, so that its
+// transfers together stay below the limit.
+var transferLimiter = sync.OnceValue(func() *storage.RateLimiter { return storage.NewRateLimiter(0) })
+
+// limitRate is the --limit-rate flag, which overrides the limit_rate setting.
+var limitRate rateFlag
+
+type rateFlag struct {
+	value string
+	rate  int64
+}
+
+func (f *rateFlag) String() string { return f.value }
+func (f *rateFlag) Type() string   { return "rate" }
+
+func (f *rateFlag) Set(s string) error {
+	rate, err := storage.ParseRate(s)
+	if err != nil {
+		return err
+	}
+	f.value, f.rate = s, rate
+	return nil
+}
+
+// formatRate formats a rate in bytes per second; 0 is no limit.
+func formatRate(rate int64) string {
+	if rate == 0 {
+		return "unlimited"
+	}
+	return formatBytes(rate) + "/s"
+}
+
+// limitWindow is an entry of a bandwidth schedule: rate applies from start
+// until end, in minutes after midnight local time. A window whose end is
+// before its start runs past midnight.
+type limitWindow struct {
+	start, end int
+	rate       int64
+}
+
+func (w limitWindow) contains(minute int) bool {
+	if w.start < w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+// parseSchedule parses entries such as "08:00-19:00=1MB/s" or
+// "19:00-07:00=unlimited".
+func parseSchedule(entries []string) ([]limitWindow, error) {
+	var windows []limitWindow
+	for _, entry := range entries {
+		span, rate, ok := strings.Cut(entry, "=")
+		from, to, ok2 := strings.Cut(span, "-")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("invalid limit schedule %q (expected HH:MM-HH:MM=RATE)", entry)
+		}
+		var w limitWindow
+		var err error
+		if w.start, err = parseClock(from); err != nil {
+			return nil, fmt.Errorf("invalid limit schedule %q: %w", entry, err)
+		}
+		if w.end, err = parseClock(to); err != nil {
+			return nil, fmt.Errorf("invalid limit schedule %q: %w", entry, err)
+		}
+		if w.start == w.end {
+			return nil, fmt.Errorf("invalid limit schedule %q: window is empty", entry)
+		}
+		if w.rate, err = storage.ParseRate(rate); err != nil {
+			return nil, fmt.Errorf("invalid limit schedule %q: %w", entry, err)
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// scheduledRate returns the rate of the first window containing t, or base
+// outside of all of them.
+func scheduledRate(windows []limitWindow, base int64, t time.Time) int64 {
+	minute := t.Hour()*60 + t.Minute()
+	for _, w := range windows {
+		if w.contains(minute) {
+			return w.rate
+		}
+	}
+	return base
+}
+
+// loadSchedule returns the windows of entries, or of the limit_schedule
+// setting if there are none.
+func loadSchedule(entries []string) ([]limitWindow, error) {
+	if len(entries) == 0 {
+		cfg, err := config.Load()
+		if err != nil {
+			return nil, err
+		}
+		entries = cfg.LimitSchedule
+	}
+	return parseSchedule(entries)
+}
+
+// followSchedule switches the bandwidth limit between the windows of the
+// schedule and the configured limit until ctx is done. It must be called
+// after the store was opened, which sets the configured limit.
+func followSchedule(ctx context.Context, windows []limitWindow) {
+	if len(windows) == 0 {
+		return
+	}
+	limiter := transferLimiter()
+	base := limiter.Rate()
+	current := scheduledRate(windows, base, time.Now())
+	limiter.SetRate(current)
+
+	go func() {
+		for {
+			// Windows start and end on the minute
+			next := time.Now().Truncate(time.Minute).Add(time.Minute)
+			select {
+			case <-time.After(time.Until(next)):
+			case <-ctx.Done():
+				return
+			}
+			if rate := scheduledRate(windows, base, time.Now()); rate != current {
+				limiter.SetRate(rate)
+				current = rate
+				log.Printf("Bandwidth limit is now %s", formatRate(rate))
+			}
+		}
+	}()
+}
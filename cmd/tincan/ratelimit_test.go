@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// at returns today's date at hh:mm:ss local time.
+func at(hh, mm, ss int) time.Time {
+	y, m, d := time.Now().Date()
+	return time.Date(y, m, d, hh, mm, ss, 0, time.Local)
+}
+
+func mustSchedule(t *testing.T, entries ...string) []limitWindow {
+	t.Helper()
+	windows, err := parseSchedule(entries)
+	if err != nil {
+		t.Fatalf("parseSchedule(%q): %v", entries, err)
+	}
+	return windows
+}
+
+func TestParseSchedule(t *testing.T) {
+	windows := mustSchedule(t, "08:00-19:00=1MB/s", " 19:00 - 07:30 =unlimited", "9:05-9:10=512K")
+	want := []limitWindow{
+		{8 * 60, 19 * 60, 1 << 20},
+		{19 * 60, 7*60 + 30, 0},
+		{9*60 + 5, 9*60 + 10, 512 << 10},
+	}
+	if len(windows) != len(want) {
+		t.Fatalf("parsed %v, want %v", windows, want)
+	}
+	for i := range want {
+		if windows[i] != want[i] {
+			t.Errorf("window %d = %+v, want %+v", i, windows[i], want[i])
+		}
+	}
+
+	for _, entry := range []string{
+		"",
+		"08:00-19:00",
+		"08:00=1MB/s",
+		"08:00-19:00=fast",
+		"08:00-19:00=-1MB/s",
+		"08:00-08:00=1MB/s",
+		"24:00-07:00=1MB/s",
+		"08:60-09:00=1MB/s",
+		"8am-5pm=1MB/s",
+		"08:00-19:00-20:00=1MB/s",
+	} {
+		if _, err := parseSchedule([]string{"01:00-02:00=1K", entry}); err == nil {
+			t.Errorf("parseSchedule accepted %q", entry)
+		}
+	}
+	if windows, err := parseSchedule(nil); err != nil || len(windows) != 0 {
+		t.Errorf("parseSchedule(nil) = %v, %v", windows, err)
+	}
+}
+
+func TestScheduledRate(t *testing.T) {
+	const base = 100 << 10
+	windows := mustSchedule(t, "08:00-19:00=1MB/s", "19:00-07:00=unlimited")
+
+	for _, tc := range []struct {
+		t    time.Time
+		want int64
+	}{
+		// Switching exactly on the boundaries
+		{at(7, 59, 59), base},
+		{at(8, 0, 0), 1 << 20},
+		{at(18, 59, 59), 1 << 20},
+		{at(19, 0, 0), 0},
+		{at(6, 59, 59), 0},
+		{at(7, 0, 0), base},
+
+		// Across midnight
+		{at(23, 59, 59), 0},
+		{at(0, 0, 0), 0},
+		{at(3, 30, 0), 0},
+	} {
+		if got := scheduledRate(windows, base, tc.t); got != tc.want {
+			t.Errorf("rate at %s = %d, want %d", tc.t.Format("15:04:05"), got, tc.want)
+		}
+	}
+
+	// Outside of every window, and without any, the base rate applies
+	if got := scheduledRate(nil, base, at(12, 0, 0)); got != base {
+		t.Errorf("rate without a schedule = %d, want %d", got, base)
+	}
+	if got := scheduledRate(windows, 0, at(7, 30, 0)); got != 0 {
+		t.Errorf("rate outside the windows without a limit = %d, want 0", got)
+	}
+}
+
+func TestScheduleOverlap(t *testing.T) {
+	// The first window containing the time wins
+	windows := mustSchedule(t, "12:00-13:00=1K", "09:00-17:00=2K", "22:00-10:00=3K")
+	for _, tc := range []struct {
+		t    time.Time
+		want int64
+	}{
+		{at(12, 30, 0), 1 << 10},
+		{at(13, 0, 0), 2 << 10},
+		{at(9, 30, 0), 2 << 10},
+		{at(8, 0, 0), 3 << 10},
+		{at(23, 0, 0), 3 << 10},
+		{at(18, 0, 0), 7},
+	} {
+		if got := scheduledRate(windows, 7, tc.t); got != tc.want {
+			t.Errorf("rate at %s = %d, want %d", tc.t.Format("15:04"), got, tc.want)
+		}
+	}
+
+	// Later entries only fill the gaps of earlier ones
+	windows = mustSchedule(t, "22:00-10:00=3K", "09:00-17:00=2K")
+	if got := scheduledRate(windows, 7, at(9, 30, 0)); got != 3<<10 {
+		t.Errorf("rate in the overlap = %d, want the first window's", got)
+	}
+}
+
+func TestFollowSchedule(t *testing.T) {
+	limiter := transferLimiter()
+	limiter.SetRate(5 << 10)
+	t.Cleanup(func() { limiter.SetRate(0) })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Nothing changes without a schedule
+	followSchedule(ctx, nil)
+	if got := limiter.Rate(); got != 5<<10 {
+		t.Fatalf("rate without a schedule = %d", got)
+	}
+
+	// The window in effect applies right away, not at the next boundary
+	followSchedule(ctx, mustSchedule(t, "00:00-12:00=1MB/s", "12:00-00:00=1MB/s"))
+	if got := limiter.Rate(); got != 1<<20 {
+		t.Fatalf("rate after starting the schedule = %d, want %d", got, 1<<20)
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"tincan/internal/config"
+	"tincan/pkg/encrypt"
+	"tincan/pkg/storage"
+)
+
+var (
+	receiveInto         string
+	receivePrefix       string
+	receiveInterval     time.Duration
+	receiveOnce         bool
+	receiveDeleteAfter  bool
+	receiveSkipExisting bool
+	receiveIdentity     string
+	receiveJobs         int
+)
+
+var receiveCmd = &cobra.Command{
+	Use:   "receive --into <directory>",
+	Short: "Download new files as they arrive in the bucket",
+	Long: `Poll the bucket (or a folder of it, with --prefix) and download every file
+that has not been received before into a local directory, keeping its path
+below the prefix. Files are received again when they are replaced in the
+bucket.
+
+What has been received is recorded under state_dir, so restarting picks up
+where it left off. With --delete-after, each file is removed from the bucket
+once its download has been verified.`,
+	Args: cobra.NoArgs,
+	RunE: runReceive,
+}
+
+func init() {
+	receiveCmd.Flags().StringVar(&receiveInto, "into", "", "directory to download into (required)")
+	receiveCmd.Flags().StringVar(&receivePrefix, "prefix", "", "only receive files in this folder")
+	receiveCmd.Flags().DurationVar(&receiveInterval, "interval", 30*time.Second, "how often to check for new files")
+	receiveCmd.Flags().BoolVar(&receiveOnce, "once", false, "check once and exit")
+	receiveCmd.Flags().BoolVar(&receiveDeleteAfter, "delete-after", false, "delete files from the bucket once they are downloaded and verified")
+	receiveCmd.Flags().BoolVar(&receiveSkipExisting, "skip-existing", false, "on the first run, treat files already in the bucket as received")
+	receiveCmd.Flags().StringVar(&receiveIdentity, "identity", "", "secret key file for decryption (default identity_file from config)")
+	receiveCmd.Flags().IntVarP(&receiveJobs, "jobs", "j", 4, "files to download in parallel")
+	receiveCmd.MarkFlagRequired("into")
+}
+
+// receiveState records the files a receive has fetched, so that they are
+// not downloaded again.
+type receiveState struct {
+	Received map[string]receivedFile `json:"received"`
+}
+
+type receivedFile struct {
+	ETag         string    `json:"etag,omitempty"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+	Received     time.Time `json:"received"`
+}
+
+// matches reports whether f is the version of the file that was received.
+func (r receivedFile) matches(f storage.FileInfo) bool {
+	if r.ETag != "" || f.ETag != "" {
+		return r.ETag == f.ETag
+	}
+	return r.Size == f.Size && r.LastModified.Equal(f.LastModified)
+}
+
+func runReceive(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	client, err := newStorage()
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+
+	into, err := filepath.Abs(receiveInto)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(into, 0o755); err != nil {
+		return fmt.Errorf("unable to create %s: %w", into, err)
+	}
+
+	prefix := receivePrefix
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	// One state file per destination and source, named after both
+	sum := sha256.Sum256([]byte(cfg.Profile + "\x00" + cfg.BucketName + "\x00" + cfg.Prefix + prefix + "\x00" + into))
+	statePath := filepath.Join(cfg.StateDir, "receive", hex.EncodeToString(sum[:6])+".json")
+
+	state, err := loadReceiveState(statePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		state = &receiveState{Received: make(map[string]receivedFile)}
+		if receiveSkipExisting {
+			if err := markReceived(cmd.Context(), client, prefix, state); err != nil {
+				return err
+			}
+			if err := saveReceiveState(statePath, state); err != nil {
+				return err
+			}
+			log.Printf("Skipped %d files already in the bucket", len(state.Received))
+		}
+	} else if err != nil {
+		return err
+	}
+
+	opts := transferOptions{identityFile: receiveIdentity, interactive: true}
+	if !receiveOnce {
+		log.Printf("Receiving new files into %s every %s (Ctrl-C to stop)", into, receiveInterval)
+	}
+	for {
+		if err := receivePoll(cmd.Context(), client, prefix, into, state, statePath, &opts); err != nil {
+			if cmd.Context().Err() != nil {
+				return nil
+			}
+			if receiveOnce {
+				return err
+			}
+			log.Printf("Receive failed: %v", err)
+		}
+		if receiveOnce {
+			return nil
+		}
+
+		select {
+		case <-cmd.Context().Done():
+			return nil
+		case <-time.After(receiveInterval):
+		}
+	}
+}
+
+// markReceived records every file currently below prefix as received.
+func markReceived(ctx context.Context, store storage.Storage, prefix string, state *receiveState) error {
+	now := time.Now()
+	return storage.Walk(ctx, store, prefix, func(f storage.FileInfo) error {
+		state.Received[f.Name] = receivedFile{ETag: f.ETag, Size: f.Size, LastModified: f.LastModified, Received: now}
+		return nil
+	})
+}
+
+// receivePoll downloads the files below prefix that have not been received.
+func receivePoll(ctx context.Context, store storage.Storage, prefix, into string, state *receiveState, statePath string, opts *transferOptions) error {
+	listed := make(map[string]bool)
+	var files []storage.FileInfo
+	err := storage.Walk(ctx, store, prefix, func(f storage.FileInfo) error {
+		listed[f.Name] = true
+		if strings.HasSuffix(f.Name, "/") {
+			return nil
+		}
+		if r, ok := state.Received[f.Name]; !ok || !r.matches(f) {
+			files = append(files, f)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list files: %w", err)
+	}
+
+	// Forget files that are gone, so they are received again if re-sent
+	for key := range state.Received {
+		if !listed[key] {
+			delete(state.Received, key)
+		}
+	}
+	if len(files) == 0 {
+		return saveReceiveState(statePath, state)
+	}
+	fillMetadata(ctx, store, files)
+
+	// Keys such as a/b and a//b end up in the same file. It stays with the
+	// key received first, or listed first, and the others are skipped.
+	owners := make(map[string]string)
+	receivedKeys := make([]string, 0, len(state.Received))
+	for key := range state.Received {
+		receivedKeys = append(receivedKeys, key)
+	}
+	sort.Strings(receivedKeys)
+	for _, key := range receivedKeys {
+		if local, ok := receivePath(into, prefix, key); ok {
+			if _, taken := owners[local]; !taken {
+				owners[local] = key
+			}
+		}
+	}
+
+	entries := make([]dirEntry, 0, len(files))
+	byKey := make(map[string]storage.FileInfo, len(files))
+	for _, f := range files {
+		local, ok := receivePath(into, prefix, f.Name)
+		if !ok {
+			log.Printf("Skipping %s: it would be written outside of %s", f.Name, into)
+			continue
+		}
+		if owner, taken := owners[local]; taken && owner != f.Name {
+			log.Printf("Skipping %s: %s is received from %s", f.Name, local, owner)
+			continue
+		}
+		owners[local] = f.Name
+		e := remoteEntry(f, local)
+		if e.encryption == encrypt.MethodPassphrase && opts.secret == "" {
+			if opts.secret, err = opts.passphrase(false); err != nil {
+				return err
+			}
+		}
+		entries = append(entries, e)
+		byKey[f.Name] = f
+	}
+
+	received := make(chan storage.FileInfo, len(entries))
+	result, err := transferAll(ctx, entries, receiveJobs, "received", func(ctx context.Context, e dirEntry) error {
+		f := byKey[e.key]
+		if err := os.MkdirAll(filepath.Dir(e.local), 0o755); err != nil {
+			return err
+		}
+		if err := downloadFile(ctx, store, e.key, e.local, *opts); err != nil {
+			return err
+		}
+		if err := verifyReceived(f, e.local); err != nil {
+			os.Remove(e.local)
+			return err
+		}
+		os.Chtimes(e.local, e.modTime, e.modTime)
+		if receiveDeleteAfter {
+			if err := store.DeleteContext(ctx, e.key); err != nil {
+				return fmt.Errorf("downloaded but not deleted: %w", err)
+			}
+		}
+		received <- f
+		return nil
+	})
+	close(received)
+
+	now := time.Now()
+	for f := range received {
+		state.Received[f.Name] = receivedFile{ETag: f.ETag, Size: f.Size, LastModified: f.LastModified, Received: now}
+	}
+	if saveErr := saveReceiveState(statePath, state); saveErr != nil {
+		return saveErr
+	}
+	if err != nil {
+		return err
+	}
+	if result.failed > 0 {
+		return fmt.Errorf("%d of %d files failed", result.failed, len(entries))
+	}
+	return nil
+}
+
+// receivePath returns the file key is received into, or false if it would
+// be outside of into.
+func receivePath(into, prefix, key string) (string, bool) {
+	rel := filepath.FromSlash(strings.TrimPrefix(key, prefix))
+	if !filepath.IsLocal(rel) {
+		return "", false
+	}
+	return filepath.Join(into, rel), true
+}
+
+// verifyReceived checks the size of a downloaded file that has no recorded
+// checksum. Downloads of files with one are verified as they are written.
+func verifyReceived(f storage.FileInfo, local string) error {
+	if f.SHA256() != "" || f.Encrypted() || f.Compression() != "" {
+		return nil // the checksum, decryption or decompression already checked the content
+	}
+	info, err := os.Stat(local)
+	if err != nil {
+		return err
+	}
+	if info.Size() != f.Size {
+		return fmt.Errorf("size mismatch for %s: got %d bytes, want %d", f.Name, info.Size(), f.Size)
+	}
+	return nil
+}
+
+func loadReceiveState(path string) (*receiveState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var state receiveState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("unable to read receive state %q: %w", path, err)
+	}
+	if state.Received == nil {
+		state.Received = make(map[string]receivedFile)
+	}
+	return &state, nil
+}
+
+func saveReceiveState(path string, state *receiveState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("unable to save receive state: %w", err)
+	}
+	return writeFileAtomic(path, func(w io.Writer) error {
+		if _, err := w.Write(data); err != nil {
+			return fmt.Errorf("unable to save receive state: %w", err)
+		}
+		return nil
+	})
+}
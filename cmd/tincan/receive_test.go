@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func setReceiveFlags(t *testing.T, into string, deleteAfter, skipExisting bool) {
+	t.Helper()
+	receiveInto, receivePrefix, receiveOnce = into, "in", true
+	receiveDeleteAfter, receiveSkipExisting = deleteAfter, skipExisting
+	t.Cleanup(func() {
+		receiveInto, receivePrefix, receiveOnce = "", "", false
+		receiveDeleteAfter, receiveSkipExisting = false, false
+	})
+}
+
+func runReceiveOnce(t *testing.T) {
+	t.Helper()
+	cmd := &cobra.Command{}
+	cmd.SetContext(context.Background())
+	if err := runReceive(cmd, nil); err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+}
+
+func readLocal(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestReceiveSeen(t *testing.T) {
+	store := useMemoryBackend(t)
+	ctx := context.Background()
+	into := t.TempDir()
+	setReceiveFlags(t, into, false, false)
+	putRemote(t, store, "in/a.txt", "alpha", syncTime, nil)
+	putRemote(t, store, "in/sub/b.txt", "beta", syncTime, nil)
+	putRemote(t, store, "elsewhere/c.txt", "gamma", syncTime, nil)
+
+	runReceiveOnce(t)
+	if names := dirNames(t, into); !slices.Equal(names, []string{"a.txt", "sub"}) {
+		t.Fatalf("received %v", names)
+	}
+	if got := readLocal(t, filepath.Join(into, "sub", "b.txt")); got != "beta" {
+		t.Fatalf("sub/b.txt holds %q", got)
+	}
+	if info, _ := os.Stat(filepath.Join(into, "a.txt")); !info.ModTime().Equal(syncTime) {
+		t.Errorf("a.txt has time %v, want %v", info.ModTime(), syncTime)
+	}
+
+	// What was received is not fetched again, even when removed locally
+	os.Remove(filepath.Join(into, "a.txt"))
+	runReceiveOnce(t)
+	if names := dirNames(t, into); !slices.Equal(names, []string{"sub"}) {
+		t.Fatalf("second receive left %v", names)
+	}
+
+	// Unless it is replaced in the bucket
+	putRemote(t, store, "in/a.txt", "alpha 2", syncTime, nil)
+	runReceiveOnce(t)
+	if got := readLocal(t, filepath.Join(into, "a.txt")); got != "alpha 2" {
+		t.Fatalf("replaced a.txt received as %q", got)
+	}
+
+	// Or removed and sent again
+	store.DeleteContext(ctx, "in/sub/b.txt")
+	runReceiveOnce(t)
+	writeTestFile(t, into, "sub/b.txt", []byte("edited locally"))
+	putRemote(t, store, "in/sub/b.txt", "beta", syncTime, nil)
+	runReceiveOnce(t)
+	if got := readLocal(t, filepath.Join(into, "sub", "b.txt")); got != "beta" {
+		t.Fatalf("re-sent sub/b.txt received as %q", got)
+	}
+}
+
+func TestReceiveSkipExisting(t *testing.T) {
+	store := useMemoryBackend(t)
+	into := t.TempDir()
+	putRemote(t, store, "in/old.txt", "old", syncTime, nil)
+
+	setReceiveFlags(t, into, false, true)
+	runReceiveOnce(t)
+	if names := dirNames(t, into); len(names) != 0 {
+		t.Fatalf("first receive with --skip-existing fetched %v", names)
+	}
+
+	// Only the first run skips
+	putRemote(t, store, "in/new.txt", "new", syncTime, nil)
+	runReceiveOnce(t)
+	if names := dirNames(t, into); !slices.Equal(names, []string{"new.txt"}) {
+		t.Fatalf("received %v", names)
+	}
+}
+
+func TestReceiveDeleteAfter(t *testing.T) {
+	store := useMemoryBackend(t)
+	ctx := context.Background()
+	into := t.TempDir()
+	putRemote(t, store, "in/a.txt", "alpha", syncTime, nil)
+	putRemote(t, store, "in/b.txt", "beta", syncTime, nil)
+
+	setReceiveFlags(t, into, true, false)
+	runReceiveOnce(t)
+	if names := dirNames(t, into); !slices.Equal(names, []string{"a.txt", "b.txt"}) {
+		t.Fatalf("received %v", names)
+	}
+	for _, key := range []string{"in/a.txt", "in/b.txt"} {
+		if _, err := store.StatContext(ctx, key); err == nil {
+			t.Errorf("%s is still in the bucket", key)
+		}
+	}
+}
+
+func TestReceiveCollisions(t *testing.T) {
+	store := useMemoryBackend(t)
+	into := t.TempDir()
+	setReceiveFlags(t, into, false, false)
+	putRemote(t, store, "in/a.txt", "first", syncTime, nil)
+	runReceiveOnce(t)
+
+	// A later key for the same file does not replace it
+	putRemote(t, store, "in/./a.txt", "second", syncTime, nil)
+	putRemote(t, store, "in//a.txt", "third", syncTime, nil)
+	runReceiveOnce(t)
+	if got := readLocal(t, filepath.Join(into, "a.txt")); got != "first" {
+		t.Fatalf("a.txt holds %q, want the key received first", got)
+	}
+
+	// Of keys arriving together, the one listed first wins for good
+	putRemote(t, store, "in/d/b.txt", "clean", syncTime, nil)
+	putRemote(t, store, "in/d//b.txt", "doubled", syncTime, nil)
+	runReceiveOnce(t)
+	if got := readLocal(t, filepath.Join(into, "d", "b.txt")); got != "doubled" {
+		t.Fatalf("d/b.txt holds %q, want the key listed first", got)
+	}
+	putRemote(t, store, "in/d/b.txt", "clean, again", syncTime, nil)
+	runReceiveOnce(t)
+	if got := readLocal(t, filepath.Join(into, "d", "b.txt")); got != "doubled" {
+		t.Fatalf("d/b.txt holds %q after the other key was replaced", got)
+	}
+
+	// Keys outside of the directory are never written
+	putRemote(t, store, "in/../escape.txt", "x", syncTime, nil)
+	runReceiveOnce(t)
+	if _, err := os.Stat(filepath.Join(filepath.Dir(into), "escape.txt")); err == nil {
+		t.Fatal("a key was received outside of the directory")
+	}
+	if names := dirNames(t, into); !slices.Equal(names, []string{"a.txt", "d"}) {
+		t.Fatalf("received %v", names)
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"tincan/pkg/compress"
+	"tincan/pkg/storage"
+)
+
+// codePrefix holds one JSON index entry per share code, and sendPrefix the
+// files uploaded by send.
+const (
+	codePrefix = storage.InternalPrefix + "codes/"
+	sendPrefix = storage.InternalPrefix + "send/"
+)
+
+var (
+	sendKey       string
+	sendExpires   time.Duration
+	sendOnce      bool
+	sendCompress  string
+	sendEncrypt   bool
+	sendRecipient string
+
+	getOutput   string
+	getIdentity string
+)
+
+var sendCmd = &cobra.Command{
+	Use:   "send [file]",
+	Short: "Upload a file and print a short code to fetch it with",
+	Long: `Upload a file and print a short code such as 7-purple-tiger. Run
+"tincan get 7-purple-tiger" on another machine to download it, without
+knowing where it is stored.
+
+Codes expire after --expires. With --once, a code works for a single
+download, after which the file is deleted. --key hands out a code for a file
+that is already in the bucket instead; that file is never deleted by get.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if sendKey != "" {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.ExactArgs(1)(cmd, args)
+	},
+	RunE: runSend,
+}
+
+var getCmd = &cobra.Command{
+	Use:   "get <code>",
+	Short: "Download a file shared with tincan send",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runGet,
+}
+
+func init() {
+	sendCmd.Flags().StringVar(&sendKey, "key", "", "share a file already in the bucket instead of uploading one")
+	sendCmd.Flags().DurationVar(&sendExpires, "expires", 24*time.Hour, "how long the code stays valid")
+	sendCmd.Flags().BoolVar(&sendOnce, "once", false, "allow a single download, then delete the file")
+	sendCmd.Flags().StringVar(&sendCompress, "compress", "", "compress before uploading: zstd or gzip")
+	sendCmd.Flags().Lookup("compress").NoOptDefVal = compress.Zstd
+	sendCmd.Flags().BoolVar(&sendEncrypt, "encrypt", false, "encrypt the file before uploading")
+	sendCmd.Flags().StringVar(&sendRecipient, "recipient", "", "public key to encrypt to instead of a passphrase (implies --encrypt)")
+
+	getCmd.Flags().StringVarP(&getOutput, "output", "o", "", "file to save to (default: the original file name)")
+	getCmd.Flags().StringVar(&getIdentity, "identity", "", "secret key file for decryption (default identity_file from config)")
+}
+
+// shareCode is the index entry a code points to.
+type shareCode struct {
+	Key       string    `json:"key"`
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	Created   time.Time `json:"created"`
+	Expires   time.Time `json:"expires"`
+	SingleUse bool      `json:"singleUse,omitempty"`
+	// Owned is set when the file was uploaded by send and is deleted along
+	// with the code.
+	Owned bool `json:"owned,omitempty"`
+}
+
+func runSend(cmd *cobra.Command, args []string) error {
+	if sendExpires <= 0 {
+		return fmt.Errorf("--expires must be positive")
+	}
+	if sendCompress != "" && !compress.Valid(sendCompress) {
+		return fmt.Errorf("unsupported compression %q (expected zstd or gzip)", sendCompress)
+	}
+
+	client, err := newStorage()
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+	ctx := cmd.Context()
+
+	code, err := newShareCode(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	entry := shareCode{Created: now, Expires: now.Add(sendExpires), SingleUse: sendOnce}
+	if sendKey != "" {
+		info, err := client.StatContext(ctx, sendKey)
+		if err != nil {
+			return fmt.Errorf("failed to find %s: %w", sendKey, err)
+		}
+		entry.Key, entry.Name, entry.Size = sendKey, filepath.Base(sendKey), info.Size
+	} else {
+		filePath := args[0]
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return fmt.Errorf("file does not exist: %s", filePath)
+		}
+		if info.IsDir() {
+			return fmt.Errorf("%s is a directory", filePath)
+		}
+
+		entry.Name, entry.Size, entry.Owned = filepath.Base(filePath), info.Size(), true
+		entry.Key = sendPrefix + code + "/" + entry.Name
+
+		fmt.Printf("Uploading %s...\n", entry.Name)
+		opts := transferOptions{
+			compress:    sendCompress,
+			encrypt:     sendEncrypt || sendRecipient != "",
+			recipient:   sendRecipient,
+			interactive: true,
+			ttl:         sendExpires,
+		}
+		if sendOnce {
+			// Only one of several simultaneous gets can receive the file
+			opts.metadata = map[string]string{storage.MetaBurn: "true"}
+		}
+		if err := uploadFile(ctx, client, filePath, entry.Key, opts); err != nil {
+			return fmt.Errorf("failed to upload file: %w", err)
+		}
+	}
+
+	if err := putShareCode(ctx, client, code, entry); err != nil {
+		return fmt.Errorf("failed to save share code: %w", err)
+	}
+
+	uses := "until"
+	if entry.SingleUse {
+		uses = "once, until"
+	}
+	fmt.Printf("\nShare code: %s\n", code)
+	fmt.Printf("On the other machine run: tincan get %s\n", code)
+	fmt.Printf("Valid %s %s\n", uses, entry.Expires.Local().Format("2006-01-02 15:04"))
+	return nil
+}
+
+func runGet(cmd *cobra.Command, args []string) error {
+	code := normalizeCode(args[0])
+
+	client, err := newStorage()
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+	ctx := cmd.Context()
+
+	var entry shareCode
+	if err := getJSON(ctx, client, codePrefix+code+".json", &entry); err != nil {
+		if errors.Is(err, storage.ErrNotExist) {
+			return fmt.Errorf("unknown share code %q", code)
+		}
+		if errors.Is(err, storage.ErrClaimed) {
+			return fmt.Errorf("share code %q has already been used", code)
+		}
+		return fmt.Errorf("failed to look up share code: %w", err)
+	}
+
+	// Reading a single-use code took it out of the bucket, so that no other
+	// get can start; it goes back unless the file was delivered, also when
+	// the get is interrupted and ctx is done
+	used := false
+	if entry.SingleUse {
+		defer func() {
+			if used {
+				return
+			}
+			restoreCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := putShareCode(restoreCtx, client, code, entry); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to restore share code %s: %v\n", code, err)
+			}
+		}()
+	}
+
+	if time.Now().After(entry.Expires) {
+		used = true
+		removeShareCode(ctx, client, code, entry)
+		return fmt.Errorf("share code %q expired on %s", code, entry.Expires.Local().Format("2006-01-02 15:04"))
+	}
+
+	filePath := getOutput
+	if filePath == "" {
+		// The index is only as trustworthy as the bucket, so its name must
+		// not point outside the current directory
+		filePath = filepath.Base(entry.Name)
+		if !filepath.IsLocal(filePath) || filePath == "." {
+			return fmt.Errorf("share code %q has an invalid file name %q (use --output)", code, entry.Name)
+		}
+	}
+	if _, err := os.Stat(filePath); err == nil {
+		fmt.Printf("File %s already exists. Overwrite? (y/N): ", filePath)
+		var response string
+		fmt.Scanln(&response)
+		if response != "y" && response != "Y" {
+			fmt.Println("Download cancelled")
+			return nil
+		}
+	}
+
+	fmt.Printf("Downloading %s (%s)...\n", entry.Name, formatBytes(entry.Size))
+	opts := transferOptions{identityFile: getIdentity, interactive: true}
+	if err := downloadFile(ctx, client, entry.Key, filePath, opts); err != nil {
+		if entry.SingleUse && (errors.Is(err, storage.ErrClaimed) || errors.Is(err, storage.ErrNotExist)) {
+			used = true
+			return fmt.Errorf("share code %q has already been used", code)
+		}
+		return fmt.Errorf("failed to download file: %w", err)
+	}
+	used = true
+	fmt.Printf("Successfully downloaded %s\n", filePath)
+
+	if entry.SingleUse {
+		if err := removeShareCode(ctx, client, code, entry); err != nil {
+			return fmt.Errorf("downloaded, but failed to remove single-use code: %w", err)
+		}
+	}
+	return nil
+}
+
+// putShareCode stores the index entry of code. prune removes it once it
+// expires, even if it is never used, and a single-use code is
+// burn-after-reading, so that only one get can ever read it, even for a
+// file shared with --key that is not deleted itself.
+func putShareCode(ctx context.Context, store storage.Storage, code string, entry shareCode) error {
+	metadata := map[string]string{storage.MetaExpires: entry.Expires.Format(time.RFC3339Nano)}
+	if entry.SingleUse {
+		metadata[storage.MetaBurn] = "true"
+	}
+	return putJSON(ctx, store, codePrefix+code+".json", entry, storage.WithMetadata(metadata))
+}
+
+// removeShareCode deletes a code, and the file it points to if send
+// uploaded it.
+func removeShareCode(ctx context.Context, store storage.Storage, code string, entry shareCode) error {
+	if entry.Owned {
+		if err := store.DeleteContext(ctx, entry.Key); err != nil {
+			return err
+		}
+	}
+	return store.DeleteContext(ctx, codePrefix+code+".json")
+}
+
+// newShareCode picks a code that is not in use.
+func newShareCode(ctx context.Context, store storage.Storage) (string, error) {
+	for i := 0; i < 10; i++ {
+		code := fmt.Sprintf("%d-%s-%s", randomInt(98)+2, codeAdjectives[randomInt(len(codeAdjectives))], codeNouns[randomInt(len(codeNouns))])
+		_, err := store.StatContext(ctx, codePrefix+code+".json")
+		if errors.Is(err, storage.ErrNotExist) {
+			return code, nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to check share code: %w", err)
+		}
+	}
+	return "", fmt.Errorf("unable to find an unused share code")
+}
+
+func randomInt(n int) int {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		panic(err)
+	}
+	return int(v.Int64())
+}
+
+// normalizeCode accepts codes typed with spaces or in upper case.
+func normalizeCode(code string) string {
+	return strings.Join(strings.Fields(strings.ToLower(strings.ReplaceAll(code, "-", " "))), "-")
+}
+
+// putJSON stores v as the object key.
+func putJSON(ctx context.Context, store storage.Storage, key string, v any, opts ...storage.UploadOption) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp("", "tincan_index_*")
+	if err != nil {
+		return fmt.Errorf("unable to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write temp file: %w", err)
+	}
+	return store.UploadContext(ctx, tmp.Name(), key, opts...)
+}
+
+// getJSON reads the object key into v.
+func getJSON(ctx context.Context, store storage.Storage, key string, v any) error {
+	tmp, err := os.CreateTemp("", "tincan_index_*")
+	if err != nil {
+		return fmt.Errorf("unable to create temp file: %w", err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if err := store.DownloadContext(ctx, key, tmp.Name()); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("unable to read %q: %w", key, err)
+	}
+	return nil
+}
+
+var codeAdjectives = []string{
+	"amber", "ancient", "bold", "brave", "bright", "brisk", "calm", "clever",
+	"cosmic", "crimson", "curly", "dapper", "daring", "dusty", "eager", "early",
+	"electric", "emerald", "fancy", "fluffy", "frosty", "gentle", "giant", "golden",
+	"happy", "hidden", "humble", "icy", "jolly", "keen", "lively", "lucky",
+	"mellow", "mighty", "misty", "modern", "noble", "odd", "orange", "patient",
+	"plucky", "polite", "proud", "purple", "quick", "quiet", "rapid", "rusty",
+	"silent", "silver", "sleepy", "smooth", "snowy", "solar", "spicy", "sunny",
+	"swift", "tidy", "tiny", "velvet", "vivid", "wild", "witty", "zesty",
+}
+
+var codeNouns = []string{
+	"anchor", "badger", "banjo", "beacon", "bison", "cactus", "canyon", "comet",
+	"cookie", "coral", "crane", "dolphin", "dragon", "falcon", "ferret", "fjord",
+	"gecko", "glacier", "harbor", "hedgehog", "heron", "island", "jaguar", "kettle",
+	"koala", "lantern", "lemon", "lizard", "llama", "magnet", "maple", "meadow",
+	"meteor", "moose", "nebula", "octopus", "otter", "owl", "panda", "parrot",
+	"pebble", "pepper", "pigeon", "planet", "puffin", "quartz", "rabbit", "raven",
+	"river", "rocket", "saddle", "salmon", "spider", "squid", "teapot", "thunder",
+	"tiger", "tulip", "turtle", "violin", "walrus", "willow", "wombat", "zebra",
+}
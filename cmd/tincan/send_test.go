@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"tincan/pkg/storage"
+)
+
+// useMemoryBackend points newStorage at the in-memory bucket, emptied, and
+// runs the test in an empty directory.
+func useMemoryBackend(t *testing.T) storage.Storage {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("TINCAN_BACKEND", "memory")
+	t.Setenv("TINCAN_PASSPHRASE", "")
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	store, err := newStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, prefix := range []string{"", storage.InternalPrefix} {
+		storage.Walk(context.Background(), store, prefix, func(f storage.FileInfo) error {
+			return store.DeleteContext(context.Background(), f.Name)
+		})
+	}
+	return store
+}
+
+func runGetCode(t *testing.T, code, output string) error {
+	t.Helper()
+	getOutput = output
+	t.Cleanup(func() { getOutput = "" })
+	cmd := &cobra.Command{}
+	cmd.SetContext(context.Background())
+	return runGet(cmd, []string{code})
+}
+
+func shareTestCode(t *testing.T, store storage.Storage, code string, entry shareCode) {
+	t.Helper()
+	if entry.Expires.IsZero() {
+		entry.Expires = time.Now().Add(time.Hour)
+	}
+	if err := putShareCode(context.Background(), store, code, entry); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetFileName(t *testing.T) {
+	ctx := context.Background()
+	store := useMemoryBackend(t)
+	store.UploadStream(ctx, strings.NewReader("content"), 7, "shared.txt")
+
+	// Only the last element of the name in the index is used
+	shareTestCode(t, store, "2-path-name", shareCode{Key: "shared.txt", Name: "../../outside/escape.txt"})
+	if err := runGetCode(t, "2-path-name", ""); err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if data, err := os.ReadFile("escape.txt"); err != nil || string(data) != "content" {
+		t.Fatalf("escape.txt = %q, %v", data, err)
+	}
+
+	for i, name := range []string{"..", "/", "", "."} {
+		code := "3-bad-name" + strings.Repeat("x", i)
+		shareTestCode(t, store, code, shareCode{Key: "shared.txt", Name: name})
+		if err := runGetCode(t, code, ""); err == nil || !strings.Contains(err.Error(), "invalid file name") {
+			t.Errorf("get of a file named %q = %v", name, err)
+		}
+	}
+	if names := dirNames(t, "."); len(names) != 1 {
+		t.Fatalf("get wrote %v", names)
+	}
+
+	// --output is taken as given
+	shareTestCode(t, store, "4-bad-name", shareCode{Key: "shared.txt", Name: ".."})
+	if err := runGetCode(t, "4-bad-name", "named.txt"); err != nil {
+		t.Fatalf("get --output: %v", err)
+	}
+}
+
+func TestGetOnce(t *testing.T) {
+	ctx := context.Background()
+	store := useMemoryBackend(t)
+	store.UploadStream(ctx, strings.NewReader("report"), 6, "reports/q3.txt")
+
+	// A file shared with --key --once stays, but its code works once
+	shareTestCode(t, store, "5-single-use", shareCode{Key: "reports/q3.txt", Name: "q3.txt", SingleUse: true})
+	info, err := store.StatContext(ctx, codePrefix+"5-single-use.json")
+	if err != nil || !info.BurnAfterReading() {
+		t.Fatalf("single-use code is not burn-after-reading: %v", err)
+	}
+
+	// A failed get leaves the code usable
+	if err := runGetCode(t, "5-single-use", "missing/q3.txt"); err == nil {
+		t.Fatal("get into a missing directory succeeded")
+	}
+	if _, err := store.StatContext(ctx, codePrefix+"5-single-use.json"); err != nil {
+		t.Fatalf("failed get used up the code: %v", err)
+	}
+
+	if err := runGetCode(t, "5-single-use", "first.txt"); err != nil {
+		t.Fatalf("first get: %v", err)
+	}
+	if err := runGetCode(t, "5-single-use", "second.txt"); err == nil {
+		t.Fatal("second get succeeded")
+	}
+	if _, err := os.Stat("second.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("second get wrote a file: %v", err)
+	}
+	if _, err := store.StatContext(ctx, "reports/q3.txt"); err != nil {
+		t.Fatalf("get deleted the file shared with --key: %v", err)
+	}
+
+	// A file uploaded by send is deleted with its code
+	key := sendPrefix + "6-single-use/owned.txt"
+	store.UploadStream(ctx, strings.NewReader("owned"), 5, key, storage.WithMetadata(map[string]string{storage.MetaBurn: "true"}))
+	shareTestCode(t, store, "6-single-use", shareCode{Key: key, Name: "owned.txt", SingleUse: true, Owned: true})
+	if err := runGetCode(t, "6-single-use", ""); err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	for _, k := range []string{key, codePrefix + "6-single-use.json"} {
+		if _, err := store.StatContext(ctx, k); !errors.Is(err, storage.ErrNotExist) {
+			t.Errorf("%s is still there after get: %v", k, err)
+		}
+	}
+
+	// An expired code is removed without a download
+	shareTestCode(t, store, "7-expired", shareCode{Key: "reports/q3.txt", Name: "q3.txt", SingleUse: true, Expires: time.Now().Add(-time.Minute)})
+	if err := runGetCode(t, "7-expired", "expired.txt"); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Fatalf("get of an expired code = %v", err)
+	}
+	if _, err := store.StatContext(ctx, codePrefix+"7-expired.json"); !errors.Is(err, storage.ErrNotExist) {
+		t.Fatalf("expired code is still there: %v", err)
+	}
+}
+
+func TestGetOnceInterrupted(t *testing.T) {
+	ctx := context.Background()
+	store := useMemoryBackend(t)
+	store.UploadStream(ctx, strings.NewReader("report"), 6, "reports/q3.txt")
+	shareTestCode(t, store, "8-single-use", shareCode{Key: "reports/q3.txt", Name: "q3.txt", SingleUse: true})
+
+	// Ctrl-C once the code was taken out of the bucket, as the file is fetched
+	codeKey := codePrefix + "8-single-use.json"
+	getCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cmd := &cobra.Command{}
+	cmd.SetContext(storage.WithProgress(getCtx, func(p storage.Progress) {
+		if p.Key == codeKey {
+			cancel()
+		}
+	}))
+	if err := runGet(cmd, []string{"8-single-use"}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("interrupted get = %v", err)
+	}
+	if _, err := store.StatContext(ctx, codeKey); err != nil {
+		t.Fatalf("interrupted get used up the code: %v", err)
+	}
+	if err := runGetCode(t, "8-single-use", "q3.txt"); err != nil {
+		t.Fatalf("get after the interruption: %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"tincan/pkg/storage"
+)
+
+var (
+	shareExpires time.Duration
+	shareUpload  bool
+)
+
+var shareCmd = &cobra.Command{
+	Use:   "share <key>",
+	Short: "Print a link that downloads a file without credentials",
+	Long: `Print a presigned URL that anyone can use to download a file from the
+bucket until it expires, without AWS keys or tincan.
+
+With --upload, the URL instead accepts an HTTP PUT of a file to key, so a
+colleague without access to the bucket can drop a file into it:
+
+  curl -T report.pdf -H 'If-None-Match: *' '<url>'
+
+Files uploaded with --once cannot be shared, as a link would let them be
+downloaded any number of times.
+
+Upload links never replace a file: key must not exist yet, and the link
+stops accepting uploads once one went through.
+
+Links are signed with your credentials and stop working when they expire or
+the credentials are revoked. S3 allows at most 7 days.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runShare,
+}
+
+func init() {
+	shareCmd.Flags().DurationVar(&shareExpires, "expires", 24*time.Hour, "how long the link stays valid")
+	shareCmd.Flags().BoolVar(&shareUpload, "upload", false, "create a link for uploading to key instead")
+}
+
+func runShare(cmd *cobra.Command, args []string) error {
+	key := args[0]
+
+	client, err := newStorage()
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+	presigner, ok := client.(storage.Presigner)
+	if !ok {
+		return fmt.Errorf("share links are not supported by this storage backend")
+	}
+	ctx := cmd.Context()
+
+	var url string
+	if shareUpload {
+		if _, err := client.StatContext(ctx, key); err == nil {
+			return fmt.Errorf("%s already exists and an upload link cannot replace it", key)
+		} else if !errors.Is(err, storage.ErrNotExist) {
+			return fmt.Errorf("failed to check %s: %w", key, err)
+		}
+		if url, err = presigner.PresignPut(ctx, key, shareExpires); err != nil {
+			return fmt.Errorf("failed to create upload link: %w", err)
+		}
+	} else {
+		info, err := client.StatContext(ctx, key)
+		if err != nil {
+			return fmt.Errorf("failed to find %s: %w", key, err)
+		}
+		// A link would skip the claim and the delete, so the file could be
+		// read any number of times
+		if info.BurnAfterReading() {
+			return fmt.Errorf("%s is deleted by its first download and cannot be shared with a link", key)
+		}
+		if info.Encrypted() || info.Compression() != "" {
+			fmt.Printf("Warning: %s is stored encrypted or compressed and the link downloads it as stored\n", key)
+		}
+		if url, err = presigner.PresignGet(ctx, key, shareExpires); err != nil {
+			return fmt.Errorf("failed to create share link: %w", err)
+		}
+	}
+
+	fmt.Println(url)
+	fmt.Printf("\nValid until %s\n", time.Now().Add(shareExpires).Format("2006-01-02 15:04"))
+	if shareUpload {
+		fmt.Printf("Upload with: curl -T <file> -H 'If-None-Match: *' '%s'\n", url)
+	}
+	return nil
+}
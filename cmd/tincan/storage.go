@@ -0,0 +1,56 @@
+package main
+
+import (
+	"sync"
+
+	"tincan/internal/config"
+	"tincan/pkg/s3client"
+	"tincan/pkg/storage"
+	"tincan/pkg/storage/local"
+	"tincan/pkg/storage/memory"
+)
+
+// memoryStore is shared so every web request sees the same in-memory bucket.
+var memoryStore = sync.OnceValue(func() *memory.Storage { return memory.New() })
+
+// newStorage opens the backend selected by the "backend" config setting,
+// limited to the configured bandwidth.
+func newStorage() (storage.Storage, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	rate, err := storage.ParseRate(cfg.LimitRate)
+	if err != nil {
+		return nil, err
+	}
+	if limitRate.value != "" {
+		rate = limitRate.rate
+	}
+	limiter := transferLimiter()
+	limiter.SetRate(rate)
+
+	var store storage.Storage
+	switch cfg.Backend {
+	case "local":
+		var s *local.Storage
+		if s, err = local.New(cfg.LocalPath); err == nil {
+			s.Limiter = limiter
+			store = s
+		}
+	case "memory":
+		store = memoryStore()
+	default:
+		var c *s3client.Client
+		if c, err = s3client.New(cfg); err == nil {
+			c.Limiter = limiter
+			store = c
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"tincan/pkg/encrypt"
+	"tincan/pkg/storage"
+)
+
+// remoteMarker marks the bucket side of a sync when it is ambiguous.
+const remoteMarker = "remote:"
+
+var (
+	syncDelete   bool
+	syncDryRun   bool
+	syncChecksum bool
+	syncInclude  []string
+	syncExclude  []string
+	syncJobs     int
+	syncSchedule []string
+)
+
+var syncCmd = &cobra.Command{
+	Use:   "sync <source> <destination>",
+	Short: "Make a bucket folder match a local directory, or the reverse",
+	Long: `Make the destination match the source, transferring only files that are
+new or changed. One side is a local directory and the other a folder in the
+bucket:
+
+  tincan sync ./work shared/work     upload local changes
+  tincan sync shared/work ./work     download remote changes
+
+The local side is the one that exists as a directory; write the bucket side
+as remote:shared/work when both or neither do.
+
+Files are compared by size and modification time, or by SHA-256 checksum with
+--checksum. --delete also removes destination files that are not in the
+source. --include, --exclude and .tincanignore work as for upload. Local
+files that watch leaves alone, such as hidden files and .partial files of
+interrupted downloads, are not synced either.
+
+--limit-schedule changes the bandwidth limit at times of day, as for watch.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runSync,
+}
+
+func init() {
+	syncCmd.Flags().BoolVar(&syncDelete, "delete", false, "delete destination files that are not in the source")
+	syncCmd.Flags().BoolVarP(&syncDryRun, "dry-run", "n", false, "show what would be transferred without changing anything")
+	syncCmd.Flags().BoolVarP(&syncChecksum, "checksum", "c", false, "compare file contents by SHA-256 instead of modification time")
+	syncCmd.Flags().StringSliceVar(&syncInclude, "include", nil, "only sync files matching this glob (repeatable)")
+	syncCmd.Flags().StringSliceVar(&syncExclude, "exclude", nil, "skip files matching this glob (repeatable)")
+	syncCmd.Flags().IntVarP(&syncJobs, "jobs", "j", 4, "files to transfer in parallel")
+	syncCmd.Flags().StringSliceVar(&syncSchedule, "limit-schedule", nil, "bandwidth limit at times of day, e.g. 08:00-19:00=1MB/s (repeatable, overrides limit_schedule)")
+}
+
+// syncPlan lists what a sync has to do.
+type syncPlan struct {
+	upload    bool // direction: local to bucket
+	transfer  []dirEntry
+	remove    []dirEntry
+	unchanged []dirEntry
+}
+
+func runSync(cmd *cobra.Command, args []string) error {
+	dir, prefix, upload, err := syncSides(args[0], args[1])
+	if err != nil {
+		return err
+	}
+
+	filter, err := newPathFilter(syncInclude, syncExclude)
+	if err != nil {
+		return err
+	}
+	if err := filter.loadIgnoreFile(dir); err != nil {
+		return err
+	}
+	schedule, err := loadSchedule(syncSchedule)
+	if err != nil {
+		return err
+	}
+
+	client, err := newStorage()
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+	followSchedule(cmd.Context(), schedule)
+
+	plan, err := planSync(cmd.Context(), client, dir, prefix, filter, upload)
+	if err != nil {
+		return err
+	}
+
+	if upload {
+		fmt.Printf("Syncing %s to %s\n", dir, remoteMarker+prefix)
+	} else {
+		fmt.Printf("Syncing %s to %s\n", remoteMarker+prefix, dir)
+	}
+	if syncDryRun {
+		verb, del := "upload", "delete remote"
+		if !upload {
+			verb, del = "download", "delete local"
+		}
+		for _, e := range plan.transfer {
+			fmt.Printf("  would %s %s (%s)\n", verb, e.key, formatBytes(e.size))
+		}
+		for _, e := range plan.remove {
+			fmt.Printf("  would %s %s\n", del, e.key)
+		}
+		printSyncSummary(plan, transferResult{files: len(plan.transfer), bytes: entriesSize(plan.transfer)}, transferResult{files: len(plan.remove), bytes: entriesSize(plan.remove)})
+		return nil
+	}
+
+	opts := transferOptions{interactive: true}
+	var transfer func(context.Context, dirEntry) error
+	verb := "uploaded"
+	if upload {
+		transfer = func(ctx context.Context, e dirEntry) error {
+			return uploadFile(ctx, client, e.local, e.key, opts)
+		}
+	} else {
+		verb = "downloaded"
+		for _, e := range plan.transfer {
+			if e.encryption == encrypt.MethodPassphrase {
+				if opts.secret, err = opts.passphrase(false); err != nil {
+					return err
+				}
+				break
+			}
+		}
+		transfer = func(ctx context.Context, e dirEntry) error {
+			if err := os.MkdirAll(filepath.Dir(e.local), 0o755); err != nil {
+				return err
+			}
+			if err := downloadFile(ctx, client, e.key, e.local, opts); err != nil {
+				return err
+			}
+			return os.Chtimes(e.local, e.modTime, e.modTime)
+		}
+	}
+
+	transferred, err := transferAll(cmd.Context(), plan.transfer, syncJobs, verb, transfer)
+	if err != nil {
+		return err
+	}
+
+	// Only delete once everything else is in place
+	var deleted transferResult
+	if transferred.failed == 0 {
+		deleted, err = transferAll(cmd.Context(), plan.remove, syncJobs, "deleted", func(ctx context.Context, e dirEntry) error {
+			if upload {
+				return client.DeleteContext(ctx, e.key)
+			}
+			return os.Remove(e.local)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	printSyncSummary(plan, transferred, deleted)
+	if failed := transferred.failed + deleted.failed; failed > 0 {
+		return fmt.Errorf("%d files failed to sync", failed)
+	}
+	return nil
+}
+
+// syncSides works out which argument is the local directory and which the
+// bucket folder, returning the folder as a key prefix.
+func syncSides(src, dst string) (dir, prefix string, upload bool, err error) {
+	srcRemote, dstRemote := strings.HasPrefix(src, remoteMarker), strings.HasPrefix(dst, remoteMarker)
+	switch {
+	case srcRemote && dstRemote:
+		return "", "", false, fmt.Errorf("one side of a sync must be a local directory")
+	case srcRemote:
+		dir, prefix, upload = dst, src, false
+	case dstRemote:
+		dir, prefix, upload = src, dst, true
+	default:
+		srcDir, dstDir := isDir(src), isDir(dst)
+		if srcDir == dstDir {
+			return "", "", false, fmt.Errorf("cannot tell which of %q and %q is the local directory; write the bucket side as %s<folder>", src, dst, remoteMarker)
+		}
+		if srcDir {
+			dir, prefix, upload = src, dst, true
+		} else {
+			dir, prefix, upload = dst, src, false
+		}
+	}
+
+	prefix = strings.TrimPrefix(strings.TrimPrefix(prefix, remoteMarker), "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	if upload && !isDir(dir) {
+		return "", "", false, fmt.Errorf("%s is not a directory", dir)
+	}
+	return dir, prefix, upload, nil
+}
+
+func isDir(p string) bool {
+	info, err := os.Stat(p)
+	return err == nil && info.IsDir()
+}
+
+// planSync compares the local directory with the files below prefix.
+func planSync(ctx context.Context, store storage.Storage, dir, prefix string, filter *pathFilter, upload bool) (*syncPlan, error) {
+	var local []dirEntry
+	if isDir(dir) {
+		entries, err := collectUploads(dir, prefix, filter)
+		if err != nil {
+			return nil, err
+		}
+		// Files still being written, such as the .partial files of
+		// interrupted downloads, are neither sent nor deleted
+		for _, e := range entries {
+			if !partialFile(filepath.Base(e.local)) {
+				local = append(local, e)
+			}
+		}
+	}
+
+	remote := make(map[string]storage.FileInfo)
+	err := storage.Walk(ctx, store, prefix, func(f storage.FileInfo) error {
+		if !strings.HasSuffix(f.Name, "/") && filter.match(strings.TrimPrefix(f.Name, prefix)) {
+			remote[f.Name] = f
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+
+	// Metadata is needed for files present on both sides, and for the
+	// modification times of downloads
+	var stat []storage.FileInfo
+	inLocal := make(map[string]bool, len(local))
+	for _, l := range local {
+		inLocal[l.key] = true
+	}
+	for key, f := range remote {
+		if !upload || inLocal[key] {
+			stat = append(stat, f)
+		}
+	}
+	fillMetadata(ctx, store, stat)
+	for _, f := range stat {
+		remote[f.Name] = f
+	}
+
+	plan := &syncPlan{upload: upload}
+	for _, l := range local {
+		r, ok := remote[l.key]
+		if !ok {
+			if upload {
+				plan.transfer = append(plan.transfer, l)
+			} else if syncDelete {
+				plan.remove = append(plan.remove, l)
+			}
+			continue
+		}
+		changed, err := syncChanged(l, r, upload)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case !changed:
+			plan.unchanged = append(plan.unchanged, l)
+		case upload:
+			plan.transfer = append(plan.transfer, l)
+		default:
+			plan.transfer = append(plan.transfer, remoteEntry(r, l.local))
+		}
+	}
+	for key, r := range remote {
+		if inLocal[key] {
+			continue
+		}
+		if !upload {
+			rel := filepath.FromSlash(strings.TrimPrefix(key, prefix))
+			if !filepath.IsLocal(rel) {
+				return nil, fmt.Errorf("refusing to download %q outside of %q", key, dir)
+			}
+			plan.transfer = append(plan.transfer, remoteEntry(r, filepath.Join(dir, rel)))
+		} else if syncDelete {
+			plan.remove = append(plan.remove, remoteEntry(r, ""))
+		}
+	}
+
+	byKey := func(entries []dirEntry) func(i, j int) bool {
+		return func(i, j int) bool { return entries[i].key < entries[j].key }
+	}
+	sort.Slice(plan.transfer, byKey(plan.transfer))
+	sort.Slice(plan.remove, byKey(plan.remove))
+
+	return plan, nil
+}
+
+func remoteEntry(f storage.FileInfo, local string) dirEntry {
+	return dirEntry{
+		key:        f.Name,
+		local:      local,
+		size:       f.Size,
+		modTime:    f.ModTime(),
+		encryption: f.Metadata[storage.MetaEncryption],
+	}
+}
+
+// syncChanged reports whether the local file l and the stored file r differ.
+func syncChanged(l dirEntry, r storage.FileInfo, upload bool) (bool, error) {
+	// The stored size of compressed or encrypted files says nothing about
+	// the original
+	if !r.Encrypted() && r.Compression() == "" && l.size != r.Size {
+		return true, nil
+	}
+
+	// Files stored without a checksum are compared by modification time
+	if want := r.SHA256(); syncChecksum && want != "" {
+		sum, err := fileSHA256(l.local)
+		if err != nil {
+			return false, err
+		}
+		return sum != want, nil
+	}
+
+	if _, ok := r.Metadata[storage.MetaModTime]; ok {
+		return !l.modTime.Truncate(time.Second).Equal(r.ModTime().Truncate(time.Second)), nil
+	}
+	// Without a recorded modification time only a newer source is a change
+	if upload {
+		return l.modTime.After(r.LastModified), nil
+	}
+	return r.LastModified.After(l.modTime), nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("unable to read %q: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func entriesSize(entries []dirEntry) int64 {
+	var size int64
+	for _, e := range entries {
+		size += e.size
+	}
+	return size
+}
+
+func printSyncSummary(plan *syncPlan, transferred, deleted transferResult) {
+	verb := "Uploaded"
+	if !plan.upload {
+		verb = "Downloaded"
+	}
+	deletedVerb := "Deleted"
+	if syncDryRun {
+		verb, deletedVerb = "To "+strings.ToLower(verb[:len(verb)-2]), "To delete"
+	}
+
+	fmt.Println()
+	fmt.Printf("  %-12s %8s %12s\n", "", "Files", "Size")
+	fmt.Printf("  %-12s %8d %12s\n", verb, transferred.files, formatBytes(transferred.bytes))
+	fmt.Printf("  %-12s %8d %12s\n", deletedVerb, deleted.files, formatBytes(deleted.bytes))
+	fmt.Printf("  %-12s %8d %12s\n", "Unchanged", len(plan.unchanged), formatBytes(entriesSize(plan.unchanged)))
+	if failed := transferred.failed + deleted.failed; failed > 0 {
+		fmt.Printf("  %-12s %8d\n", "Failed", failed)
+	}
+	if !syncDryRun && transferred.failed > 0 && len(plan.remove) > 0 {
+		fmt.Printf("  %-12s %8d  (skipped because transfers failed)\n", "Not deleted", len(plan.remove))
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"tincan/pkg/storage"
+)
+
+// syncTime is the modification time of the files the sync tests start with.
+var syncTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func setSyncFlags(t *testing.T, del, checksum, dryRun bool) {
+	t.Helper()
+	syncDelete, syncChecksum, syncDryRun = del, checksum, dryRun
+	t.Cleanup(func() { syncDelete, syncChecksum, syncDryRun = false, false, false })
+}
+
+// putRemote stores content as key with the modification time recorded the
+// way uploadFile records it, plus metadata.
+func putRemote(t *testing.T, store storage.Storage, key, content string, modTime time.Time, metadata map[string]string) {
+	t.Helper()
+	m := map[string]string{storage.MetaModTime: modTime.Format(time.RFC3339Nano)}
+	for k, v := range metadata {
+		m[k] = v
+	}
+	err := store.UploadStream(context.Background(), strings.NewReader(content), int64(len(content)), key, storage.WithMetadata(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func putLocal(t *testing.T, dir, name, content string, modTime time.Time) {
+	t.Helper()
+	path := writeTestFile(t, dir, name, []byte(content))
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func keys(entries []dirEntry) []string {
+	var keys []string
+	for _, e := range entries {
+		keys = append(keys, e.key)
+	}
+	return keys
+}
+
+func plan(t *testing.T, store storage.Storage, dir, prefix string, upload bool) *syncPlan {
+	t.Helper()
+	filter, _ := newPathFilter(nil, nil)
+	p, err := planSync(context.Background(), store, dir, prefix, filter, upload)
+	if err != nil {
+		t.Fatalf("planSync: %v", err)
+	}
+	return p
+}
+
+func checkPlan(t *testing.T, p *syncPlan, transfer, remove, unchanged []string) {
+	t.Helper()
+	unchangedKeys := keys(p.unchanged)
+	slices.Sort(unchangedKeys)
+	if !slices.Equal(keys(p.transfer), transfer) || !slices.Equal(keys(p.remove), remove) || !slices.Equal(unchangedKeys, unchanged) {
+		t.Fatalf("plan transfers %v, removes %v, leaves %v; want %v, %v, %v",
+			keys(p.transfer), keys(p.remove), unchangedKeys, transfer, remove, unchanged)
+	}
+}
+
+func TestSyncSides(t *testing.T) {
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing")
+	for _, tc := range []struct {
+		src, dst string
+		prefix   string
+		upload   bool
+		fails    bool
+	}{
+		{dir, "shared/work", "shared/work/", true, false},
+		{"shared/work/", dir, "shared/work/", false, false},
+		{"remote:/shared", missing, "shared/", false, false},
+		{dir, "remote:" + dir, strings.TrimPrefix(dir, "/") + "/", true, false},
+		{missing, "remote:x", "", false, true},
+		{"remote:a", "remote:b", "", false, true},
+		{dir, dir, "", false, true},
+		{"a", "b", "", false, true},
+	} {
+		_, prefix, upload, err := syncSides(tc.src, tc.dst)
+		if tc.fails {
+			if err == nil {
+				t.Errorf("syncSides(%q, %q) succeeded", tc.src, tc.dst)
+			}
+			continue
+		}
+		if err != nil || prefix != tc.prefix || upload != tc.upload {
+			t.Errorf("syncSides(%q, %q) = %q, %v, %v; want %q, %v", tc.src, tc.dst, prefix, upload, err, tc.prefix, tc.upload)
+		}
+	}
+}
+
+func TestPlanSyncUpload(t *testing.T) {
+	store := useMemoryBackend(t)
+	dir := t.TempDir()
+	putLocal(t, dir, "new.txt", "new", syncTime)
+	putLocal(t, dir, "same.txt", "same", syncTime)
+	putLocal(t, dir, "sub/touched.txt", "touched", syncTime.Add(time.Hour))
+	putLocal(t, dir, "grown.txt", "grown longer", syncTime)
+	putRemote(t, store, "up/same.txt", "same", syncTime, nil)
+	putRemote(t, store, "up/sub/touched.txt", "touched", syncTime, nil)
+	putRemote(t, store, "up/grown.txt", "grown", syncTime, nil)
+	putRemote(t, store, "up/gone.txt", "gone", syncTime, nil)
+	putRemote(t, store, "other/new.txt", "elsewhere", syncTime, nil)
+
+	setSyncFlags(t, false, false, false)
+	p := plan(t, store, dir, "up/", true)
+	checkPlan(t, p, []string{"up/grown.txt", "up/new.txt", "up/sub/touched.txt"}, nil, []string{"up/same.txt"})
+
+	// The remote side is never newer in an upload: only its own files go
+	setSyncFlags(t, true, false, false)
+	p = plan(t, store, dir, "up/", true)
+	checkPlan(t, p, []string{"up/grown.txt", "up/new.txt", "up/sub/touched.txt"}, []string{"up/gone.txt"}, []string{"up/same.txt"})
+	if p.transfer[1].local != filepath.Join(dir, "new.txt") {
+		t.Errorf("upload of up/new.txt reads %s", p.transfer[1].local)
+	}
+}
+
+func TestPlanSyncDownload(t *testing.T) {
+	store := useMemoryBackend(t)
+	dir := t.TempDir()
+	putLocal(t, dir, "same.txt", "same", syncTime)
+	putLocal(t, dir, "old.txt", "old", syncTime)
+	putLocal(t, dir, "local-only.txt", "mine", syncTime)
+	putRemote(t, store, "down/same.txt", "same", syncTime, nil)
+	putRemote(t, store, "down/old.txt", "new", syncTime.Add(time.Hour), nil)
+	putRemote(t, store, "down/sub/fresh.txt", "fresh", syncTime, nil)
+
+	setSyncFlags(t, false, false, false)
+	p := plan(t, store, dir, "down/", false)
+	checkPlan(t, p, []string{"down/old.txt", "down/sub/fresh.txt"}, nil, []string{"down/same.txt"})
+	fresh := p.transfer[1]
+	if fresh.local != filepath.Join(dir, "sub", "fresh.txt") || !fresh.modTime.Equal(syncTime) {
+		t.Errorf("download of down/sub/fresh.txt goes to %s with time %v", fresh.local, fresh.modTime)
+	}
+
+	setSyncFlags(t, true, false, false)
+	p = plan(t, store, dir, "down/", false)
+	checkPlan(t, p, []string{"down/old.txt", "down/sub/fresh.txt"}, []string{"down/local-only.txt"}, []string{"down/same.txt"})
+
+	// A missing directory is created by the download
+	p = plan(t, store, filepath.Join(dir, "missing"), "down/", false)
+	checkPlan(t, p, []string{"down/old.txt", "down/same.txt", "down/sub/fresh.txt"}, nil, nil)
+
+	// Keys that would land outside the directory are refused
+	putRemote(t, store, "down/../escape.txt", "x", syncTime, nil)
+	filter, _ := newPathFilter(nil, nil)
+	if _, err := planSync(context.Background(), store, dir, "down/", filter, false); err == nil {
+		t.Fatal("planSync accepted a key outside the directory")
+	}
+}
+
+func TestSyncWithoutModTime(t *testing.T) {
+	store := useMemoryBackend(t)
+	dir := t.TempDir()
+	putLocal(t, dir, "a.txt", "aaa", syncTime)
+	store.UploadStream(context.Background(), strings.NewReader("aaa"), 3, "plain/a.txt")
+	setSyncFlags(t, false, false, false)
+
+	// Stored just now, so newer than the local file
+	checkPlan(t, plan(t, store, dir, "plain/", true), nil, nil, []string{"plain/a.txt"})
+	checkPlan(t, plan(t, store, dir, "plain/", false), []string{"plain/a.txt"}, nil, nil)
+}
+
+func TestSyncChecksum(t *testing.T) {
+	store := useMemoryBackend(t)
+	dir := t.TempDir()
+	putLocal(t, dir, "edited.txt", "same size, other bytes", syncTime)
+	putLocal(t, dir, "same.txt", "same content", syncTime)
+	putLocal(t, dir, "packed.txt", "packed content", syncTime)
+	putLocal(t, dir, "packed-old.txt", "packed content", syncTime)
+	putRemote(t, store, "sum/edited.txt", "same size, OTHER bytes", syncTime, nil)
+	putRemote(t, store, "sum/same.txt", "same content", syncTime.Add(time.Hour), nil)
+	// Compressed without the checksum of the original, as uploadStream does
+	compressed := map[string]string{storage.MetaCompression: "zstd"}
+	putRemote(t, store, "sum/packed.txt", "zstd bytes", syncTime, compressed)
+	putRemote(t, store, "sum/packed-old.txt", "zstd bytes", syncTime.Add(-time.Hour), compressed)
+
+	setSyncFlags(t, false, false, false)
+	checkPlan(t, plan(t, store, dir, "sum/", true),
+		[]string{"sum/packed-old.txt", "sum/same.txt"}, nil, []string{"sum/edited.txt", "sum/packed.txt"})
+
+	// Without a stored checksum --checksum falls back to the times
+	setSyncFlags(t, false, true, false)
+	checkPlan(t, plan(t, store, dir, "sum/", true),
+		[]string{"sum/edited.txt", "sum/packed-old.txt"}, nil, []string{"sum/packed.txt", "sum/same.txt"})
+}
+
+func TestPlanSyncPartial(t *testing.T) {
+	store := useMemoryBackend(t)
+	dir := t.TempDir()
+	putLocal(t, dir, "a.txt", "alpha", syncTime)
+	putLocal(t, dir, "big.iso.partial", "half of it", syncTime)
+	putLocal(t, dir, "sub/.notes.swp", "swap", syncTime)
+	putLocal(t, dir, "sub/b.txt~", "backup", syncTime)
+
+	// Left by an interrupted download, they are not uploaded
+	setSyncFlags(t, true, false, false)
+	checkPlan(t, plan(t, store, dir, "p/", true), []string{"p/a.txt"}, nil, nil)
+
+	// Nor deleted as not being in the bucket
+	putRemote(t, store, "p/a.txt", "alpha", syncTime, nil)
+	putRemote(t, store, "p/big.iso", "all of it", syncTime, nil)
+	checkPlan(t, plan(t, store, dir, "p/", false), []string{"p/big.iso"}, nil, []string{"p/a.txt"})
+}
+
+func runSyncArgs(t *testing.T, args ...string) error {
+	t.Helper()
+	cmd := &cobra.Command{}
+	cmd.SetContext(context.Background())
+	return runSync(cmd, args)
+}
+
+func TestSyncRun(t *testing.T) {
+	store := useMemoryBackend(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+	putLocal(t, dir, "a.txt", "alpha", syncTime)
+	putLocal(t, dir, "sub/b.txt", "beta", syncTime)
+	putRemote(t, store, "run/stale.txt", "stale", syncTime, nil)
+
+	// A dry run changes nothing on either side
+	setSyncFlags(t, true, false, true)
+	if err := runSyncArgs(t, dir, "remote:run"); err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	for key, exists := range map[string]bool{"run/a.txt": false, "run/sub/b.txt": false, "run/stale.txt": true} {
+		if _, err := store.StatContext(ctx, key); (err == nil) != exists {
+			t.Errorf("after the dry run, %s exists: %v", key, err == nil)
+		}
+	}
+
+	setSyncFlags(t, true, false, false)
+	if err := runSyncArgs(t, dir, "remote:run"); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	for key, exists := range map[string]bool{"run/a.txt": true, "run/sub/b.txt": true, "run/stale.txt": false} {
+		if _, err := store.StatContext(ctx, key); (err == nil) != exists {
+			t.Errorf("after the sync, %s exists: %v", key, err == nil)
+		}
+	}
+	checkPlan(t, plan(t, store, dir, "run/", true), nil, nil, []string{"run/a.txt", "run/sub/b.txt"})
+
+	// And back, into a directory with a file of its own
+	back := t.TempDir()
+	putLocal(t, back, "extra.txt", "extra", syncTime)
+	setSyncFlags(t, true, false, true)
+	if err := runSyncArgs(t, "remote:run", back); err != nil {
+		t.Fatalf("dry run of the download: %v", err)
+	}
+	if names := dirNames(t, back); !slices.Equal(names, []string{"extra.txt"}) {
+		t.Fatalf("dry run of the download changed the directory to %v", names)
+	}
+
+	setSyncFlags(t, true, false, false)
+	if err := runSyncArgs(t, "remote:run", back); err != nil {
+		t.Fatalf("download sync: %v", err)
+	}
+	if names := dirNames(t, back); !slices.Equal(names, []string{"a.txt", "sub"}) {
+		t.Fatalf("download sync left %v", names)
+	}
+	info, err := os.Stat(filepath.Join(back, "sub", "b.txt"))
+	if err != nil || !info.ModTime().Equal(syncTime) {
+		t.Fatalf("downloaded file has time %v, want %v (%v)", info.ModTime(), syncTime, err)
+	}
+}
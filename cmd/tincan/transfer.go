@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+	"tincan/internal/config"
+	"tincan/pkg/compress"
+	"tincan/pkg/encrypt"
+	"tincan/pkg/storage"
+)
+
+// transferOptions controls how file content is transformed on its way to
+// and from the bucket.
+type transferOptions struct {
+	compress     string        // codec to compress with before uploading
+	encrypt      bool          // encrypt before uploading
+	recipient    string        // public key to encrypt to; a passphrase is used when empty
+	identityFile string        // secret key for files encrypted to a public key
+	interactive  bool          // prompt for a passphrase missing from TINCAN_PASSPHRASE
+	secret       string        // passphrase already entered, reused for every file
+	ttl          time.Duration // delete the file this long after the upload
+	metadata     map[string]string
+}
+
+// uploadFile uploads filePath as key, compressing and then encrypting it on
+// the way if requested. The codecs used, the file's modification time and
+// its expiry are recorded in the object metadata, along with opts.metadata
+// and, for transformed files, the checksum of the original content. Only
+// untransformed uploads can be resumed.
+func uploadFile(ctx context.Context, store storage.Storage, filePath, key string, opts transferOptions) error {
+	src, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("unable to open file %q: %w", filePath, err)
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("unable to stat file %q: %w", filePath, err)
+	}
+	metadata := maps.Clone(opts.metadata)
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
+	metadata[storage.MetaModTime] = info.ModTime().UTC().Format(time.RFC3339Nano)
+
+	uploadOpts := expiryOptions(opts.ttl)
+
+	if !opts.encrypt && opts.compress == "" {
+		return store.UploadContext(ctx, filePath, key, append(uploadOpts, storage.WithMetadata(metadata))...)
+	}
+
+	// The checksum of the original goes into the metadata, which is sent
+	// before the content, so it takes a pass over the file of its own
+	hash := sha256.New()
+	if _, err := io.Copy(hash, src); err != nil {
+		return fmt.Errorf("unable to read file %q: %w", filePath, err)
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("unable to read file %q: %w", filePath, err)
+	}
+	sum := hash.Sum(nil)
+	metadata[storage.MetaSHA256] = hex.EncodeToString(sum)
+
+	opts.metadata = metadata
+	r := &unchangedReader{r: src, hash: sha256.New(), sum: sum, name: filePath}
+	return uploadStream(ctx, store, r, -1, key, opts)
+}
+
+// unchangedReader fails at the end of a file whose content no longer has
+// the checksum taken before, so that the upload is abandoned instead of
+// recording the wrong one.
+type unchangedReader struct {
+	r    io.Reader
+	hash hash.Hash
+	sum  []byte
+	name string
+}
+
+func (r *unchangedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF && !bytes.Equal(r.hash.Sum(nil), r.sum) {
+		err = fmt.Errorf("file %q changed during the upload", r.name)
+	}
+	return n, err
+}
+
+// uploadStream uploads what is read from r as key, like uploadFile. size
+// is the length of r, or -1 if it is not known. Transformed content is
+// uploaded as it is produced, so the checksum of the original is only
+// recorded if opts.metadata carries it.
+func uploadStream(ctx context.Context, store storage.Storage, r io.Reader, size int64, key string, opts transferOptions) error {
+	metadata := maps.Clone(opts.metadata)
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
+	uploadOpts := expiryOptions(opts.ttl)
+
+	if !opts.encrypt && opts.compress == "" {
+		return store.UploadStream(ctx, r, size, key, append(uploadOpts, storage.WithMetadata(metadata))...)
+	}
+
+	encode, err := opts.encoder(metadata)
+	if err != nil {
+		return err
+	}
+
+	// The encoders write as they are created, so only once the upload reads
+	pr, pw := io.Pipe()
+	go func() {
+		w, stages, err := encode(pw)
+		if err == nil {
+			_, err = io.Copy(w, r)
+		}
+		for _, stage := range stages {
+			if err != nil {
+				break
+			}
+			err = stage.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	defer pr.Close()
+
+	return store.UploadStream(ctx, pr, -1, key, append(uploadOpts, storage.WithMetadata(metadata))...)
+}
+
+// encoder records in metadata the codecs opts asks for, and returns a
+// function that stacks their writers on w: what is written to the result is
+// compressed and then encrypted. The stages must be closed in order to
+// flush each into the next.
+func (o transferOptions) encoder(metadata map[string]string) (func(w io.Writer) (io.Writer, []io.Closer, error), error) {
+	var recipient encrypt.Recipient
+	if o.encrypt {
+		var err error
+		if recipient, err = o.encryptionRecipient(); err != nil {
+			return nil, err
+		}
+		metadata[storage.MetaEncryption] = recipient.Method()
+	}
+	if o.compress != "" {
+		metadata[storage.MetaCompression] = o.compress
+	}
+
+	return func(w io.Writer) (io.Writer, []io.Closer, error) {
+		var stages []io.Closer
+		if recipient != nil {
+			ew, err := encrypt.NewWriter(w, recipient)
+			if err != nil {
+				return nil, nil, fmt.Errorf("unable to encrypt: %w", err)
+			}
+			w = ew
+			stages = append([]io.Closer{ew}, stages...)
+		}
+		if o.compress != "" {
+			cw, err := compress.NewWriter(w, o.compress)
+			if err != nil {
+				return nil, nil, err
+			}
+			w = cw
+			stages = append([]io.Closer{cw}, stages...)
+		}
+		return w, stages, nil
+	}, nil
+}
+
+// downloadFile downloads key to filePath, decrypting and decompressing it
+// according to its metadata. The backend verifies the stored bytes, and the
+// decoded content is checked against the original checksum if one was
+// recorded. Only untransformed downloads can be resumed; the others are
+// decoded as they arrive and leave nothing behind if they fail.
+func downloadFile(ctx context.Context, store storage.Storage, key, filePath string, opts transferOptions) error {
+	info, err := store.StatContext(ctx, key)
+	if err != nil {
+		return err
+	}
+	if !info.Encrypted() && info.Compression() == "" {
+		return store.DownloadContext(ctx, key, filePath)
+	}
+	return writeFileAtomic(filePath, func(w io.Writer) error {
+		return decodeStream(ctx, store, info, w, opts)
+	})
+}
+
+// downloadStream writes the content of key to w, decrypting and
+// decompressing it on the way like downloadFile. As w may already have
+// been given part of the content, a checksum mismatch can only be reported
+// at the end.
+func downloadStream(ctx context.Context, store storage.Storage, key string, w io.Writer, opts transferOptions) error {
+	info, err := store.StatContext(ctx, key)
+	if err != nil {
+		return err
+	}
+	if !info.Encrypted() && info.Compression() == "" {
+		return store.DownloadStream(ctx, key, w)
+	}
+	return decodeStream(ctx, store, info, w, opts)
+}
+
+// decodeStream downloads the transformed file info to w, decoding it on the
+// way and checking the result against the original checksum.
+func decodeStream(ctx context.Context, store storage.Storage, info *storage.FileInfo, w io.Writer, opts transferOptions) error {
+	key := info.Name
+
+	// Look up the key first, so that a missing one fails before the
+	// download, which may delete a burn-after-reading file
+	var identity encrypt.Identity
+	var err error
+	if info.Encrypted() {
+		if identity, err = opts.decryptionIdentity(info.Metadata[storage.MetaEncryption]); err != nil {
+			return fmt.Errorf("%q is encrypted: %w", key, err)
+		}
+	}
+
+	pr, pw := io.Pipe()
+	stored := make(chan error, 1)
+	go func() {
+		err := store.DownloadStream(ctx, key, pw)
+		pw.CloseWithError(err)
+		stored <- err
+	}()
+	// The stored error, if any, explains a failure to decode better
+	fail := func(err error) error {
+		pr.CloseWithError(err)
+		if storeErr := <-stored; storeErr != nil {
+			return storeErr
+		}
+		return err
+	}
+
+	var r io.Reader = pr
+	if info.Encrypted() {
+		if r, err = encrypt.NewReader(r, identity); err != nil {
+			return fail(fmt.Errorf("unable to decrypt %q: %w", key, err))
+		}
+	}
+	if codec := info.Compression(); codec != "" {
+		cr, err := compress.NewReader(r, codec)
+		if err != nil {
+			return fail(fmt.Errorf("unable to decompress %q: %w", key, err))
+		}
+		defer cr.Close()
+		r = cr
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(w, io.TeeReader(r, hash)); err != nil {
+		return fail(fmt.Errorf("unable to download %q: %w", key, err))
+	}
+	// Let the backend see the end of the stored content, and verify it
+	if _, err := io.Copy(io.Discard, pr); err != nil {
+		return fail(err)
+	}
+	if err := <-stored; err != nil {
+		return err
+	}
+	if want := info.Metadata[storage.MetaSHA256]; want != "" && hex.EncodeToString(hash.Sum(nil)) != want {
+		return fmt.Errorf("downloaded %q: %w", key, storage.ErrChecksum)
+	}
+	return nil
+}
+
+// writeFileAtomic lets write fill a temporary file next to filePath, and
+// renames it to filePath if write succeeds. Otherwise the temporary file is
+// removed and filePath is left alone.
+func writeFileAtomic(filePath string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), ".tincan-*")
+	if err != nil {
+		return fmt.Errorf("unable to create file for %q: %w", filePath, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write to file %q: %w", filePath, err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return fmt.Errorf("unable to write to file %q: %w", filePath, err)
+	}
+
+	return os.Rename(tmp.Name(), filePath)
+}
+
+func (o transferOptions) encryptionRecipient() (encrypt.Recipient, error) {
+	if o.recipient != "" {
+		return encrypt.ParseRecipient(o.recipient)
+	}
+
+	passphrase, err := o.passphrase(true)
+	if err != nil {
+		return nil, err
+	}
+	return encrypt.NewPassphrase(passphrase)
+}
+
+func (o transferOptions) decryptionIdentity(method string) (encrypt.Identity, error) {
+	switch method {
+	case encrypt.MethodPassphrase:
+		passphrase, err := o.passphrase(false)
+		if err != nil {
+			return nil, err
+		}
+		return encrypt.NewPassphrase(passphrase)
+	case encrypt.MethodX25519:
+		identityFile := o.identityFile
+		if identityFile == "" {
+			cfg, err := config.Load()
+			if err != nil {
+				return nil, err
+			}
+			identityFile = cfg.IdentityFile
+		}
+		data, err := os.ReadFile(identityFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read secret key (see tincan keygen): %w", err)
+		}
+		return encrypt.ParseIdentity(string(data))
+	default:
+		return nil, fmt.Errorf("unsupported encryption method %q", method)
+	}
+}
+
+// passphrase returns TINCAN_PASSPHRASE or asks for one on the terminal.
+// When stdin is not a terminal the passphrase is read from its first line.
+func (o transferOptions) passphrase(confirm bool) (string, error) {
+	if o.secret != "" {
+		return o.secret, nil
+	}
+	if p := os.Getenv("TINCAN_PASSPHRASE"); p != "" {
+		return p, nil
+	}
+	if !o.interactive {
+		return "", fmt.Errorf("passphrase required (set TINCAN_PASSPHRASE)")
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("unable to read passphrase: %w", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	passphrase, err := readPassword(fd, "Passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if confirm {
+		again, err := readPassword(fd, "Confirm passphrase: ")
+		if err != nil {
+			return "", err
+		}
+		if again != passphrase {
+			return "", fmt.Errorf("passphrases do not match")
+		}
+	}
+	return passphrase, nil
+}
+
+func readPassword(fd int, prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	b, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("unable to read passphrase: %w", err)
+	}
+	return string(b), nil
+}
+
+// fillMetadata stats the files whose metadata was not returned by List,
+// a few at a time.
+func fillMetadata(ctx context.Context, store storage.Storage, files []storage.FileInfo) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 8)
+	for i := range files {
+		if files[i].Metadata != nil {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(f *storage.FileInfo) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if info, err := store.StatContext(ctx, f.Name); err == nil {
+				f.Metadata = info.Metadata
+			}
+		}(&files[i])
+	}
+	wg.Wait()
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tincan/pkg/encrypt"
+	"tincan/pkg/storage"
+	"tincan/pkg/storage/memory"
+)
+
+func writeTestFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func randomData(n int) []byte {
+	data := make([]byte, n)
+	rand.Read(data)
+	return data
+}
+
+// dirNames returns the names of the entries of dir.
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestEncryptedTransfer(t *testing.T) {
+	// Nothing may be spooled to the temp dir on the way
+	tmpDir := t.TempDir()
+	t.Setenv("TMPDIR", tmpDir)
+	t.Setenv("TINCAN_PASSPHRASE", "")
+
+	ctx := context.Background()
+	store := memory.New()
+	data := randomData(300 << 10)
+	src := writeTestFile(t, t.TempDir(), "secret.bin", data)
+	opts := transferOptions{encrypt: true, secret: "hunter2"}
+
+	if err := uploadFile(ctx, store, src, "secret.bin", opts); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	info, err := store.StatContext(ctx, "secret.bin")
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if info.Metadata[storage.MetaEncryption] != encrypt.MethodPassphrase || info.Metadata[storage.MetaSHA256] == "" {
+		t.Fatalf("metadata = %v", info.Metadata)
+	}
+	var stored bytes.Buffer
+	store.DownloadStream(ctx, "secret.bin", &stored)
+	if bytes.Contains(stored.Bytes(), data[:1024]) {
+		t.Fatal("stored content is not encrypted")
+	}
+
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "secret.bin")
+	if err := downloadFile(ctx, store, "secret.bin", dst, opts); err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	if got, _ := os.ReadFile(dst); !bytes.Equal(got, data) {
+		t.Fatal("downloaded content differs")
+	}
+	if names := dirNames(t, dir); len(names) != 1 {
+		t.Fatalf("download left %v behind", names)
+	}
+	if names := dirNames(t, tmpDir); len(names) != 0 {
+		t.Fatalf("transfer left %v in the temp dir", names)
+	}
+}
+
+func TestFailedDownloadLeavesNothing(t *testing.T) {
+	t.Setenv("TINCAN_PASSPHRASE", "")
+	ctx := context.Background()
+	store := memory.New()
+	data := randomData(200 << 10)
+	src := writeTestFile(t, t.TempDir(), "file", data)
+
+	if err := uploadFile(ctx, store, src, "file", transferOptions{encrypt: true, compress: "zstd", secret: "right"}); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "file")
+	err := downloadFile(ctx, store, "file", dst, transferOptions{secret: "wrong"})
+	if !errors.Is(err, encrypt.ErrWrongKey) {
+		t.Fatalf("download with the wrong passphrase = %v, want ErrWrongKey", err)
+	}
+	if names := dirNames(t, dir); len(names) != 0 {
+		t.Fatalf("failed download left %v behind", names)
+	}
+
+	// An existing file is only replaced once the download succeeded
+	writeTestFile(t, dir, "file", []byte("old"))
+	downloadFile(ctx, store, "file", dst, transferOptions{secret: "wrong"})
+	if got, _ := os.ReadFile(dst); string(got) != "old" {
+		t.Fatalf("failed download replaced the file with %d bytes", len(got))
+	}
+
+	// A corrupted object fails without a file either
+	var stored bytes.Buffer
+	store.DownloadStream(ctx, "file", &stored)
+	corrupt := stored.Bytes()
+	corrupt[len(corrupt)/2] ^= 1
+	info, _ := store.StatContext(ctx, "file")
+	store.UploadStream(ctx, bytes.NewReader(corrupt), int64(len(corrupt)), "file", storage.WithMetadata(info.Metadata))
+
+	os.Remove(dst)
+	if err := downloadFile(ctx, store, "file", dst, transferOptions{secret: "right"}); !errors.Is(err, encrypt.ErrCorrupt) {
+		t.Fatalf("download of a corrupted object = %v, want ErrCorrupt", err)
+	}
+	if names := dirNames(t, dir); len(names) != 0 {
+		t.Fatalf("failed download left %v behind", names)
+	}
+}
+
+func TestUnchangedReader(t *testing.T) {
+	data := []byte("original content")
+	sum := sha256.Sum256(data)
+
+	r := &unchangedReader{r: bytes.NewReader(data), hash: sha256.New(), sum: sum[:], name: "f"}
+	if _, err := io.ReadAll(r); err != nil {
+		t.Fatalf("reading unchanged content: %v", err)
+	}
+
+	r = &unchangedReader{r: strings.NewReader("changed content!"), hash: sha256.New(), sum: sum[:], name: "f"}
+	if _, err := io.ReadAll(r); err == nil || !strings.Contains(err.Error(), "changed during the upload") {
+		t.Fatalf("reading changed content = %v", err)
+	}
+}
+
+func TestCompressedTransfer(t *testing.T) {
+	t.Setenv("TINCAN_PASSPHRASE", "")
+	ctx := context.Background()
+	data := []byte(strings.Repeat("a line of a log file that compresses well\n", 20000))
+	src := writeTestFile(t, t.TempDir(), "app.log", data)
+
+	for _, opts := range []transferOptions{
+		{compress: "gzip"},
+		{compress: "zstd"},
+		{compress: "zstd", encrypt: true, secret: "pw"},
+	} {
+		store := memory.New()
+		if err := uploadFile(ctx, store, src, "app.log", opts); err != nil {
+			t.Fatalf("upload with %+v: %v", opts, err)
+		}
+		info, _ := store.StatContext(ctx, "app.log")
+		if info.Compression() != opts.compress || info.Encrypted() != opts.encrypt {
+			t.Fatalf("metadata of an upload with %+v = %v", opts, info.Metadata)
+		}
+		if info.Size >= int64(len(data))/10 {
+			t.Errorf("%s stored %d bytes of %d", opts.compress, info.Size, len(data))
+		}
+
+		// Downloads decode according to the metadata alone
+		dst := filepath.Join(t.TempDir(), "app.log")
+		if err := downloadFile(ctx, store, "app.log", dst, transferOptions{secret: opts.secret}); err != nil {
+			t.Fatalf("download of %+v: %v", opts, err)
+		}
+		if got, _ := os.ReadFile(dst); !bytes.Equal(got, data) {
+			t.Fatalf("download of %+v differs", opts)
+		}
+		var buf bytes.Buffer
+		if err := downloadStream(ctx, store, "app.log", &buf, transferOptions{secret: opts.secret}); err != nil {
+			t.Fatalf("streamed download of %+v: %v", opts, err)
+		}
+		if !bytes.Equal(buf.Bytes(), data) {
+			t.Fatalf("streamed download of %+v differs", opts)
+		}
+	}
+}
+
+func TestDecodeByMetadata(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	data := []byte(strings.Repeat("0123456789", 1000))
+
+	// Without codec metadata, compressed bytes are downloaded as stored
+	src := writeTestFile(t, t.TempDir(), "plain", data)
+	if err := uploadFile(ctx, store, src, "plain", transferOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	downloadStream(ctx, store, "plain", &buf, transferOptions{})
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatal("untransformed download differs")
+	}
+
+	// A codec recorded for content that is not in it fails to decode
+	store.UploadStream(ctx, bytes.NewReader(data), int64(len(data)), "mislabeled",
+		storage.WithMetadata(map[string]string{storage.MetaCompression: "gzip"}))
+	dir := t.TempDir()
+	if err := downloadFile(ctx, store, "mislabeled", filepath.Join(dir, "mislabeled"), transferOptions{}); err == nil {
+		t.Fatal("download of mislabeled content succeeded")
+	}
+	if names := dirNames(t, dir); len(names) != 0 {
+		t.Fatalf("failed download left %v behind", names)
+	}
+
+	// The decoded content is checked against the checksum of the original
+	if err := uploadFile(ctx, store, src, "compressed", transferOptions{compress: "zstd"}); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := store.StatContext(ctx, "compressed")
+	var stored bytes.Buffer
+	store.DownloadStream(ctx, "compressed", &stored)
+	info.Metadata[storage.MetaSHA256] = strings.Repeat("0", 64)
+	store.UploadStream(ctx, &stored, int64(stored.Len()), "compressed", storage.WithMetadata(info.Metadata))
+	if err := downloadFile(ctx, store, "compressed", filepath.Join(dir, "compressed"), transferOptions{}); !errors.Is(err, storage.ErrChecksum) {
+		t.Fatalf("download with the wrong checksum = %v, want ErrChecksum", err)
+	}
+	if names := dirNames(t, dir); len(names) != 0 {
+		t.Fatalf("failed download left %v behind", names)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"tincan/pkg/storage"
+)
+
+var treeCmd = &cobra.Command{
+	Use:   "tree [prefix]",
+	Short: "Show files as a directory tree with sizes",
+	Long: `Show the files in the bucket, or below a prefix, as a directory tree.
+Each folder is shown with the number of files and total size it contains.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runTree,
+}
+
+// treeNode is a folder or, when it has no children, a file.
+type treeNode struct {
+	name     string
+	size     int64
+	files    int
+	children map[string]*treeNode
+}
+
+func (n *treeNode) add(parts []string, size int64) {
+	n.size += size
+	n.files++
+	if len(parts) == 0 {
+		return
+	}
+	if n.children == nil {
+		n.children = make(map[string]*treeNode)
+	}
+	child, ok := n.children[parts[0]]
+	if !ok {
+		child = &treeNode{name: parts[0]}
+		n.children[parts[0]] = child
+	}
+	child.add(parts[1:], size)
+}
+
+func runTree(cmd *cobra.Command, args []string) error {
+	client, err := newStorage()
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+
+	prefix := ""
+	if len(args) > 0 {
+		prefix = args[0]
+	}
+
+	// Keys are split on "/" after the last complete folder of the prefix
+	base := prefix[:strings.LastIndex(prefix, "/")+1]
+	root := &treeNode{name: base}
+	err = storage.Walk(cmd.Context(), client, prefix, func(f storage.FileInfo) error {
+		if strings.HasSuffix(f.Name, "/") {
+			return nil // folder placeholder created by the S3 console
+		}
+		root.add(strings.Split(strings.TrimPrefix(f.Name, base), "/"), f.Size)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list files: %w", err)
+	}
+
+	if root.files == 0 {
+		fmt.Println("No files found in bucket")
+		return nil
+	}
+
+	name := root.name
+	if name == "" {
+		name = "."
+	}
+	fmt.Printf("%s  (%s)\n", name, treeSummary(root))
+	printTree(root, "")
+	return nil
+}
+
+func printTree(n *treeNode, indent string) {
+	names := make([]string, 0, len(n.children))
+	for name := range n.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		child := n.children[name]
+		branch, next := "├── ", "│   "
+		if i == len(names)-1 {
+			branch, next = "└── ", "    "
+		}
+		if child.children == nil {
+			fmt.Printf("%s%s%s  %s\n", indent, branch, child.name, formatBytes(child.size))
+			continue
+		}
+		fmt.Printf("%s%s%s/  (%s)\n", indent, branch, child.name, treeSummary(child))
+		printTree(child, indent+next)
+	}
+}
+
+func treeSummary(n *treeNode) string {
+	noun := "files"
+	if n.files == 1 {
+		noun = "file"
+	}
+	return fmt.Sprintf("%d %s, %s", n.files, noun, formatBytes(n.size))
+}
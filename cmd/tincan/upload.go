@@ -1,41 +1,282 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
-	"tincan/pkg/s3client"
+	"tincan/pkg/compress"
+	"tincan/pkg/storage"
+)
+
+var (
+	uploadListPending bool
+	uploadAbort       string
+	uploadCompress    string
+	uploadEncrypt     bool
+	uploadRecipient   string
+	uploadPrefix      string
+	uploadInclude     []string
+	uploadExclude     []string
+	uploadJobs        int
+	uploadTTL         string
+	uploadOnce        bool
 )
 
 var uploadCmd = &cobra.Command{
-	Use:   "upload [file]",
-	Short: "Upload a file to S3",
-	Args:  cobra.ExactArgs(1),
-	RunE:  runUpload,
+	Use:   "upload [file or directory | - key]",
+	Short: "Upload a file or directory to S3",
+	Long: `Upload a file or directory to S3.
+
+A directory is uploaded recursively, each file under the directory's name
+followed by its relative path (or under --prefix). --include and --exclude
+select files by glob; a pattern without a slash matches a file or directory
+name at any depth. Patterns listed in a .tincanignore file at the top of the
+directory are excluded too.
+
+Large files are uploaded in parts. If an upload is interrupted, running the
+same command again resumes it from the last completed part.
+
+With --compress the file is compressed (zstd by default, or gzip) while it is
+uploaded; downloads decompress it automatically.
+
+With --encrypt the file is encrypted before it leaves this machine, using a
+passphrase (TINCAN_PASSPHRASE or prompted) or, with --recipient, the public
+key printed by "tincan keygen" on the receiving machine.
+
+With --ttl, such as 12h or 7d, the file expires after that long and is
+deleted by "tincan prune". With --once, it is deleted as soon as it has been
+downloaded once; simultaneous downloads cannot both get it.
+
+With "-" as the file, standard input is uploaded as the given key (under
+--prefix, if set), for example:
+
+  tar cz dir | tincan upload - backup.tgz
+
+Input of unknown length is sent in parts as it is read. Such an upload
+cannot be resumed, and encrypting it needs TINCAN_PASSPHRASE or --recipient,
+as the passphrase cannot be read from standard input.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if uploadListPending || uploadAbort != "" {
+			return cobra.NoArgs(cmd, args)
+		}
+		if len(args) > 0 && args[0] == "-" {
+			return cobra.ExactArgs(2)(cmd, args)
+		}
+		return cobra.ExactArgs(1)(cmd, args)
+	},
+	RunE: runUpload,
+}
+
+func init() {
+	uploadCmd.Flags().BoolVar(&uploadListPending, "list-pending", false, "list interrupted uploads that can be resumed")
+	uploadCmd.Flags().StringVar(&uploadAbort, "abort", "", "abort a pending upload by ID (or \"all\")")
+	uploadCmd.Flags().StringVar(&uploadCompress, "compress", "", "compress before uploading: zstd or gzip")
+	uploadCmd.Flags().Lookup("compress").NoOptDefVal = compress.Zstd
+	uploadCmd.Flags().BoolVar(&uploadEncrypt, "encrypt", false, "encrypt the file before uploading")
+	uploadCmd.Flags().StringVar(&uploadRecipient, "recipient", "", "public key to encrypt to instead of a passphrase (implies --encrypt)")
+	uploadCmd.Flags().StringVar(&uploadPrefix, "prefix", "", "folder to upload into (default: the directory's name)")
+	uploadCmd.Flags().StringSliceVar(&uploadInclude, "include", nil, "only upload files matching this glob (repeatable)")
+	uploadCmd.Flags().StringSliceVar(&uploadExclude, "exclude", nil, "skip files matching this glob (repeatable)")
+	uploadCmd.Flags().IntVarP(&uploadJobs, "jobs", "j", 4, "files to upload in parallel")
+	uploadCmd.Flags().StringVar(&uploadTTL, "ttl", "", "delete the file after this long, e.g. 12h or 7d")
+	uploadCmd.Flags().BoolVar(&uploadOnce, "once", false, "delete the file after its first download (burn after reading)")
+	uploadCmd.MarkFlagsMutuallyExclusive("list-pending", "abort")
 }
 
 func runUpload(cmd *cobra.Command, args []string) error {
+	if uploadListPending || uploadAbort != "" {
+		return runPendingUploads()
+	}
+
 	filePath := args[0]
 
-	// Check if file exists
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		return fmt.Errorf("file does not exist: %s", filePath)
+	if uploadCompress != "" && !compress.Valid(uploadCompress) {
+		return fmt.Errorf("unsupported compression %q (expected zstd or gzip)", uploadCompress)
+	}
+
+	var info os.FileInfo
+	if filePath != "-" {
+		// Check if file exists
+		var err error
+		info, err = os.Stat(filePath)
+		if os.IsNotExist(err) {
+			return fmt.Errorf("file does not exist: %s", filePath)
+		}
+		if err != nil {
+			return err
+		}
 	}
 
-	client, err := s3client.New()
+	client, err := newStorage()
 	if err != nil {
-		return fmt.Errorf("failed to create S3 client: %w", err)
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+
+	opts := transferOptions{
+		compress:    uploadCompress,
+		encrypt:     uploadEncrypt || uploadRecipient != "",
+		recipient:   uploadRecipient,
+		interactive: true,
+	}
+	if uploadOnce {
+		opts.metadata = map[string]string{storage.MetaBurn: "true"}
+	}
+	if uploadTTL != "" {
+		if opts.ttl, err = parseTTL(uploadTTL); err != nil {
+			return err
+		}
 	}
 
-	fileName := filepath.Base(filePath)
-	fmt.Printf("Uploading %s...\n", fileName)
+	prefix := uploadPrefix
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
 
-	if err := client.Upload(filePath, fileName); err != nil {
+	if info != nil && info.IsDir() {
+		return uploadDir(cmd, client, filePath, prefix, opts)
+	}
+
+	var fileName string
+	if filePath == "-" {
+		fileName = prefix + args[1]
+		fmt.Printf("Uploading standard input as %s...\n", fileName)
+
+		// Standard input holds the data, so it cannot answer a prompt
+		opts.interactive = false
+		progress := newProgressDisplay(fileName)
+		err = uploadStream(progress.track(cmd.Context()), client, os.Stdin, stdinSize(), fileName, opts)
+		progress.close()
+	} else {
+		fileName = prefix + filepath.Base(filePath)
+		fmt.Printf("Uploading %s...\n", fileName)
+		progress := newProgressDisplay(fileName)
+		err = uploadFile(progress.track(cmd.Context()), client, filePath, fileName, opts)
+		progress.close()
+	}
+	if err != nil {
 		return fmt.Errorf("failed to upload file: %w", err)
 	}
 
 	fmt.Printf("Successfully uploaded %s\n", fileName)
+	if opts.ttl > 0 {
+		fmt.Printf("Expires %s\n", time.Now().Add(opts.ttl).Format("2006-01-02 15:04"))
+	}
 	return nil
-}
\ No newline at end of file
+}
+
+// stdinSize returns the length of standard input if it is a regular file,
+// or -1 for a pipe or terminal.
+func stdinSize() int64 {
+	info, err := os.Stdin.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return -1
+	}
+	// The file may have been read from already
+	pos, err := os.Stdin.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return -1
+	}
+	return info.Size() - pos
+}
+
+func uploadDir(cmd *cobra.Command, client storage.Storage, dir, prefix string, opts transferOptions) error {
+	if !cmd.Flags().Changed("prefix") {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		prefix = filepath.Base(abs) + "/"
+	}
+
+	filter, err := newPathFilter(uploadInclude, uploadExclude)
+	if err != nil {
+		return err
+	}
+	if err := filter.loadIgnoreFile(dir); err != nil {
+		return err
+	}
+
+	entries, err := collectUploads(dir, prefix, filter)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Println("No files to upload")
+		return nil
+	}
+
+	// Ask for the passphrase once rather than for every file
+	if opts.encrypt && opts.recipient == "" {
+		if opts.secret, err = opts.passphrase(true); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Uploading %d files from %s to %s...\n", len(entries), dir, prefix)
+	result, err := transferAll(cmd.Context(), entries, uploadJobs, "uploaded", func(ctx context.Context, e dirEntry) error {
+		return uploadFile(ctx, client, e.local, e.key, opts)
+	})
+	if err != nil {
+		return err
+	}
+	return result.report("uploaded")
+}
+
+func runPendingUploads() error {
+	client, err := newStorage()
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+
+	resumable, ok := client.(storage.Resumable)
+	if !ok {
+		return fmt.Errorf("the configured backend does not support resumable uploads")
+	}
+
+	pending, err := resumable.PendingUploads()
+	if err != nil {
+		return fmt.Errorf("failed to list pending uploads: %w", err)
+	}
+
+	if uploadListPending {
+		if len(pending) == 0 {
+			fmt.Println("No pending uploads")
+			return nil
+		}
+
+		fmt.Println("Pending uploads:")
+		for _, p := range pending {
+			progress := fmt.Sprintf("%s/%s", formatBytes(p.Uploaded), formatBytes(p.Size))
+			started := p.Started.Format("2006-01-02 15:04:05")
+			fmt.Printf("  %s  %-30s %20s  %s\n", p.ID, p.Key, progress, started)
+			fmt.Printf("      from %s\n", p.FilePath)
+		}
+		return nil
+	}
+
+	aborted := 0
+	for _, p := range pending {
+		if uploadAbort != "all" && uploadAbort != p.ID {
+			continue
+		}
+		if err := resumable.AbortUpload(p.ID); err != nil {
+			return fmt.Errorf("failed to abort upload %s: %w", p.ID, err)
+		}
+		fmt.Printf("Aborted upload of %s (%s)\n", p.Key, p.ID)
+		aborted++
+	}
+
+	if aborted == 0 && uploadAbort != "all" {
+		return fmt.Errorf("no pending upload with ID %q", uploadAbort)
+	}
+	if aborted == 0 {
+		fmt.Println("No pending uploads")
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+// setStdin makes data the standard input, read from a pipe or, if file is
+// set, from a regular file.
+func setStdin(t *testing.T, data []byte, file bool) {
+	t.Helper()
+	var r *os.File
+	if file {
+		var err error
+		if r, err = os.Open(writeTestFile(t, t.TempDir(), "stdin", data)); err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		var w *os.File
+		var err error
+		if r, w, err = os.Pipe(); err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			w.Write(data)
+			w.Close()
+		}()
+	}
+	old := os.Stdin
+	os.Stdin = r
+	t.Cleanup(func() {
+		os.Stdin = old
+		r.Close()
+	})
+}
+
+// captureStdout sends standard output to a file until the returned function
+// is called, which returns what was written.
+func captureStdout(t *testing.T) func() []byte {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "stdout")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := os.Stdout
+	os.Stdout = f
+	restore := func() { os.Stdout = old }
+	t.Cleanup(restore)
+	return func() []byte {
+		restore()
+		f.Close()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+}
+
+func setUploadFlags(t *testing.T, compress string, encrypt bool) {
+	t.Helper()
+	uploadCompress, uploadEncrypt = compress, encrypt
+	t.Cleanup(func() { uploadCompress, uploadEncrypt = "", false })
+}
+
+func runCommand(t *testing.T, run func(*cobra.Command, []string) error, args ...string) error {
+	t.Helper()
+	cmd := &cobra.Command{}
+	cmd.SetContext(context.Background())
+	return run(cmd, args)
+}
+
+func TestStdinSize(t *testing.T) {
+	setStdin(t, []byte("0123456789"), true)
+	if n := stdinSize(); n != 10 {
+		t.Fatalf("size of a file = %d, want 10", n)
+	}
+	// What was read already is not sent
+	io.ReadFull(os.Stdin, make([]byte, 4))
+	if n := stdinSize(); n != 6 {
+		t.Fatalf("size of a file read from = %d, want 6", n)
+	}
+
+	setStdin(t, []byte("0123456789"), false)
+	if n := stdinSize(); n != -1 {
+		t.Fatalf("size of a pipe = %d, want -1", n)
+	}
+}
+
+func TestUploadStdin(t *testing.T) {
+	store := useMemoryBackend(t)
+	ctx := context.Background()
+	data := []byte(strings.Repeat("streamed from a pipe\n", 5000))
+
+	for _, tc := range []struct {
+		name     string
+		file     bool
+		compress string
+		encrypt  bool
+	}{
+		{"pipe", false, "", false},
+		{"file", true, "", false},
+		{"compressed pipe", false, "zstd", false},
+		{"encrypted pipe", false, "gzip", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("TINCAN_PASSPHRASE", "pw")
+			setStdin(t, data, tc.file)
+			setUploadFlags(t, tc.compress, tc.encrypt)
+			if err := runCommand(t, runUpload, "-", "stdin.txt"); err != nil {
+				t.Fatalf("upload -: %v", err)
+			}
+			info, err := store.StatContext(ctx, "stdin.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Compression() != tc.compress || info.Encrypted() != tc.encrypt {
+				t.Fatalf("metadata = %v", info.Metadata)
+			}
+			var got bytes.Buffer
+			if err := downloadStream(ctx, store, "stdin.txt", &got, transferOptions{}); err != nil || !bytes.Equal(got.Bytes(), data) {
+				t.Fatalf("stored %d bytes of %d, %v", got.Len(), len(data), err)
+			}
+		})
+	}
+
+	// Standard input holds the data, so the passphrase cannot come from it
+	t.Setenv("TINCAN_PASSPHRASE", "")
+	setStdin(t, []byte("pw\nsecret data"), false)
+	setUploadFlags(t, "", true)
+	if err := runCommand(t, runUpload, "-", "prompted.txt"); err == nil || !strings.Contains(err.Error(), "TINCAN_PASSPHRASE") {
+		t.Fatalf("encrypted upload - without TINCAN_PASSPHRASE = %v", err)
+	}
+	if _, err := store.StatContext(ctx, "prompted.txt"); err == nil {
+		t.Fatal("encrypted upload - stored a file without a passphrase")
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"tincan/pkg/storage"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <key> <localfile>",
+	Short: "Check that a local file matches a file in the bucket",
+	Long: `Compare the SHA-256 checksum of a local file with the one recorded when
+key was uploaded. For compressed or encrypted files the checksum of the
+original content is used, so localfile is the file as it was uploaded or
+downloaded, not as it is stored.
+
+Files uploaded by older versions of tincan may have no checksum to compare.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runVerify,
+}
+
+func runVerify(cmd *cobra.Command, args []string) error {
+	key, localFile := args[0], args[1]
+
+	client, err := newStorage()
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+
+	info, err := client.StatContext(cmd.Context(), key)
+	if err != nil {
+		return fmt.Errorf("failed to find %s: %w", key, err)
+	}
+	want := info.SHA256()
+	if want == "" {
+		return fmt.Errorf("%s has no recorded checksum", key)
+	}
+
+	got, err := fileSHA256(localFile)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", localFile, err)
+	}
+	if got != want {
+		return fmt.Errorf("%w: %s differs from %s\n  local:  %s\n  remote: %s", storage.ErrChecksum, localFile, key, got, want)
+	}
+
+	fmt.Printf("OK: %s matches %s (sha256 %s)\n", localFile, key, got)
+	return nil
+}
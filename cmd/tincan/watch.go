@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cobra"
+	"tincan/pkg/compress"
+	"tincan/pkg/storage"
+)
+
+// sentDir is where uploaded files are moved with --move-sent.
+const sentDir = "sent"
+
+var (
+	watchPrefix    string
+	watchSettle    time.Duration
+	watchRetries   int
+	watchMoveSent  bool
+	watchExisting  bool
+	watchInclude   []string
+	watchExclude   []string
+	watchCompress  string
+	watchEncrypt   bool
+	watchRecipient string
+	watchSchedule  []string
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch <directory>",
+	Short: "Upload files as they appear in a directory",
+	Long: `Watch a directory and upload every file that is created or changed in it,
+including in subdirectories, under its relative path (below --prefix).
+
+A file is uploaded once it has not been written to for --settle, so files
+that are still being copied in are left alone. Hidden files and names ending
+in .tmp, .part, .partial, .crdownload or ~ are ignored, as are files excluded
+by --exclude or .tincanignore. Failed uploads are retried with backoff.
+
+With --move-sent, uploaded files are moved to a "sent" subdirectory, and
+files already waiting in the directory are uploaded on startup.
+
+--limit-schedule changes the bandwidth limit at times of day; outside its
+windows --limit-rate applies. To upload at 2MB/s by day and at full speed
+overnight:
+
+  tincan watch ./out --limit-rate 2MB/s --limit-schedule 19:00-07:00=unlimited`,
+	Args: cobra.ExactArgs(1),
+	RunE: runWatch,
+}
+
+func init() {
+	watchCmd.Flags().StringVar(&watchPrefix, "prefix", "", "folder to upload into")
+	watchCmd.Flags().DurationVar(&watchSettle, "settle", 2*time.Second, "how long a file must be unchanged before it is uploaded")
+	watchCmd.Flags().IntVar(&watchRetries, "retries", 5, "attempts per file before giving up")
+	watchCmd.Flags().BoolVar(&watchMoveSent, "move-sent", false, "move uploaded files into a sent/ subdirectory")
+	watchCmd.Flags().BoolVar(&watchExisting, "existing", false, "upload files already in the directory on startup")
+	watchCmd.Flags().StringSliceVar(&watchInclude, "include", nil, "only upload files matching this glob (repeatable)")
+	watchCmd.Flags().StringSliceVar(&watchExclude, "exclude", nil, "skip files matching this glob (repeatable)")
+	watchCmd.Flags().StringVar(&watchCompress, "compress", "", "compress before uploading: zstd or gzip")
+	watchCmd.Flags().Lookup("compress").NoOptDefVal = compress.Zstd
+	watchCmd.Flags().BoolVar(&watchEncrypt, "encrypt", false, "encrypt files before uploading")
+	watchCmd.Flags().StringVar(&watchRecipient, "recipient", "", "public key to encrypt to instead of a passphrase (implies --encrypt)")
+	watchCmd.Flags().StringSliceVar(&watchSchedule, "limit-schedule", nil, "bandwidth limit at times of day, e.g. 08:00-19:00=1MB/s (repeatable, overrides limit_schedule)")
+}
+
+// watcher uploads the files of one directory tree as they settle.
+type watcher struct {
+	dir    string
+	prefix string
+	filter *pathFilter
+	store  storage.Storage
+	opts   transferOptions
+	fs     *fsnotify.Watcher
+	queue  chan string
+
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+	sent   map[string]time.Time // modification time of each uploaded file
+}
+
+func runWatch(cmd *cobra.Command, args []string) error {
+	dir := filepath.Clean(args[0])
+	if !isDir(dir) {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if watchCompress != "" && !compress.Valid(watchCompress) {
+		return fmt.Errorf("unsupported compression %q (expected zstd or gzip)", watchCompress)
+	}
+
+	filter, err := newPathFilter(watchInclude, watchExclude)
+	if err != nil {
+		return err
+	}
+	if err := filter.loadIgnoreFile(dir); err != nil {
+		return err
+	}
+	schedule, err := loadSchedule(watchSchedule)
+	if err != nil {
+		return err
+	}
+
+	client, err := newStorage()
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+	followSchedule(cmd.Context(), schedule)
+
+	opts := transferOptions{
+		compress:    watchCompress,
+		encrypt:     watchEncrypt || watchRecipient != "",
+		recipient:   watchRecipient,
+		interactive: true,
+	}
+	if opts.encrypt && opts.recipient == "" {
+		if opts.secret, err = opts.passphrase(true); err != nil {
+			return err
+		}
+	}
+
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("unable to watch %s: %w", dir, err)
+	}
+	defer fsw.Close()
+
+	prefix := watchPrefix
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	w := &watcher{
+		dir:    dir,
+		prefix: prefix,
+		filter: filter,
+		store:  client,
+		opts:   opts,
+		fs:     fsw,
+		queue:  make(chan string, 64),
+		timers: make(map[string]*time.Timer),
+		sent:   make(map[string]time.Time),
+	}
+
+	ctx := cmd.Context()
+	if err := w.addTree(ctx, dir, watchExisting || watchMoveSent); err != nil {
+		return err
+	}
+	// Let an upload in progress wind down before returning
+	uploading := make(chan struct{})
+	go func() {
+		w.uploadLoop(ctx)
+		close(uploading)
+	}()
+
+	log.Printf("Watching %s for new files (Ctrl-C to stop)", dir)
+	for {
+		select {
+		case <-ctx.Done():
+			<-uploading
+			return nil
+		case err := <-fsw.Errors:
+			log.Printf("Watch error: %v", err)
+		case ev := <-fsw.Events:
+			w.handle(ctx, ev)
+		}
+	}
+}
+
+// addTree watches dir and its subdirectories, scheduling the files already
+// in them when schedule is set.
+func (w *watcher) addTree(ctx context.Context, dir string, schedule bool) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, ok := w.rel(p)
+		if !ok {
+			if d.IsDir() && p != w.dir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if err := w.fs.Add(p); err != nil {
+				return fmt.Errorf("unable to watch %s: %w", p, err)
+			}
+			return nil
+		}
+		if schedule && d.Type().IsRegular() && w.filter.match(rel) {
+			w.schedule(ctx, p)
+		}
+		return nil
+	})
+}
+
+// rel returns the slash-separated path of p below the watched directory,
+// or false for paths that are never uploaded.
+func (w *watcher) rel(p string) (string, bool) {
+	rel, err := filepath.Rel(w.dir, p)
+	if err != nil {
+		return "", false
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == "." {
+		return rel, true
+	}
+	if watchMoveSent && (rel == sentDir || strings.HasPrefix(rel, sentDir+"/")) {
+		return "", false
+	}
+	if partialFile(filepath.Base(p)) || w.filter.excluded(rel) {
+		return "", false
+	}
+	return rel, true
+}
+
+// partialFile reports whether name looks like a file that is still being
+// written or is not meant to be shared.
+func partialFile(name string) bool {
+	if strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") {
+		return true
+	}
+	switch filepath.Ext(name) {
+	case ".tmp", ".part", ".partial", ".crdownload":
+		return true
+	}
+	return false
+}
+
+func (w *watcher) handle(ctx context.Context, ev fsnotify.Event) {
+	rel, ok := w.rel(ev.Name)
+	if !ok {
+		return
+	}
+
+	switch {
+	case ev.Has(fsnotify.Create), ev.Has(fsnotify.Write):
+		info, err := os.Stat(ev.Name)
+		if err != nil {
+			return
+		}
+		if info.IsDir() {
+			// Files may have been created before the watch was added
+			if err := w.addTree(ctx, ev.Name, true); err != nil {
+				log.Printf("Watch error: %v", err)
+			}
+			return
+		}
+		if info.Mode().IsRegular() && w.filter.match(rel) {
+			w.schedule(ctx, ev.Name)
+		}
+	case ev.Has(fsnotify.Remove), ev.Has(fsnotify.Rename):
+		w.mu.Lock()
+		if t, ok := w.timers[ev.Name]; ok {
+			t.Stop()
+			delete(w.timers, ev.Name)
+		}
+		w.mu.Unlock()
+	}
+}
+
+// schedule queues p for upload once it has not changed for watchSettle,
+// unless ctx is done by then.
+func (w *watcher) schedule(ctx context.Context, p string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if t, ok := w.timers[p]; ok {
+		t.Reset(watchSettle)
+		return
+	}
+	w.timers[p] = time.AfterFunc(watchSettle, func() {
+		w.mu.Lock()
+		delete(w.timers, p)
+		w.mu.Unlock()
+		select {
+		case w.queue <- p:
+		case <-ctx.Done():
+		}
+	})
+}
+
+func (w *watcher) uploadLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case p := <-w.queue:
+			w.upload(ctx, p)
+		}
+	}
+}
+
+// upload sends p, retrying with exponential backoff, and moves it to sent/
+// if requested.
+func (w *watcher) upload(ctx context.Context, p string) {
+	info, err := os.Stat(p)
+	if err != nil || !info.Mode().IsRegular() {
+		return
+	}
+	w.mu.Lock()
+	sent, ok := w.sent[p]
+	w.mu.Unlock()
+	if ok && sent.Equal(info.ModTime()) {
+		return // already uploaded and unchanged
+	}
+
+	rel, _ := w.rel(p)
+	key := w.prefix + rel
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err = uploadFile(ctx, w.store, p, key, w.opts)
+		if err == nil || ctx.Err() != nil || errors.Is(err, fs.ErrNotExist) || attempt >= watchRetries {
+			break
+		}
+		log.Printf("Upload of %s failed (attempt %d of %d), retrying in %s: %v", rel, attempt, watchRetries, backoff, err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, time.Minute)
+	}
+	if err != nil {
+		log.Printf("Failed to upload %s: %v", rel, err)
+		return
+	}
+	log.Printf("Uploaded %s as %s (%s)", rel, key, formatBytes(info.Size()))
+
+	if !watchMoveSent {
+		w.mu.Lock()
+		w.sent[p] = info.ModTime()
+		w.mu.Unlock()
+		return
+	}
+	if err := moveToSent(w.dir, rel); err != nil {
+		log.Printf("Failed to move %s to %s/: %v", rel, sentDir, err)
+	}
+}
+
+// moveToSent moves dir/rel to dir/sent/rel, adding a timestamp to the name
+// if a file of that name was sent before.
+func moveToSent(dir, rel string) error {
+	dest := filepath.Join(dir, sentDir, filepath.FromSlash(rel))
+	if _, err := os.Stat(dest); err == nil {
+		ext := filepath.Ext(dest)
+		dest = strings.TrimSuffix(dest, ext) + time.Now().Format("-20060102-150405") + ext
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(dir, filepath.FromSlash(rel)), dest)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func setWatchFlags(t *testing.T, settle time.Duration, moveSent, existing bool) {
+	t.Helper()
+	watchPrefix, watchSettle, watchRetries, watchMoveSent, watchExisting = "in", settle, 1, moveSent, existing
+	t.Cleanup(func() {
+		watchPrefix, watchSettle, watchRetries, watchMoveSent, watchExisting = "", 2*time.Second, 5, false, false
+	})
+}
+
+// startWatch runs the watch command on dir until the test ends.
+func startWatch(t *testing.T, dir string) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := &cobra.Command{}
+	cmd.SetContext(ctx)
+	done := make(chan error, 1)
+	go func() { done <- runWatch(cmd, []string{dir}) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("watch: %v", err)
+		}
+	})
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func newTestWatcher(queue chan string) *watcher {
+	return &watcher{queue: queue, timers: make(map[string]*time.Timer), sent: make(map[string]time.Time)}
+}
+
+func TestPartialFile(t *testing.T) {
+	for name, want := range map[string]bool{
+		"report.pdf":            false,
+		"notes.txt":             false,
+		"temporary.tmp.txt":     false,
+		".hidden":               true,
+		"draft.txt~":            true,
+		"copy.tmp":              true,
+		"video.mp4.part":        true,
+		"data.partial":          true,
+		"setup.exe.crdownload":  true,
+		".report.pdf.swp":       true,
+		"archive.tar.gz":        false,
+		"part":                  false,
+		"file.PART":             false,
+		"backup.2024-05-01.tmp": true,
+	} {
+		if got := partialFile(name); got != want {
+			t.Errorf("partialFile(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestSettle(t *testing.T) {
+	setWatchFlags(t, 100*time.Millisecond, false, false)
+	ctx := context.Background()
+	w := newTestWatcher(make(chan string, 8))
+
+	// Every write restarts the wait
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		w.schedule(ctx, "busy")
+		time.Sleep(30 * time.Millisecond)
+	}
+	w.schedule(ctx, "quiet")
+	if len(w.queue) != 0 {
+		t.Fatalf("%q was queued while it was still being written", <-w.queue)
+	}
+
+	var got []string
+	for len(got) < 2 {
+		select {
+		case p := <-w.queue:
+			got = append(got, p)
+			// Written last 120ms in, so due at 220ms
+			if elapsed := time.Since(start); p == "busy" && elapsed < 220*time.Millisecond {
+				t.Errorf("busy file queued after %v, before it settled", elapsed)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("only %v queued", got)
+		}
+	}
+	slices.Sort(got)
+	if !slices.Equal(got, []string{"busy", "quiet"}) {
+		t.Fatalf("queued %v", got)
+	}
+	time.Sleep(200 * time.Millisecond)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.queue) != 0 || len(w.timers) != 0 {
+		t.Fatalf("%d more queued, %d timers left", len(w.queue), len(w.timers))
+	}
+}
+
+func TestSettleAfterStop(t *testing.T) {
+	setWatchFlags(t, 10*time.Millisecond, false, false)
+	ctx, cancel := context.WithCancel(context.Background())
+	w := newTestWatcher(make(chan string))
+
+	w.schedule(ctx, "late")
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+
+	// With nobody reading the queue any more, the timer must not be left
+	// waiting to hand over the file
+	select {
+	case p := <-w.queue:
+		t.Fatalf("%s was still being queued after the watch stopped", p)
+	default:
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.timers) != 0 {
+		t.Fatalf("%d timers left", len(w.timers))
+	}
+}
+
+func TestWatchUploads(t *testing.T) {
+	store := useMemoryBackend(t)
+	ctx := context.Background()
+	setWatchFlags(t, 50*time.Millisecond, false, true)
+	dir := t.TempDir()
+	writeTestFile(t, dir, "before.txt", []byte("already there"))
+
+	startWatch(t, dir)
+	uploaded := func(key string) func() bool {
+		return func() bool {
+			_, err := store.StatContext(ctx, key)
+			return err == nil
+		}
+	}
+	// Uploaded with --existing, and the watch is set up once it is
+	waitFor(t, "in/before.txt", uploaded("in/before.txt"))
+
+	writeTestFile(t, dir, "video.mp4.part", []byte("half"))
+	writeTestFile(t, dir, ".hidden", []byte("secret"))
+	writeTestFile(t, dir, "new.txt", []byte("new"))
+	os.MkdirAll(filepath.Join(dir, "sub"), 0o755)
+	writeTestFile(t, dir, "sub/deep.txt", []byte("deep"))
+	waitFor(t, "in/new.txt", uploaded("in/new.txt"))
+	waitFor(t, "in/sub/deep.txt", uploaded("in/sub/deep.txt"))
+
+	time.Sleep(200 * time.Millisecond)
+	for _, key := range []string{"in/video.mp4.part", "in/.hidden"} {
+		if uploaded(key)() {
+			t.Errorf("%s was uploaded", key)
+		}
+	}
+
+	// A rewritten file goes up again
+	writeTestFile(t, dir, "new.txt", []byte("rewritten"))
+	waitFor(t, "the rewritten in/new.txt", func() bool {
+		info, err := store.StatContext(ctx, "in/new.txt")
+		return err == nil && info.Size == int64(len("rewritten"))
+	})
+}
+
+func TestWatchMoveSent(t *testing.T) {
+	store := useMemoryBackend(t)
+	ctx := context.Background()
+	setWatchFlags(t, 50*time.Millisecond, true, false)
+	dir := t.TempDir()
+	writeTestFile(t, dir, "waiting.txt", []byte("waiting"))
+	writeTestFile(t, dir, "sent/old.txt", []byte("sent before"))
+
+	// Files already waiting are sent without --existing
+	startWatch(t, dir)
+	sent := func(n int) func() bool {
+		return func() bool {
+			entries, _ := os.ReadDir(filepath.Join(dir, sentDir))
+			return len(entries) == n
+		}
+	}
+	waitFor(t, "waiting.txt to be sent", sent(2))
+
+	// A second file of the same name keeps the first one
+	writeTestFile(t, dir, "waiting.txt", []byte("again"))
+	waitFor(t, "the second waiting.txt to be sent", sent(3))
+
+	if names := dirNames(t, dir); !slices.Equal(names, []string{sentDir}) {
+		t.Fatalf("left %v in the directory", names)
+	}
+	names := dirNames(t, filepath.Join(dir, sentDir))
+	if names[0] != "old.txt" || !strings.HasPrefix(names[1], "waiting-") || names[2] != "waiting.txt" {
+		t.Fatalf("sent/ holds %v", names)
+	}
+	got, _ := os.ReadFile(filepath.Join(dir, sentDir, "waiting.txt"))
+	if string(got) != "waiting" {
+		t.Fatalf("sent/waiting.txt holds %q", got)
+	}
+	if _, err := store.StatContext(ctx, "in/sent/old.txt"); err == nil {
+		t.Fatal("a file in sent/ was uploaded")
+	}
+	if info, err := store.StatContext(ctx, "in/waiting.txt"); err != nil || info.Size != int64(len("again")) {
+		t.Fatalf("in/waiting.txt = %+v, %v", info, err)
+	}
+}
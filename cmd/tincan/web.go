@@ -1,24 +1,39 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
+	"io/fs"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/spf13/cobra"
-	"tincan/pkg/s3client"
+	"tincan/pkg/storage"
 )
 
+var webPruneEvery time.Duration
+
 var webCmd = &cobra.Command{
 	Use:   "web",
 	Short: "Start web interface",
-	Long:  `Start a web server providing a GUI interface for TinCan operations.`,
-	Run:   runWebServer,
+	Long: `Start a web server providing a GUI interface for TinCan operations.
+
+With --prune-every, expired files are deleted on that schedule while the
+server runs, as with "tincan prune".`,
+	Run: runWebServer,
+}
+
+func init() {
+	webCmd.Flags().DurationVar(&webPruneEvery, "prune-every", 0, "delete expired files this often (0 to disable)")
 }
 
 func runWebServer(cmd *cobra.Command, args []string) {
@@ -30,13 +45,53 @@ func runWebServer(cmd *cobra.Command, args []string) {
 	http.HandleFunc("/", handleHome)
 	http.HandleFunc("/upload", handleUpload)
 	http.HandleFunc("/download", handleDownload)
+	http.HandleFunc("/progress", handleProgress)
 	http.HandleFunc("/validate", handleValidate)
 	http.HandleFunc("/list", handleList)
 	http.HandleFunc("/clean", handleClean)
 	http.HandleFunc("/delete", handleDelete)
+	http.HandleFunc("/share", handleShare)
+
+	server := &http.Server{Addr: ":" + port}
+	go func() {
+		<-cmd.Context().Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	if webPruneEvery > 0 {
+		go pruneLoop(cmd.Context(), webPruneEvery)
+	}
 
 	fmt.Printf("TinCan web interface starting on http://localhost:%s\n", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+}
+
+// pruneLoop deletes expired files every interval until ctx is cancelled.
+func pruneLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		client, err := newStorage()
+		if err == nil {
+			var expired []storage.FileInfo
+			if expired, err = pruneExpired(ctx, client, "", false); len(expired) > 0 {
+				log.Printf("Pruned %d expired files", len(expired))
+			}
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Prune failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
 
 func handleHome(w http.ResponseWriter, r *http.Request) {
@@ -164,6 +219,9 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
             transform: translateY(-1px);
         }
         .file-name { font-weight: 500; color: var(--text-primary); }
+        .folder-item { cursor: pointer; }
+        .breadcrumbs { margin: 10px 0 0; color: var(--text-secondary); }
+        .breadcrumbs a { color: var(--text-primary); cursor: pointer; text-decoration: underline; }
         button {
             padding: 10px 20px;
             margin: 5px;
@@ -232,6 +290,14 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
             color: var(--text-primary);
             transition: all 0.2s ease;
         }
+        select {
+            padding: 12px;
+            border: 2px solid var(--border-primary);
+            border-radius: 6px;
+            font-size: 14px;
+            background: var(--bg-secondary);
+            color: var(--text-primary);
+        }
         input[type="text"]:focus {
             outline: none;
             border-color: var(--border-accent);
@@ -360,6 +426,16 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
         <h2>&#128228; Upload File</h2>
         <form id="uploadForm" enctype="multipart/form-data">
             <input type="file" id="fileInput" name="file" required>
+            <select id="ttlInput" name="ttl" title="Delete the file automatically after">
+                <option value="" selected>Keep forever</option>
+                <option value="1h">Expire after 1 hour</option>
+                <option value="1d">Expire after 1 day</option>
+                <option value="7d">Expire after 7 days</option>
+                <option value="30d">Expire after 30 days</option>
+            </select>
+            <label style="color: var(--text-secondary); font-size: 14px;">
+                <input type="checkbox" id="onceInput"> Delete after the first download
+            </label>
             <button type="submit" class="btn-primary" id="uploadBtn">
                 <span id="uploadText">Upload File</span>
             </button>
@@ -394,6 +470,7 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
                 </label>
             </div>
         </div>
+        <div id="breadcrumbs" class="breadcrumbs"></div>
         <div id="fileList" class="file-list"></div>
     </div>
 
@@ -417,6 +494,21 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
         <div id="downloadResult"></div>
     </div>
 
+    <div class="section">
+        <h2>&#128279; Request an Upload</h2>
+        <p style="color: #6b7280; margin-bottom: 15px;">Create a link that lets someone without access to the bucket upload a file to it.</p>
+        <div style="display: flex; gap: 10px; align-items: center; flex-wrap: wrap;">
+            <input type="text" id="requestKey" placeholder="Save upload as">
+            <select id="requestExpires">
+                <option value="1h">1 hour</option>
+                <option value="24h" selected>1 day</option>
+                <option value="168h">7 days</option>
+            </select>
+            <button onclick="requestUpload()" class="btn-primary">Create Link</button>
+        </div>
+        <div id="requestResult"></div>
+    </div>
+
     <div class="section">
         <h2>&#128465;&#65039; Clean Up</h2>
         <p style="color: #6b7280; margin-bottom: 15px;">This will delete all files in the bucket. This action cannot be undone.</p>
@@ -546,6 +638,10 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
             }
 
             const formData = new FormData();
+            // The server reads the fields before it streams the file
+            formData.append('ttl', document.getElementById('ttlInput').value);
+            if (document.getElementById('onceInput').checked) formData.append('once', 'true');
+            formData.append('size', file.size);
             formData.append('file', file);
 
             showLoading('uploadBtn', 'uploadText', 'Upload File');
@@ -567,21 +663,19 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
                 hideLoading('uploadBtn', 'uploadText', 'Upload File');
                 hideProgress('uploadProgress');
 
-                if (xhr.status === 200) {
-                    try {
-                        const data = JSON.parse(xhr.responseText);
-                        if (data.success) {
-                            showAlert('uploadResult', data.message + ' (' + formatFileSize(file.size) + ')', true);
-                            fileInput.value = '';
-                            listFiles();
-                        } else {
-                            showAlert('uploadResult', data.error, false);
-                        }
-                    } catch (e) {
-                        showAlert('uploadResult', 'Upload completed but response was invalid', false);
-                    }
+                let data;
+                try {
+                    data = JSON.parse(xhr.responseText);
+                } catch (e) {
+                    showAlert('uploadResult', xhr.status === 200 ? 'Upload completed but response was invalid' : 'Upload failed: Server error ' + xhr.status, false);
+                    return;
+                }
+                if (data.success) {
+                    showAlert('uploadResult', data.message + ' (' + formatFileSize(file.size) + ')', true);
+                    fileInput.value = '';
+                    listFiles();
                 } else {
-                    showAlert('uploadResult', 'Upload failed: Server error ' + xhr.status, false);
+                    showAlert('uploadResult', data.error, false);
                 }
             });
 
@@ -604,55 +698,162 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
             xhr.send(formData);
         };
 
+        const listPageSize = 100;
+        let listCursor = '';
+        let listObserver = null;
+        let currentPrefix = '';
+
+        function openFolder(prefix) {
+            currentPrefix = prefix;
+            listFiles();
+        }
+
+        function renderBreadcrumbs() {
+            var parts = currentPrefix.split('/').filter(Boolean);
+            var html = '<a onclick="openFolder(\'\')">&#127968; bucket</a>';
+            var path = '';
+            parts.forEach(function(part) {
+                path += part + '/';
+                html += ' / <a onclick="openFolder(\'' + path + '\')">' + part + '</a>';
+            });
+            document.getElementById('breadcrumbs').innerHTML = html;
+        }
+
+        function renderFolder(prefix) {
+            return '<div class="file-item folder-item" onclick="openFolder(\'' + prefix + '\')">' +
+                '<div class="file-info">' +
+                    '<div class="file-name">&#128193; ' + prefix.slice(currentPrefix.length) + '</div>' +
+                '</div>' +
+            '</div>';
+        }
+
+        function renderFile(file) {
+            var fileName = file.name || file;
+            var fileSize = formatFileSize(file.size || 0);
+            var uploadDate = 'Unknown';
+
+            if (file.lastModified) {
+                var date = new Date(file.lastModified);
+                uploadDate = date.toLocaleDateString() + ' ' + date.toLocaleTimeString([], {hour: '2-digit', minute:'2-digit'});
+            }
+
+            // Listings from S3 come without metadata, which is fetched
+            // once the file scrolls into view
+            var pending = file.metadata ? '' : ' data-pending-key="' + encodeURIComponent(fileName) + '"';
+
+            return '<div class="file-item"' + pending + '>' +
+                '<div class="file-info">' +
+                    '<div class="file-name"><span class="file-icon">' + fileIcon(file.metadata) + '</span> ' + fileName.slice(currentPrefix.length) + '</div>' +
+                    '<div style="font-size: 0.8em; color: #6b7280;">' +
+                        fileSize + ' &bull; ' + uploadDate + '<span class="file-remaining">' + fileRemaining(file.metadata) + '</span>' +
+                    '</div>' +
+                '</div>' +
+                '<div>' +
+                    '<button onclick="downloadFile(\'' + fileName + '\')" class="btn-download">' +
+                        '&#128229; Download' +
+                    '</button>' +
+                    '<button onclick="copyShareLink(\'' + fileName + '\')" class="btn-secondary" style="padding: 6px 12px; font-size: 12px; margin-left: 5px;">' +
+                        '&#128279; Copy share link' +
+                    '</button>' +
+                    '<button onclick="deleteFile(\'' + fileName + '\')" class="btn-danger" style="padding: 6px 12px; font-size: 12px; margin-left: 5px;">' +
+                        '&#128465;&#65039; Delete' +
+                    '</button>' +
+                '</div>' +
+            '</div>';
+        }
+
+        function fileIcon(metadata) {
+            var icon = metadata && metadata['tincan-encryption'] ? '<span title="Encrypted">&#128274;</span>' : '&#128196;';
+            if (metadata && metadata['tincan-burn'] === 'true') {
+                icon += '<span title="Deleted after the first download">&#128293;</span>';
+            }
+            return icon;
+        }
+
+        function fileRemaining(metadata) {
+            var expires = metadata && metadata['tincan-expires'];
+            return expires ? ' &bull; ' + formatRemaining(new Date(expires)) : '';
+        }
+
+        const metadataObserver = new IntersectionObserver(function(entries) {
+            entries.forEach(function(entry) {
+                if (!entry.isIntersecting) return;
+                var item = entry.target;
+                var key = decodeURIComponent(item.dataset.pendingKey);
+                metadataObserver.unobserve(item);
+                item.removeAttribute('data-pending-key');
+                fetch('/validate?key=' + encodeURIComponent(key))
+                .then(response => response.json())
+                .then(data => {
+                    if (!data.success || !data.metadata) return;
+                    item.querySelector('.file-icon').innerHTML = fileIcon(data.metadata);
+                    item.querySelector('.file-remaining').innerHTML = fileRemaining(data.metadata);
+                })
+                .catch(function() {});
+            });
+        });
+
         function listFiles() {
             showLoading('refreshBtn', 'refreshText', 'Refresh List');
+            listCursor = '';
+            renderBreadcrumbs();
+            loadFilePage(true);
+        }
 
-            fetch('/list')
+        // loadFilePage fetches the next page of the listing and appends it,
+        // replacing the list instead when reset is set.
+        function loadFilePage(reset) {
+            if (listObserver) {
+                listObserver.disconnect();
+                listObserver = null;
+            }
+
+            fetch('/list?limit=' + listPageSize + '&delimiter=/&prefix=' + encodeURIComponent(currentPrefix) + '&cursor=' + encodeURIComponent(listCursor))
             .then(response => response.json())
             .then(data => {
                 hideLoading('refreshBtn', 'refreshText', 'Refresh List');
                 const fileList = document.getElementById('fileList');
+                const datalist = document.getElementById('fileNames');
 
-                if (data.success) {
-                    if (data.files.length === 0) {
-                        fileList.innerHTML = '<div class="empty-state">&#128237; No files in bucket<br><small>Upload a file to get started</small></div>';
-                    } else {
-                        fileList.innerHTML = data.files.map(function(file) {
-                            var fileName = file.name || file;
-                            var fileSize = formatFileSize(file.size || 0);
-                            var uploadDate = 'Unknown';
-
-                            if (file.lastModified) {
-                                var date = new Date(file.lastModified);
-                                uploadDate = date.toLocaleDateString() + ' ' + date.toLocaleTimeString([], {hour: '2-digit', minute:'2-digit'});
-                            }
-
-                            return '<div class="file-item">' +
-                                '<div class="file-info">' +
-                                    '<div class="file-name">&#128196; ' + fileName + '</div>' +
-                                    '<div style="font-size: 0.8em; color: #6b7280;">' +
-                                        fileSize + ' &bull; ' + uploadDate +
-                                    '</div>' +
-                                '</div>' +
-                                '<div>' +
-                                    '<button onclick="downloadFile(\'' + fileName + '\')" class="btn-download">' +
-                                        '&#128229; Download' +
-                                    '</button>' +
-                                    '<button onclick="deleteFile(\'' + fileName + '\')" class="btn-danger" style="padding: 6px 12px; font-size: 12px; margin-left: 5px;">' +
-                                        '&#128465;&#65039; Delete' +
-                                    '</button>' +
-                                '</div>' +
-                            '</div>';
-                        }).join('');
-                    }
-
-                    // Update autocomplete datalist
-                    const datalist = document.getElementById('fileNames');
-                    datalist.innerHTML = data.files.map(function(file) {
-                        return '<option value="' + (file.name || file) + '">';
-                    }).join('');
-                } else {
+                if (!data.success) {
                     fileList.innerHTML = '<div class="alert alert-error">' + data.error + '</div>';
+                    return;
+                }
+
+                if (reset) {
+                    metadataObserver.disconnect();
+                    fileList.innerHTML = '';
+                    datalist.innerHTML = '';
+                }
+                const more = document.getElementById('loadMore');
+                if (more) more.remove();
+
+                const prefixes = data.prefixes || [];
+                if (reset && data.files.length === 0 && prefixes.length === 0) {
+                    fileList.innerHTML = currentPrefix
+                        ? '<div class="empty-state">&#128237; This folder is empty</div>'
+                        : '<div class="empty-state">&#128237; No files in bucket<br><small>Upload a file to get started</small></div>';
+                    return;
+                }
+
+                fileList.insertAdjacentHTML('beforeend', prefixes.map(renderFolder).join('') + data.files.map(renderFile).join(''));
+                fileList.querySelectorAll('[data-pending-key]').forEach(function(item) {
+                    metadataObserver.observe(item);
+                });
+
+                // Update autocomplete datalist
+                datalist.insertAdjacentHTML('beforeend', data.files.map(function(file) {
+                    return '<option value="' + (file.name || file) + '">';
+                }).join(''));
+
+                listCursor = data.nextCursor || '';
+                if (listCursor) {
+                    // Load the next page once the end of the list scrolls into view
+                    fileList.insertAdjacentHTML('beforeend', '<div style="text-align: center;"><button id="loadMore" class="btn-secondary" onclick="loadFilePage(false)">Load more</button></div>');
+                    listObserver = new IntersectionObserver(function(entries) {
+                        if (entries[0].isIntersecting) loadFilePage(false);
+                    });
+                    listObserver.observe(document.getElementById('loadMore'));
                 }
             })
             .catch(error => {
@@ -661,6 +862,15 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
             });
         }
 
+        function formatRemaining(expires) {
+            const minutes = Math.ceil((expires - new Date()) / 60000);
+            if (minutes <= 0) return 'expired';
+            if (minutes < 60) return 'expires in ' + minutes + 'm';
+            const hours = Math.floor(minutes / 60);
+            if (hours < 24) return 'expires in ' + hours + 'h ' + (minutes % 60) + 'm';
+            return 'expires in ' + Math.floor(hours / 24) + 'd ' + (hours % 24) + 'h';
+        }
+
         function formatFileSize(bytes) {
             if (bytes === 0) return '0 Bytes';
             const k = 1024;
@@ -683,48 +893,29 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
                 return;
             }
 
-            // Show progress for validation phase
             showProgress('downloadProgress', key, 0);
-            updateProgress('downloadProgress', 20);
 
             // First validate that the file exists
             fetch('/validate?key=' + encodeURIComponent(key))
             .then(response => {
-                updateProgress('downloadProgress', 50);
-                if (!response.ok) {
+                return response.json().catch(() => {
                     throw new Error('Server error: ' + response.status);
-                }
-                return response.json();
+                });
             })
             .then(data => {
                 if (data.success) {
-                    updateProgress('downloadProgress', 80);
-
-                    // Get file size for display
-                    return fetch('/list').then(response => response.json()).then(listData => {
-                        if (listData.success) {
-                            const fileInfo = listData.files.find(file => (file.name || file) === key);
-                            const fileSize = fileInfo ? fileInfo.size : 0;
-
-                            // Update progress with file size
-                            document.getElementById('downloadFileSize').textContent = formatFileSize(fileSize);
-                            updateProgress('downloadProgress', 100);
-
-                            // Start download
-                            setTimeout(() => {
-                                hideProgress('downloadProgress');
-                                showAlert('downloadResult', 'Download started! Check your browser downloads.', true);
-                                window.open('/download?key=' + encodeURIComponent(key));
-
-                                // Clear the input field
-                                if (!filename) {
-                                    document.getElementById('downloadKey').value = '';
-                                }
-                            }, 500);
-                        } else {
-                            throw new Error('Could not get file information');
-                        }
-                    });
+                    document.getElementById('downloadFileSize').textContent = formatFileSize(data.size || 0);
+                    updateProgress('downloadProgress', 0);
+
+                    // Start the download, and follow it on the server
+                    const id = Date.now().toString(36) + Math.random().toString(36).slice(2);
+                    window.open('/download?key=' + encodeURIComponent(key) + '&id=' + id);
+                    watchDownload(id, key);
+
+                    // Clear the input field
+                    if (!filename) {
+                        document.getElementById('downloadKey').value = '';
+                    }
                 } else {
                     hideProgress('downloadProgress');
                     showAlert('downloadResult', data.error, false);
@@ -736,6 +927,80 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
             });
         }
 
+        // watchDownload shows how much of the download id the server has sent,
+        // polling until it is done.
+        function watchDownload(id, key) {
+            let misses = 0;
+            const started = () => {
+                hideProgress('downloadProgress');
+                showAlert('downloadResult', 'Download started! Check your browser downloads.', true);
+            };
+            const poll = () => fetch('/progress?id=' + id)
+            .then(response => response.json())
+            .then(data => {
+                if (!data.success) {
+                    // The download may not have reached the server yet
+                    if (++misses < 20) setTimeout(poll, 500);
+                    else started();
+                    return;
+                }
+
+                if (data.total > 0) updateProgress('downloadProgress', data.bytes / data.total * 100);
+                let info = formatFileSize(data.bytes) + ' of ' + formatFileSize(data.total);
+                if (data.rate >= 1) info += ', ' + formatFileSize(Math.round(data.rate)) + '/s';
+                if (data.eta > 0 && !data.done) info += ', ' + data.eta + 's left';
+                document.getElementById('downloadFileSize').textContent = info;
+
+                if (!data.done) {
+                    setTimeout(poll, 500);
+                    return;
+                }
+                hideProgress('downloadProgress');
+                if (data.error) {
+                    showAlert('downloadResult', 'Download failed: ' + data.error, false);
+                } else {
+                    showAlert('downloadResult', 'Downloaded "' + key + '"', true);
+                }
+            })
+            .catch(started);
+            poll();
+        }
+
+        // shareLink asks the server for a presigned URL for key.
+        function shareLink(key, expires, upload) {
+            return fetch('/share?key=' + encodeURIComponent(key) + '&expires=' + expires + (upload ? '&upload=1' : ''))
+            .then(response => response.json())
+            .then(data => {
+                if (!data.success) throw new Error(data.error);
+                return data.url;
+            });
+        }
+
+        function copyShareLink(filename) {
+            shareLink(filename, '24h', false)
+            .then(url => navigator.clipboard.writeText(url))
+            .then(() => showAlert('fileList', 'Share link for "' + filename + '" copied to the clipboard (valid for 24 hours)', true))
+            .catch(error => showAlert('fileList', 'Failed to create share link: ' + error.message, false));
+        }
+
+        function requestUpload() {
+            const key = document.getElementById('requestKey').value.trim();
+            if (!key) {
+                showAlert('requestResult', 'Please enter a name for the uploaded file.', false);
+                return;
+            }
+
+            shareLink(key, document.getElementById('requestExpires').value, true)
+            .then(url => {
+                const container = document.getElementById('requestResult');
+                container.innerHTML = '<div class="alert alert-success">Send this command to the uploader:' +
+                    '<input type="text" readonly style="width: 100%; margin-top: 8px;" onclick="this.select()"></div>';
+                container.querySelector('input').value = "curl -T <file> -H 'If-None-Match: *' '" + url + "'";
+                navigator.clipboard.writeText(url).catch(() => {});
+            })
+            .catch(error => showAlert('requestResult', 'Failed to create upload link: ' + error.message, false));
+        }
+
         function deleteFile(filename) {
             if (!confirm('Are you sure you want to delete "' + filename + '"?\n\nThis action cannot be undone.')) {
                 return;
@@ -994,39 +1259,75 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	file, header, err := r.FormFile("file")
+	// The file is streamed to the bucket as it arrives, so the fields
+	// describing it have to come before it in the form
+	reader, err := r.MultipartReader()
 	if err != nil {
-		writeJSONResponse(w, map[string]interface{}{"success": false, "error": "Failed to read file"})
+		writeJSONError(w, http.StatusBadRequest, "Failed to read file")
 		return
 	}
-	defer file.Close()
 
-	// Create temporary file
-	tempFile, err := os.CreateTemp("", "tincan_upload_*_"+header.Filename)
-	if err != nil {
-		writeJSONResponse(w, map[string]interface{}{"success": false, "error": "Failed to create temp file"})
-		return
+	var ttl time.Duration
+	var once bool
+	size := int64(-1)
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Failed to read file")
+			return
+		}
+		if part.FormName() == "file" {
+			defer part.Close()
+			uploadFormFile(w, r, part, part.FileName(), size, ttl, once)
+			return
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, 64))
+		part.Close()
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Failed to read form")
+			return
+		}
+		switch v := string(value); part.FormName() {
+		case "ttl":
+			if v != "" {
+				if ttl, err = parseTTL(v); err != nil {
+					writeJSONError(w, http.StatusBadRequest, err.Error())
+					return
+				}
+			}
+		case "once":
+			once = v == "true"
+		case "size":
+			if size, err = strconv.ParseInt(v, 10, 64); err != nil || size < 0 {
+				writeJSONError(w, http.StatusBadRequest, "Invalid size parameter")
+				return
+			}
+		}
 	}
-	defer os.Remove(tempFile.Name())
-	defer tempFile.Close()
+}
 
-	// Copy uploaded file to temp file
-	_, err = io.Copy(tempFile, file)
-	if err != nil {
-		writeJSONResponse(w, map[string]interface{}{"success": false, "error": "Failed to save file"})
+// uploadFormFile uploads the file part of an upload form as name.
+func uploadFormFile(w http.ResponseWriter, r *http.Request, file io.Reader, name string, size int64, ttl time.Duration, once bool) {
+	if name == "" {
+		writeJSONError(w, http.StatusBadRequest, "Failed to read file")
 		return
 	}
 
-	// Upload to S3
-	client, err := s3client.New()
+	client, err := newStorage()
 	if err != nil {
-		writeJSONResponse(w, map[string]interface{}{"success": false, "error": "S3 client error: " + err.Error()})
+		writeJSONError(w, http.StatusInternalServerError, "Storage error: "+err.Error())
 		return
 	}
 
-	err = client.Upload(tempFile.Name(), header.Filename)
+	opts := expiryOptions(ttl)
+	if once {
+		opts = append(opts, storage.WithMetadata(map[string]string{storage.MetaBurn: "true"}))
+	}
+
+	err = client.UploadStream(r.Context(), file, size, name, opts...)
 	if err != nil {
-		writeJSONResponse(w, map[string]interface{}{"success": false, "error": "Upload failed: " + err.Error()})
+		writeJSONError(w, errorStatus(err), "Upload failed: "+err.Error())
 		return
 	}
 
@@ -1034,19 +1335,43 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleList(w http.ResponseWriter, r *http.Request) {
-	client, err := s3client.New()
+	client, err := newStorage()
 	if err != nil {
-		writeJSONResponse(w, map[string]interface{}{"success": false, "error": "S3 client error: " + err.Error()})
+		writeJSONError(w, http.StatusInternalServerError, "Storage error: "+err.Error())
 		return
 	}
 
-	files, err := client.List()
+	// Without a limit the whole listing is returned in one response
+	q := r.URL.Query()
+	opts := storage.ListOptions{Prefix: q.Get("prefix"), Delimiter: q.Get("delimiter"), Cursor: q.Get("cursor")}
+	if limit := q.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			writeJSONError(w, http.StatusBadRequest, "Invalid limit parameter")
+			return
+		}
+		opts.Limit = n
+	}
+
+	page, err := client.ListPage(r.Context(), opts)
+	for err == nil && opts.Limit == 0 && page.NextCursor != "" {
+		var next *storage.Page
+		opts.Cursor = page.NextCursor
+		if next, err = client.ListPage(r.Context(), opts); err == nil {
+			page.Files = append(page.Files, next.Files...)
+			page.Prefixes = append(page.Prefixes, next.Prefixes...)
+			page.NextCursor = next.NextCursor
+		}
+	}
 	if err != nil {
-		writeJSONResponse(w, map[string]interface{}{"success": false, "error": "Failed to list files: " + err.Error()})
+		writeJSONError(w, errorStatus(err), "Failed to list files: "+err.Error())
 		return
 	}
+	if page.Files == nil {
+		page.Files = []storage.FileInfo{}
+	}
 
-	writeJSONResponse(w, map[string]interface{}{"success": true, "files": files})
+	writeJSONResponse(w, map[string]interface{}{"success": true, "files": page.Files, "prefixes": page.Prefixes, "nextCursor": page.NextCursor})
 }
 
 func handleDownload(w http.ResponseWriter, r *http.Request) {
@@ -1056,30 +1381,144 @@ func handleDownload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	client, err := s3client.New()
+	client, err := newStorage()
 	if err != nil {
-		http.Error(w, "S3 client error: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Storage error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Create temporary file for download
-	tempFile, err := os.CreateTemp("", "tincan_download_*_"+filepath.Base(key))
+	info, err := client.StatContext(r.Context(), key)
 	if err != nil {
-		http.Error(w, "Failed to create temp file", http.StatusInternalServerError)
+		if errors.Is(err, storage.ErrNotExist) {
+			http.Error(w, "File '"+key+"' not found in bucket", http.StatusNotFound)
+		} else {
+			http.Error(w, "Download failed: "+err.Error(), errorStatus(err))
+		}
 		return
 	}
-	defer os.Remove(tempFile.Name())
-	defer tempFile.Close()
 
-	err = client.Download(key, tempFile.Name())
-	if err != nil {
-		http.Error(w, "Download failed: "+err.Error(), http.StatusInternalServerError)
+	// Headers are only sent with the first byte of the file, so that an
+	// error before it can still be reported
+	out := &headerWriter{ResponseWriter: w, header: func(h http.Header) {
+		h.Set("Content-Type", "application/octet-stream")
+		h.Set("Content-Disposition", "attachment; filename=\""+filepath.Base(key)+"\"")
+		h.Set("Cache-Control", "no-store")
+		if !info.Encrypted() && info.Compression() == "" {
+			h.Set("Content-Length", strconv.FormatInt(info.Size, 10))
+		}
+	}}
+
+	ctx := r.Context()
+	if id := r.URL.Query().Get("id"); id != "" {
+		ctx = trackDownload(ctx, id, key, info.Size)
+		defer func() { finishDownload(id, err) }()
+	}
+
+	// A burn-after-reading file is deleted by the download, so only one
+	// request can get it
+	err = downloadStream(ctx, client, key, out, transferOptions{})
+	switch {
+	case err == nil:
+		out.Write(nil) // an empty file still needs its headers
+	case out.sent:
+		// Cut the response short so the client does not take what it got
+		// for the whole file. A checksum mismatch may only be found once
+		// all of it was sent.
+		log.Printf("Download of %q failed: %v", key, err)
+		panic(http.ErrAbortHandler)
+	case errors.Is(err, storage.ErrClaimed):
+		http.Error(w, "File '"+key+"' is being downloaded by someone else", http.StatusConflict)
+	case errors.Is(err, storage.ErrNotExist):
+		http.Error(w, "File '"+key+"' not found in bucket", http.StatusNotFound)
+	default:
+		http.Error(w, "Download failed: "+err.Error(), errorStatus(err))
+	}
+}
+
+// webDownload is the progress of a download started by the page, which
+// polls /progress with the id it passed to /download.
+type webDownload struct {
+	progress storage.Progress
+	done     bool
+	err      string
+}
+
+var (
+	downloadsMu sync.Mutex
+	downloads   = make(map[string]*webDownload)
+)
+
+// trackDownload records the progress of the download id of key in
+// downloads.
+func trackDownload(ctx context.Context, id, key string, size int64) context.Context {
+	d := &webDownload{progress: storage.Progress{Key: key, Total: size}}
+	downloadsMu.Lock()
+	downloads[id] = d
+	downloadsMu.Unlock()
+
+	return storage.WithProgress(ctx, func(p storage.Progress) {
+		downloadsMu.Lock()
+		d.progress = p
+		downloadsMu.Unlock()
+	})
+}
+
+// finishDownload marks the download id as done, and forgets it once the
+// page has had time to see that.
+func finishDownload(id string, err error) {
+	downloadsMu.Lock()
+	if d := downloads[id]; d != nil {
+		d.done = true
+		if err != nil {
+			d.err = err.Error()
+		}
+	}
+	downloadsMu.Unlock()
+
+	time.AfterFunc(time.Minute, func() {
+		downloadsMu.Lock()
+		delete(downloads, id)
+		downloadsMu.Unlock()
+	})
+}
+
+func handleProgress(w http.ResponseWriter, r *http.Request) {
+	downloadsMu.Lock()
+	d, ok := downloads[r.URL.Query().Get("id")]
+	var state webDownload
+	if ok {
+		state = *d
+	}
+	downloadsMu.Unlock()
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "Unknown download")
 		return
 	}
 
-	// Serve the file
-	w.Header().Set("Content-Disposition", "attachment; filename=\""+filepath.Base(key)+"\"")
-	http.ServeFile(w, r, tempFile.Name())
+	writeJSONResponse(w, map[string]interface{}{
+		"success": true,
+		"bytes":   state.progress.Bytes,
+		"total":   state.progress.Total,
+		"rate":    state.progress.Rate,
+		"eta":     int(state.progress.ETA.Seconds()),
+		"done":    state.done,
+		"error":   state.err,
+	})
+}
+
+// headerWriter calls header before the first write to the response.
+type headerWriter struct {
+	http.ResponseWriter
+	header func(http.Header)
+	sent   bool
+}
+
+func (w *headerWriter) Write(p []byte) (int, error) {
+	if !w.sent {
+		w.header(w.Header())
+		w.sent = true
+	}
+	return w.ResponseWriter.Write(p)
 }
 
 func handleClean(w http.ResponseWriter, r *http.Request) {
@@ -1088,66 +1527,56 @@ func handleClean(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	client, err := s3client.New()
+	client, err := newStorage()
 	if err != nil {
-		writeJSONResponse(w, map[string]interface{}{"success": false, "error": "S3 client error: " + err.Error()})
+		writeJSONError(w, http.StatusInternalServerError, "Storage error: "+err.Error())
 		return
 	}
 
 	// Get list of files first
-	fileNames, err := client.ListNames()
+	files, err := client.ListContext(r.Context())
 	if err != nil {
-		writeJSONResponse(w, map[string]interface{}{"success": false, "error": "Failed to list files: " + err.Error()})
+		writeJSONError(w, errorStatus(err), "Failed to list files: "+err.Error())
 		return
 	}
 
 	// Delete each file
-	for _, fileName := range fileNames {
-		err = client.Delete(fileName)
+	for _, file := range files {
+		err = client.DeleteContext(r.Context(), file.Name)
 		if err != nil {
-			writeJSONResponse(w, map[string]interface{}{"success": false, "error": "Failed to delete " + fileName + ": " + err.Error()})
+			writeJSONError(w, errorStatus(err), "Failed to delete "+file.Name+": "+err.Error())
 			return
 		}
 	}
 
-	writeJSONResponse(w, map[string]interface{}{"success": true, "message": fmt.Sprintf("Deleted %d files", len(fileNames))})
+	writeJSONResponse(w, map[string]interface{}{"success": true, "message": fmt.Sprintf("Deleted %d files", len(files))})
 }
 
 func handleValidate(w http.ResponseWriter, r *http.Request) {
 	key := r.URL.Query().Get("key")
 	if key == "" {
-		writeJSONResponse(w, map[string]interface{}{"success": false, "error": "Missing key parameter"})
+		writeJSONError(w, http.StatusBadRequest, "Missing key parameter")
 		return
 	}
 
-	client, err := s3client.New()
+	client, err := newStorage()
 	if err != nil {
-		writeJSONResponse(w, map[string]interface{}{"success": false, "error": "S3 client error: " + err.Error()})
+		writeJSONError(w, http.StatusInternalServerError, "Storage error: "+err.Error())
 		return
 	}
 
-	// Get list of files to check if the file exists
-	fileNames, err := client.ListNames()
-	if err != nil {
-		writeJSONResponse(w, map[string]interface{}{"success": false, "error": "Failed to validate file: " + err.Error()})
+	// Check that the file exists
+	info, err := client.StatContext(r.Context(), key)
+	if errors.Is(err, storage.ErrNotExist) {
+		writeJSONError(w, http.StatusNotFound, "File '"+key+"' not found in bucket")
 		return
 	}
-
-	// Check if the file exists in the list
-	fileExists := false
-	for _, fileName := range fileNames {
-		if fileName == key {
-			fileExists = true
-			break
-		}
-	}
-
-	if !fileExists {
-		writeJSONResponse(w, map[string]interface{}{"success": false, "error": "File '" + key + "' not found in bucket"})
+	if err != nil {
+		writeJSONError(w, errorStatus(err), "Failed to validate file: "+err.Error())
 		return
 	}
 
-	writeJSONResponse(w, map[string]interface{}{"success": true, "message": "File exists and is ready for download"})
+	writeJSONResponse(w, map[string]interface{}{"success": true, "message": "File exists and is ready for download", "size": info.Size, "metadata": info.Metadata})
 }
 
 func handleDelete(w http.ResponseWriter, r *http.Request) {
@@ -1158,26 +1587,115 @@ func handleDelete(w http.ResponseWriter, r *http.Request) {
 
 	key := r.URL.Query().Get("key")
 	if key == "" {
-		writeJSONResponse(w, map[string]interface{}{"success": false, "error": "Missing key parameter"})
+		writeJSONError(w, http.StatusBadRequest, "Missing key parameter")
 		return
 	}
 
-	client, err := s3client.New()
+	client, err := newStorage()
 	if err != nil {
-		writeJSONResponse(w, map[string]interface{}{"success": false, "error": "S3 client error: " + err.Error()})
+		writeJSONError(w, http.StatusInternalServerError, "Storage error: "+err.Error())
 		return
 	}
 
-	err = client.Delete(key)
+	err = client.DeleteContext(r.Context(), key)
 	if err != nil {
-		writeJSONResponse(w, map[string]interface{}{"success": false, "error": "Failed to delete file: " + err.Error()})
+		writeJSONError(w, errorStatus(err), "Failed to delete file: "+err.Error())
 		return
 	}
 
 	writeJSONResponse(w, map[string]interface{}{"success": true, "message": "File deleted successfully"})
 }
 
+func handleShare(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		writeJSONError(w, http.StatusBadRequest, "Missing key parameter")
+		return
+	}
+	expires := 24 * time.Hour
+	if v := r.URL.Query().Get("expires"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid expires parameter")
+			return
+		}
+		expires = d
+	}
+
+	client, err := newStorage()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Storage error: "+err.Error())
+		return
+	}
+	presigner, ok := client.(storage.Presigner)
+	if !ok {
+		writeJSONError(w, http.StatusNotImplemented, "Share links are not supported by this storage backend")
+		return
+	}
+
+	var url string
+	if r.URL.Query().Get("upload") != "" {
+		if _, err := client.StatContext(r.Context(), key); err == nil {
+			writeJSONError(w, http.StatusConflict, "File '"+key+"' already exists and an upload link cannot replace it")
+			return
+		} else if !errors.Is(err, storage.ErrNotExist) {
+			writeJSONError(w, errorStatus(err), "Failed to check file: "+err.Error())
+			return
+		}
+		url, err = presigner.PresignPut(r.Context(), key, expires)
+	} else {
+		var info *storage.FileInfo
+		info, err = client.StatContext(r.Context(), key)
+		if errors.Is(err, storage.ErrNotExist) {
+			writeJSONError(w, http.StatusNotFound, "File '"+key+"' not found in bucket")
+			return
+		} else if err != nil {
+			writeJSONError(w, errorStatus(err), "Failed to find file: "+err.Error())
+			return
+		}
+		if info.BurnAfterReading() {
+			writeJSONError(w, http.StatusConflict, "File '"+key+"' is deleted by its first download and cannot be shared with a link")
+			return
+		}
+		url, err = presigner.PresignGet(r.Context(), key, expires)
+	}
+	if err != nil {
+		writeJSONError(w, errorStatus(err), "Failed to create link: "+err.Error())
+		return
+	}
+
+	writeJSONResponse(w, map[string]interface{}{"success": true, "url": url, "expires": time.Now().Add(expires)})
+}
+
 func writeJSONResponse(w http.ResponseWriter, data map[string]interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(data)
-}
\ No newline at end of file
+}
+
+// writeJSONError reports a failure with the given status, in the same shape
+// as writeJSONResponse so the page can show the message.
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": message})
+}
+
+// errorStatus returns the HTTP status for a failed storage operation.
+// Problems with the bucket itself are the server's upstream failing, not
+// the request.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrClaimed):
+		return http.StatusConflict
+	case errors.Is(err, storage.ErrAccessDenied), errors.Is(err, fs.ErrPermission):
+		return http.StatusForbidden
+	case errors.Is(err, storage.ErrThrottled):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, storage.ErrNoBucket), errors.Is(err, storage.ErrChecksum):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -4,20 +4,78 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/spf13/viper"
+	embeddedcreds "tincan/internal/credentials"
+	"tincan/pkg/storage"
+)
+
+// Credential sources for the S3 backend
+const (
+	CredentialsDefault  = "default"  // AWS SDK chain: env, ~/.aws, IAM role
+	CredentialsStatic   = "static"   // aws_access_key_id / aws_secret_access_key
+	CredentialsShared   = "shared"   // aws_profile from the AWS shared config files
+	CredentialsEmbedded = "embedded" // keys baked in at build time
 )
 
 type Config struct {
-	BucketName      string `mapstructure:"bucket_name"`
-	AWSRegion       string `mapstructure:"aws_region"`
-	AWSAccessKeyID  string `mapstructure:"aws_access_key_id"`
-	AWSSecretKey    string `mapstructure:"aws_secret_access_key"`
+	// Profile is the name of the profile applied on top of the top-level
+	// settings, if any.
+	Profile string `mapstructure:"profile"`
+
+	Backend          string `mapstructure:"backend"`
+	LocalPath        string `mapstructure:"local_path"`
+	StateDir         string `mapstructure:"state_dir"`
+	IdentityFile     string `mapstructure:"identity_file"`
+	BucketName       string `mapstructure:"bucket_name"`
+	Prefix           string `mapstructure:"prefix"`
+	AWSRegion        string `mapstructure:"aws_region"`
+	CredentialSource string `mapstructure:"credential_source"`
+	AWSProfile       string `mapstructure:"aws_profile"`
+	AWSAccessKeyID   string `mapstructure:"aws_access_key_id"`
+	AWSSecretKey     string `mapstructure:"aws_secret_access_key"`
+
+	// S3-compatible stores (MinIO, Ceph, R2, ...)
+	EndpointURL        string `mapstructure:"endpoint_url"`
+	UsePathStyle       bool   `mapstructure:"use_path_style"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+
+	// Transfer tuning for the S3 backend
+	PartSizeMB           int64 `mapstructure:"part_size_mb"`
+	UploadConcurrency    int   `mapstructure:"upload_concurrency"`
+	DownloadConcurrency  int   `mapstructure:"download_concurrency"`
+	MultipartThresholdMB int64 `mapstructure:"multipart_threshold_mb"`
+
+	// RequestTimeout bounds each S3 request; zero disables it
+	RequestTimeout time.Duration `mapstructure:"request_timeout"`
+
+	// Failed S3 requests are retried up to RetryMaxAttempts times in all,
+	// waiting RetryBaseDelay, doubling up to RetryMaxDelay, in between
+	RetryMaxAttempts int           `mapstructure:"retry_max_attempts"`
+	RetryBaseDelay   time.Duration `mapstructure:"retry_base_delay"`
+	RetryMaxDelay    time.Duration `mapstructure:"retry_max_delay"`
+
+	// LimitRate bounds the bandwidth of all transfers together, e.g.
+	// "5MB/s"; empty means no limit. LimitSchedule entries such as
+	// "08:00-19:00=1MB/s" replace it at times of day for watch and sync.
+	LimitRate     string   `mapstructure:"limit_rate"`
+	LimitSchedule []string `mapstructure:"limit_schedule"`
 }
 
 func Load() (*Config, error) {
 	// Set default values
-	viper.SetDefault("aws_region", "us-east-1")
+	viper.SetDefault("backend", "s3")
+	viper.SetDefault("part_size_mb", 16)
+	viper.SetDefault("upload_concurrency", 4)
+	viper.SetDefault("download_concurrency", 4)
+	viper.SetDefault("use_path_style", false)
+	viper.SetDefault("insecure_skip_verify", false)
+	viper.SetDefault("multipart_threshold_mb", 64)
+	viper.SetDefault("request_timeout", "0s")
+	viper.SetDefault("retry_max_attempts", 5)
+	viper.SetDefault("retry_base_delay", "200ms")
+	viper.SetDefault("retry_max_delay", "20s")
 
 	// Config file name (without extension)
 	viper.SetConfigName("tincan")
@@ -27,12 +85,35 @@ func Load() (*Config, error) {
 	if home, err := os.UserHomeDir(); err == nil {
 		viper.AddConfigPath(home)
 		viper.AddConfigPath(filepath.Join(home, ".config"))
+		viper.SetDefault("state_dir", filepath.Join(home, ".tincan"))
+		viper.SetDefault("identity_file", filepath.Join(home, ".tincan", "identity"))
 	}
 	viper.AddConfigPath(".")
 
-	// Environment variable prefix
+	// Credentials embedded at build time act as defaults
+	if embeddedcreds.HasEmbeddedCredentials() {
+		viper.SetDefault("bucket_name", embeddedcreds.BucketName)
+		viper.SetDefault("aws_region", embeddedcreds.Region)
+		viper.SetDefault("credential_source", CredentialsEmbedded)
+	}
+
+	// Environment variable prefix. Keys without a default are bound
+	// explicitly so Unmarshal sees them; TINCAN_BUCKET is the historical
+	// name of TINCAN_BUCKET_NAME.
 	viper.SetEnvPrefix("TINCAN")
 	viper.AutomaticEnv()
+	viper.BindEnv("profile")
+	viper.BindEnv("local_path")
+	viper.BindEnv("bucket_name", "TINCAN_BUCKET_NAME", "TINCAN_BUCKET")
+	viper.BindEnv("prefix")
+	viper.BindEnv("aws_region")
+	viper.BindEnv("credential_source")
+	viper.BindEnv("aws_profile")
+	viper.BindEnv("aws_access_key_id")
+	viper.BindEnv("aws_secret_access_key")
+	viper.BindEnv("endpoint_url")
+	viper.BindEnv("limit_rate")
+	viper.BindEnv("limit_schedule")
 
 	// Read config file (optional)
 	if err := viper.ReadInConfig(); err != nil {
@@ -41,15 +122,72 @@ func Load() (*Config, error) {
 		}
 	}
 
+	// Settings of the selected profile override the top-level ones of the
+	// file, but not environment variables or flags
+	if name := viper.GetString("profile"); name != "" {
+		profile := viper.Sub("profiles." + name)
+		if profile == nil {
+			return nil, fmt.Errorf("profile %q not found in config file", name)
+		}
+		if err := viper.MergeConfigMap(profile.AllSettings()); err != nil {
+			return nil, fmt.Errorf("unable to decode profile %q: %w", name, err)
+		}
+	}
+
 	var config Config
 	if err := viper.Unmarshal(&config); err != nil {
 		return nil, fmt.Errorf("unable to decode config: %w", err)
 	}
 
-	// Validate required fields
-	if config.BucketName == "" {
-		return nil, fmt.Errorf("bucket_name is required (set TINCAN_BUCKET_NAME environment variable or add to config file)")
+	if config.CredentialSource == "" {
+		config.CredentialSource = CredentialsDefault
+		if config.AWSAccessKeyID != "" {
+			config.CredentialSource = CredentialsStatic
+		}
+	}
+
+	// Validate required fields; bucket settings are checked by the S3 backend
+	switch config.Backend {
+	case "s3", "memory":
+	case "local":
+		if config.LocalPath == "" {
+			return nil, fmt.Errorf("local_path is required for the local backend (set TINCAN_LOCAL_PATH environment variable or add to config file)")
+		}
+	default:
+		return nil, fmt.Errorf("unknown backend %q (expected s3, local or memory)", config.Backend)
+	}
+	if config.PartSizeMB < 5 {
+		return nil, fmt.Errorf("part_size_mb must be at least 5 (S3 minimum part size)")
+	}
+	if config.MultipartThresholdMB < 1 {
+		return nil, fmt.Errorf("multipart_threshold_mb must be at least 1")
+	}
+	if config.UploadConcurrency < 1 || config.DownloadConcurrency < 1 {
+		return nil, fmt.Errorf("upload_concurrency and download_concurrency must be at least 1")
+	}
+	if config.RetryMaxAttempts < 1 {
+		return nil, fmt.Errorf("retry_max_attempts must be at least 1")
+	}
+	if _, err := storage.ParseRate(config.LimitRate); err != nil {
+		return nil, fmt.Errorf("limit_rate: %w", err)
+	}
+	switch config.CredentialSource {
+	case CredentialsDefault:
+	case CredentialsEmbedded:
+		if !embeddedcreds.HasEmbeddedCredentials() {
+			return nil, fmt.Errorf("credential_source embedded requires a build with embedded credentials")
+		}
+	case CredentialsStatic:
+		if config.AWSAccessKeyID == "" || config.AWSSecretKey == "" {
+			return nil, fmt.Errorf("credential_source static requires aws_access_key_id and aws_secret_access_key")
+		}
+	case CredentialsShared:
+		if config.AWSProfile == "" {
+			return nil, fmt.Errorf("credential_source shared requires aws_profile")
+		}
+	default:
+		return nil, fmt.Errorf("unknown credential_source %q (expected default, static, shared or embedded)", config.CredentialSource)
 	}
 
 	return &config, nil
-}
\ No newline at end of file
+}
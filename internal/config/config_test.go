@@ -0,0 +1,134 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+const testConfig = `
+bucket_name: top-bucket
+aws_region: us-east-1
+prefix: top/
+part_size_mb: 8
+profile: home
+profiles:
+  work:
+    bucket_name: work-bucket
+    aws_region: eu-west-1
+    part_size_mb: 32
+  home:
+    bucket_name: home-bucket
+  offline:
+    backend: local
+    local_path: /srv/tincan
+`
+
+// loadWith loads the config file content from a fresh home directory, with
+// the TINCAN_* variables in env set and all others cleared.
+func loadWith(t *testing.T, content string, env map[string]string) (*Config, error) {
+	t.Helper()
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	for _, kv := range os.Environ() {
+		if name, _, _ := strings.Cut(kv, "="); strings.HasPrefix(name, "TINCAN_") {
+			t.Setenv(name, "")
+		}
+	}
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if content != "" {
+		if err := os.WriteFile(filepath.Join(home, "tincan.yaml"), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return Load()
+}
+
+func TestProfiles(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		env    map[string]string
+		bucket string
+		region string
+		prefix string
+		part   int64
+	}{
+		{"default profile from the file", nil, "home-bucket", "us-east-1", "top/", 8},
+		{"profile from the environment", map[string]string{"TINCAN_PROFILE": "work"}, "work-bucket", "eu-west-1", "top/", 32},
+		{"environment over the profile", map[string]string{"TINCAN_PROFILE": "work", "TINCAN_BUCKET_NAME": "env-bucket", "TINCAN_AWS_REGION": "ap-south-1"}, "env-bucket", "ap-south-1", "top/", 32},
+		{"historical variable over the profile", map[string]string{"TINCAN_BUCKET": "old-env-bucket"}, "old-env-bucket", "us-east-1", "top/", 8},
+		{"environment over the file", map[string]string{"TINCAN_PREFIX": "env/", "TINCAN_PART_SIZE_MB": "64"}, "home-bucket", "us-east-1", "env/", 64},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := loadWith(t, testConfig, tc.env)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if cfg.BucketName != tc.bucket || cfg.AWSRegion != tc.region || cfg.Prefix != tc.prefix || cfg.PartSizeMB != tc.part {
+				t.Fatalf("bucket %q, region %q, prefix %q, part size %d; want %q, %q, %q, %d",
+					cfg.BucketName, cfg.AWSRegion, cfg.Prefix, cfg.PartSizeMB, tc.bucket, tc.region, tc.prefix, tc.part)
+			}
+		})
+	}
+}
+
+func TestProfileSelection(t *testing.T) {
+	cfg, err := loadWith(t, testConfig, map[string]string{"TINCAN_PROFILE": "work"})
+	if err != nil || cfg.Profile != "work" {
+		t.Fatalf("Load = %+v, %v", cfg, err)
+	}
+
+	// Settings the profile leaves out come from the top level
+	cfg, err = loadWith(t, testConfig, map[string]string{"TINCAN_PROFILE": "offline"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Backend != "local" || cfg.LocalPath != "/srv/tincan" || cfg.BucketName != "top-bucket" {
+		t.Fatalf("offline profile = backend %q, path %q, bucket %q", cfg.Backend, cfg.LocalPath, cfg.BucketName)
+	}
+
+	if _, err := loadWith(t, testConfig, map[string]string{"TINCAN_PROFILE": "missing"}); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("Load of a missing profile = %v", err)
+	}
+	if _, err := loadWith(t, "", map[string]string{"TINCAN_PROFILE": "work"}); err == nil {
+		t.Fatal("Load of a profile without a config file succeeded")
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	cfg, err := loadWith(t, "", nil)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Backend != "s3" || cfg.PartSizeMB != 16 || cfg.UploadConcurrency != 4 || cfg.RetryMaxAttempts != 5 {
+		t.Fatalf("defaults = %+v", cfg)
+	}
+	if cfg.CredentialSource != CredentialsDefault {
+		t.Fatalf("credential source = %q, want %q", cfg.CredentialSource, CredentialsDefault)
+	}
+
+	cfg, err = loadWith(t, "", map[string]string{"TINCAN_AWS_ACCESS_KEY_ID": "id", "TINCAN_AWS_SECRET_ACCESS_KEY": "secret"})
+	if err != nil || cfg.CredentialSource != CredentialsStatic {
+		t.Fatalf("Load with static keys = %+v, %v", cfg, err)
+	}
+
+	for _, content := range []string{"backend: local", "backend: ftp", "part_size_mb: 1", "multipart_threshold_mb: 0", "multipart_threshold_mb: -5", "limit_rate: fast", "credential_source: shared"} {
+		if _, err := loadWith(t, content, nil); err == nil {
+			t.Errorf("Load of %q succeeded", content)
+		}
+	}
+	for _, v := range []string{"0", "-1"} {
+		if _, err := loadWith(t, testConfig, map[string]string{"TINCAN_MULTIPART_THRESHOLD_MB": v}); err == nil || !strings.Contains(err.Error(), "multipart_threshold_mb") {
+			t.Errorf("Load with TINCAN_MULTIPART_THRESHOLD_MB=%s = %v", v, err)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// Package compress wraps the streaming codecs TinCan can compress uploads
+// with. Codec names are recorded in object metadata so downloads know how to
+// reverse them.
+package compress
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+// Valid reports whether codec is a supported codec name.
+func Valid(codec string) bool {
+	return codec == Gzip || codec == Zstd
+}
+
+// NewWriter returns a writer that compresses into w with codec. Close must
+// be called to flush the stream; it does not close w.
+func NewWriter(w io.Writer, codec string) (io.WriteCloser, error) {
+	switch codec {
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	case Zstd:
+		return zstd.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("unsupported compression %q (expected gzip or zstd)", codec)
+	}
+}
+
+// NewReader returns a reader that decompresses the codec stream read from r.
+func NewReader(r io.Reader, codec string) (io.ReadCloser, error) {
+	switch codec {
+	case Gzip:
+		return gzip.NewReader(r)
+	case Zstd:
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %q (expected gzip or zstd)", codec)
+	}
+}
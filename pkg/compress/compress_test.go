@@ -0,0 +1,97 @@
+package compress
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	random := make([]byte, 256<<10)
+	rand.Read(random)
+	inputs := map[string][]byte{
+		"empty":  nil,
+		"short":  []byte("hello"),
+		"text":   []byte(strings.Repeat("all work and no play makes jack a dull boy\n", 50000)),
+		"random": random,
+	}
+
+	for _, codec := range []string{Gzip, Zstd} {
+		for name, data := range inputs {
+			t.Run(codec+"/"+name, func(t *testing.T) {
+				var buf bytes.Buffer
+				w, err := NewWriter(&buf, codec)
+				if err != nil {
+					t.Fatalf("NewWriter: %v", err)
+				}
+				// Written in pieces, as it arrives from a file
+				for p := data; len(p) > 0; {
+					n := min(len(p), 10000)
+					if _, err := w.Write(p[:n]); err != nil {
+						t.Fatalf("Write: %v", err)
+					}
+					p = p[n:]
+				}
+				if err := w.Close(); err != nil {
+					t.Fatalf("Close: %v", err)
+				}
+				if name == "text" && buf.Len() > len(data)/10 {
+					t.Errorf("compressed %d bytes of text to %d", len(data), buf.Len())
+				}
+
+				r, err := NewReader(&buf, codec)
+				if err != nil {
+					t.Fatalf("NewReader: %v", err)
+				}
+				defer r.Close()
+				got, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatalf("ReadAll: %v", err)
+				}
+				if !bytes.Equal(got, data) {
+					t.Fatalf("got %d bytes back, want %d", len(got), len(data))
+				}
+			})
+		}
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	data := make([]byte, 100<<10)
+	rand.Read(data)
+
+	for _, codec := range []string{Gzip, Zstd} {
+		var buf bytes.Buffer
+		w, _ := NewWriter(&buf, codec)
+		w.Write(data)
+		w.Close()
+
+		r, err := NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()/2]), codec)
+		if err != nil {
+			continue
+		}
+		if _, err := io.ReadAll(r); err == nil {
+			t.Errorf("%s: reading a truncated stream succeeded", codec)
+		}
+		r.Close()
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	for codec, valid := range map[string]bool{Gzip: true, Zstd: true, "": false, "brotli": false, "GZIP": false} {
+		if Valid(codec) != valid {
+			t.Errorf("Valid(%q) = %v", codec, !valid)
+		}
+		if valid {
+			continue
+		}
+		if _, err := NewWriter(io.Discard, codec); err == nil {
+			t.Errorf("NewWriter(%q) succeeded", codec)
+		}
+		if _, err := NewReader(strings.NewReader(""), codec); err == nil {
+			t.Errorf("NewReader(%q) succeeded", codec)
+		}
+	}
+}
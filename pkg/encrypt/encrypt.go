@@ -0,0 +1,271 @@
+// Package encrypt implements the streaming authenticated encryption format
+// used for client-side encrypted uploads.
+//
+// An encrypted stream starts with a header identifying how the random file
+// key is protected (by a passphrase or an X25519 recipient key), followed by
+// the content split into 64 KiB chunks sealed with AES-256-GCM. Each chunk's
+// nonce carries its index and a final-chunk flag, so reordered, duplicated or
+// truncated streams fail to decrypt.
+package encrypt
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// Object metadata values recorded for encrypted uploads
+	MethodPassphrase = "passphrase"
+	MethodX25519     = "x25519"
+
+	magic     = "TINCANE1"
+	chunkSize = 64 << 10
+	keySize   = 32
+)
+
+var (
+	// ErrWrongKey is returned when none of the given identities can open a stream.
+	ErrWrongKey = errors.New("wrong passphrase or key")
+	// ErrCorrupt is returned when the ciphertext fails authentication.
+	ErrCorrupt = errors.New("encrypted data is corrupt or truncated")
+)
+
+// Recipient protects a file key so that a matching Identity can recover it.
+type Recipient interface {
+	Method() string
+	wrap(fileKey []byte) (stanza []byte, err error)
+}
+
+// Identity recovers a file key wrapped for its Recipient.
+type Identity interface {
+	Method() string
+	unwrap(stanza []byte) (fileKey []byte, err error)
+}
+
+// NewWriter returns a writer that encrypts everything written to it for r
+// and writes the result to w. Close must be called to flush the final chunk.
+func NewWriter(w io.Writer, r Recipient) (io.WriteCloser, error) {
+	fileKey := make([]byte, keySize)
+	if _, err := rand.Read(fileKey); err != nil {
+		return nil, err
+	}
+
+	stanza, err := r.wrap(fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, len(magic)+1+2+len(stanza))
+	header = append(header, magic...)
+	header = append(header, methodByte(r.Method()))
+	header = binary.BigEndian.AppendUint16(header, uint16(len(stanza)))
+	header = append(header, stanza...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &writer{w: w, aead: aead, buf: make([]byte, 0, chunkSize)}, nil
+}
+
+// NewReader returns a reader that decrypts the stream read from r using the
+// first identity whose method matches the stream header.
+func NewReader(r io.Reader, ids ...Identity) (io.Reader, error) {
+	br := bufio.NewReader(r)
+
+	prefix := make([]byte, len(magic)+1+2)
+	if _, err := io.ReadFull(br, prefix); err != nil {
+		return nil, fmt.Errorf("not an encrypted stream: %w", err)
+	}
+	if string(prefix[:len(magic)]) != magic {
+		return nil, fmt.Errorf("not an encrypted stream")
+	}
+	method := methodName(prefix[len(magic)])
+	if method == "" {
+		return nil, fmt.Errorf("unsupported encryption method %d", prefix[len(magic)])
+	}
+	stanza := make([]byte, binary.BigEndian.Uint16(prefix[len(magic)+1:]))
+	if _, err := io.ReadFull(br, stanza); err != nil {
+		return nil, fmt.Errorf("not an encrypted stream: %w", err)
+	}
+
+	// A header that none of them can read is reported as such, rather
+	// than as the wrong key
+	var fileKey []byte
+	failure := ErrWrongKey
+	for _, id := range ids {
+		if id.Method() != method {
+			continue
+		}
+		key, err := id.unwrap(stanza)
+		if err == nil {
+			fileKey = key
+			break
+		}
+		if !errors.Is(err, ErrWrongKey) {
+			failure = err
+		}
+	}
+	if fileKey == nil {
+		return nil, failure
+	}
+
+	aead, err := newAEAD(fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reader{r: br, aead: aead}, nil
+}
+
+func methodByte(method string) byte {
+	switch method {
+	case MethodPassphrase:
+		return 1
+	case MethodX25519:
+		return 2
+	}
+	return 0
+}
+
+func methodName(b byte) string {
+	switch b {
+	case 1:
+		return MethodPassphrase
+	case 2:
+		return MethodX25519
+	}
+	return ""
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce is the chunk index followed by a flag marking the last chunk.
+// Every file has its own random key, so nonces never repeat under a key.
+func chunkNonce(nonce []byte, index uint64, last bool) {
+	clear(nonce)
+	binary.BigEndian.PutUint64(nonce[len(nonce)-9:], index)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+}
+
+type writer struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	buf    []byte
+	index  uint64
+	closed bool
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to closed encrypt writer")
+	}
+
+	written := 0
+	for len(p) > 0 {
+		// Only seal a full chunk once more data arrives, so the final
+		// chunk can always be flagged as last in Close.
+		if len(w.buf) == chunkSize {
+			if err := w.flush(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(w.buf[len(w.buf):chunkSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.flush(true)
+}
+
+func (w *writer) flush(last bool) error {
+	nonce := make([]byte, w.aead.NonceSize())
+	chunkNonce(nonce, w.index, last)
+	sealed := w.aead.Seal(nil, nonce, w.buf, nil)
+	w.index++
+	w.buf = w.buf[:0]
+
+	_, err := w.w.Write(sealed)
+	return err
+}
+
+type reader struct {
+	r     *bufio.Reader
+	aead  cipher.AEAD
+	buf   []byte
+	index uint64
+	done  bool
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *reader) next() error {
+	sealed := make([]byte, chunkSize+r.aead.Overhead())
+	n, err := io.ReadFull(r.r, sealed)
+	switch {
+	case err == io.EOF:
+		// The writer always emits a final chunk, even an empty one
+		return ErrCorrupt
+	case err != nil && err != io.ErrUnexpectedEOF:
+		return err
+	}
+
+	// A short chunk is always the last; a full one is last if nothing follows
+	last := n < len(sealed) || isEOF(r.r)
+
+	nonce := make([]byte, r.aead.NonceSize())
+	chunkNonce(nonce, r.index, last)
+	plain, err := r.aead.Open(sealed[:0], nonce, sealed[:n], nil)
+	if err != nil {
+		return ErrCorrupt
+	}
+
+	r.index++
+	r.buf = plain
+	r.done = last
+	return nil
+}
+
+func isEOF(r *bufio.Reader) bool {
+	_, err := r.Peek(1)
+	return err == io.EOF
+}
@@ -0,0 +1,258 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+// sealedChunk is the size of a full chunk in the encrypted stream.
+const sealedChunk = chunkSize + 16
+
+func encryptBytes(t *testing.T, data []byte, r Recipient) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, r)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func decryptBytes(data []byte, ids ...Identity) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(data), ids...)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func randomBytes(n int) []byte {
+	data := make([]byte, n)
+	rand.Read(data)
+	return data
+}
+
+// headerSize returns the length of the header of an encrypted stream.
+func headerSize(stream []byte) int {
+	return len(magic) + 3 + int(stream[len(magic)+1])<<8 + int(stream[len(magic)+2])
+}
+
+func newPassphrase(t *testing.T, s string) *Passphrase {
+	t.Helper()
+	p, err := NewPassphrase(s)
+	if err != nil {
+		t.Fatalf("NewPassphrase: %v", err)
+	}
+	return p
+}
+
+func TestRoundTrip(t *testing.T) {
+	passphrase := newPassphrase(t, "correct horse")
+	identity, err := GenerateIdentity()
+	if err != nil {
+		t.Fatalf("GenerateIdentity: %v", err)
+	}
+
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3 * chunkSize, 3*chunkSize + 100} {
+		data := randomBytes(size)
+
+		stream := encryptBytes(t, data, passphrase)
+		if got, err := decryptBytes(stream, passphrase); err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("passphrase round trip of %d bytes: %d bytes back, %v", size, len(got), err)
+		}
+
+		stream = encryptBytes(t, data, identity.Recipient())
+		if got, err := decryptBytes(stream, identity); err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("X25519 round trip of %d bytes: %d bytes back, %v", size, len(got), err)
+		}
+	}
+}
+
+func TestSmallWrites(t *testing.T) {
+	identity, _ := GenerateIdentity()
+	data := randomBytes(2*chunkSize + 10)
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, identity.Recipient())
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	for p := data; len(p) > 0; {
+		n := min(len(p), 1000)
+		w.Write(p[:n])
+		p = p[n:]
+	}
+	w.Close()
+
+	if got, err := decryptBytes(buf.Bytes(), identity); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("round trip of small writes: %d bytes back, %v", len(got), err)
+	}
+}
+
+func TestWrongKey(t *testing.T) {
+	data := randomBytes(1000)
+
+	stream := encryptBytes(t, data, newPassphrase(t, "right"))
+	if _, err := decryptBytes(stream, newPassphrase(t, "wrong")); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("wrong passphrase = %v, want ErrWrongKey", err)
+	}
+
+	identity, _ := GenerateIdentity()
+	other, _ := GenerateIdentity()
+	stream = encryptBytes(t, data, identity.Recipient())
+	if _, err := decryptBytes(stream, other); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("wrong identity = %v, want ErrWrongKey", err)
+	}
+	// Identities of another method are not tried at all
+	if _, err := decryptBytes(stream, newPassphrase(t, "right")); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("passphrase for an X25519 stream = %v, want ErrWrongKey", err)
+	}
+	// The matching one is found among several
+	if got, err := decryptBytes(stream, other, identity); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("decrypting with several identities = %v", err)
+	}
+}
+
+func TestTampering(t *testing.T) {
+	identity, _ := GenerateIdentity()
+	data := randomBytes(3*chunkSize + 100)
+	stream := encryptBytes(t, data, identity.Recipient())
+	header := headerSize(stream)
+	chunk := func(i int) []byte { return stream[header+i*sealedChunk : header+(i+1)*sealedChunk] }
+
+	join := func(parts ...[]byte) []byte {
+		var b []byte
+		for _, p := range parts {
+			b = append(b, p...)
+		}
+		return b
+	}
+	flipped := bytes.Clone(stream)
+	flipped[header+sealedChunk+10] ^= 1
+
+	for _, tc := range []struct {
+		name   string
+		stream []byte
+	}{
+		{"truncated mid-chunk", stream[:len(stream)-50]},
+		{"missing final chunk", stream[:header+3*sealedChunk]},
+		{"only full chunks", stream[:header+2*sealedChunk]},
+		{"header only", stream[:header]},
+		{"swapped chunks", join(stream[:header], chunk(1), chunk(0), stream[header+2*sealedChunk:])},
+		{"duplicated chunk", join(stream[:header+sealedChunk], chunk(0), stream[header+sealedChunk:])},
+		{"flipped bit", flipped},
+		{"appended chunk", join(stream, chunk(0))},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := decryptBytes(tc.stream, identity)
+			if !errors.Is(err, ErrCorrupt) {
+				t.Fatalf("decrypting = %d bytes, %v; want ErrCorrupt", len(got), err)
+			}
+		})
+	}
+}
+
+func TestExactChunks(t *testing.T) {
+	// A stream of whole chunks ends with a full chunk flagged as last,
+	// which must not be taken for a stream cut off at a chunk boundary
+	identity, _ := GenerateIdentity()
+	for _, size := range []int{chunkSize, 2 * chunkSize} {
+		stream := encryptBytes(t, randomBytes(size), identity.Recipient())
+		if want := headerSize(stream) + size/chunkSize*sealedChunk; len(stream) != want {
+			t.Fatalf("stream of %d bytes is %d bytes long, want %d", size, len(stream), want)
+		}
+	}
+
+	// An empty file still has its (empty) final chunk
+	stream := encryptBytes(t, nil, identity.Recipient())
+	if len(stream) != headerSize(stream)+16 {
+		t.Fatalf("stream of nothing is %d bytes long", len(stream))
+	}
+	if _, err := decryptBytes(stream[:headerSize(stream)], identity); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("empty stream without its final chunk = %v, want ErrCorrupt", err)
+	}
+}
+
+func TestCorruptHeader(t *testing.T) {
+	passphrase := newPassphrase(t, "secret")
+	stream := encryptBytes(t, []byte("data"), passphrase)
+	stanza := len(magic) + 3
+
+	corrupt := func(i int, b byte) []byte {
+		s := bytes.Clone(stream)
+		s[i] = b
+		return s
+	}
+
+	if _, err := decryptBytes(corrupt(0, 'X'), passphrase); err == nil {
+		t.Error("stream with a bad magic decrypted")
+	}
+	if _, err := decryptBytes(corrupt(len(magic), 9), passphrase); err == nil {
+		t.Error("stream of an unknown method decrypted")
+	}
+	for _, logN := range []byte{0, 23, 255} {
+		if _, err := decryptBytes(corrupt(stanza, logN), passphrase); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("scrypt logN %d = %v, want ErrCorrupt", logN, err)
+		}
+	}
+	if _, err := decryptBytes(corrupt(stanza+1, stream[stanza+1]^1), passphrase); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("altered salt = %v, want ErrWrongKey", err)
+	}
+	if _, err := decryptBytes(stream[:stanza+5], passphrase); err == nil {
+		t.Error("stream with a truncated header decrypted")
+	}
+	if _, err := decryptBytes(nil, passphrase); err == nil {
+		t.Error("empty input decrypted")
+	}
+
+	identity, _ := GenerateIdentity()
+	stream = encryptBytes(t, []byte("data"), identity.Recipient())
+	short := bytes.Clone(stream[:len(magic)+3+10])
+	short[len(magic)+1], short[len(magic)+2] = 0, 10
+	if _, err := decryptBytes(short, identity); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("X25519 stanza of 10 bytes = %v, want ErrCorrupt", err)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	identity, err := GenerateIdentity()
+	if err != nil {
+		t.Fatalf("GenerateIdentity: %v", err)
+	}
+
+	parsed, err := ParseIdentity("# created by tincan keygen\n\n" + identity.String() + "\n")
+	if err != nil {
+		t.Fatalf("ParseIdentity: %v", err)
+	}
+	recipient, err := ParseRecipient(identity.Recipient().String())
+	if err != nil {
+		t.Fatalf("ParseRecipient: %v", err)
+	}
+	stream := encryptBytes(t, []byte("data"), recipient)
+	if got, err := decryptBytes(stream, parsed); err != nil || string(got) != "data" {
+		t.Fatalf("round trip through parsed keys = %q, %v", got, err)
+	}
+
+	for _, s := range []string{"", "tincan-pub-", "tincan-pub-!!", "age1abc", identity.String()} {
+		if _, err := ParseRecipient(s); err == nil {
+			t.Errorf("ParseRecipient(%q) succeeded", s)
+		}
+	}
+	for _, s := range []string{"", "# only a comment", "TINCAN-SECRET-KEY-!!", identity.Recipient().String()} {
+		if _, err := ParseIdentity(s); err == nil {
+			t.Errorf("ParseIdentity(%q) succeeded", s)
+		}
+	}
+	if _, err := NewPassphrase(""); err == nil {
+		t.Error("NewPassphrase accepted an empty passphrase")
+	}
+}
@@ -0,0 +1,219 @@
+package encrypt
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	publicKeyPrefix = "tincan-pub-"
+	secretKeyPrefix = "TINCAN-SECRET-KEY-"
+
+	saltSize = 16
+	// scrypt work factor, 2^15 iterations takes roughly 100ms
+	scryptLogN = 15
+)
+
+// Passphrase is both the Recipient and the Identity for passphrase-based
+// encryption. The wrapping key is derived with scrypt.
+type Passphrase struct {
+	passphrase string
+}
+
+func NewPassphrase(passphrase string) (*Passphrase, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("passphrase must not be empty")
+	}
+	return &Passphrase{passphrase: passphrase}, nil
+}
+
+func (p *Passphrase) Method() string { return MethodPassphrase }
+
+// The stanza is the scrypt work factor, the salt and the sealed file key.
+func (p *Passphrase) wrap(fileKey []byte) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	kek, err := scrypt.Key([]byte(p.passphrase), salt, 1<<scryptLogN, 8, 1, keySize)
+	if err != nil {
+		return nil, err
+	}
+
+	stanza := append([]byte{scryptLogN}, salt...)
+	return append(stanza, seal(kek, fileKey)...), nil
+}
+
+func (p *Passphrase) unwrap(stanza []byte) ([]byte, error) {
+	if len(stanza) < 1+saltSize {
+		return nil, ErrCorrupt
+	}
+	logN, salt := stanza[0], stanza[1:1+saltSize]
+	if logN < 1 || logN > 22 {
+		return nil, fmt.Errorf("scrypt work factor %d is out of range: %w", logN, ErrCorrupt)
+	}
+
+	kek, err := scrypt.Key([]byte(p.passphrase), salt, 1<<logN, 8, 1, keySize)
+	if err != nil {
+		return nil, err
+	}
+	return open(kek, stanza[1+saltSize:])
+}
+
+// X25519Recipient encrypts to the holder of the matching X25519Identity.
+type X25519Recipient struct {
+	key *ecdh.PublicKey
+}
+
+// ParseRecipient parses a public key as printed by X25519Identity.Recipient.
+func ParseRecipient(s string) (*X25519Recipient, error) {
+	raw, ok := strings.CutPrefix(strings.TrimSpace(s), publicKeyPrefix)
+	if !ok {
+		return nil, fmt.Errorf("malformed public key: missing %q prefix", publicKeyPrefix)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("malformed public key: %w", err)
+	}
+	key, err := ecdh.X25519().NewPublicKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("malformed public key: %w", err)
+	}
+	return &X25519Recipient{key: key}, nil
+}
+
+func (r *X25519Recipient) Method() string { return MethodX25519 }
+
+func (r *X25519Recipient) String() string {
+	return publicKeyPrefix + base64.RawURLEncoding.EncodeToString(r.key.Bytes())
+}
+
+// The stanza is an ephemeral public key and the file key sealed with a key
+// derived from the ephemeral-static shared secret.
+func (r *X25519Recipient) wrap(fileKey []byte) ([]byte, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := ephemeral.ECDH(r.key)
+	if err != nil {
+		return nil, err
+	}
+
+	kek, err := x25519KEK(shared, ephemeral.PublicKey().Bytes(), r.key.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return append(ephemeral.PublicKey().Bytes(), seal(kek, fileKey)...), nil
+}
+
+// X25519Identity is a private key able to decrypt files encrypted to its
+// Recipient.
+type X25519Identity struct {
+	key *ecdh.PrivateKey
+}
+
+func GenerateIdentity() (*X25519Identity, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &X25519Identity{key: key}, nil
+}
+
+// ParseIdentity parses a secret key as printed by X25519Identity.String.
+// Blank lines and lines starting with # are ignored, so a key file may carry
+// comments.
+func ParseIdentity(s string) (*X25519Identity, error) {
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		raw, ok := strings.CutPrefix(line, secretKeyPrefix)
+		if !ok {
+			return nil, fmt.Errorf("malformed secret key: missing %q prefix", secretKeyPrefix)
+		}
+		b, err := base64.RawURLEncoding.DecodeString(raw)
+		if err != nil {
+			return nil, fmt.Errorf("malformed secret key: %w", err)
+		}
+		key, err := ecdh.X25519().NewPrivateKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("malformed secret key: %w", err)
+		}
+		return &X25519Identity{key: key}, nil
+	}
+	return nil, fmt.Errorf("no secret key found")
+}
+
+func (i *X25519Identity) Method() string { return MethodX25519 }
+
+func (i *X25519Identity) String() string {
+	return secretKeyPrefix + base64.RawURLEncoding.EncodeToString(i.key.Bytes())
+}
+
+func (i *X25519Identity) Recipient() *X25519Recipient {
+	return &X25519Recipient{key: i.key.PublicKey()}
+}
+
+func (i *X25519Identity) unwrap(stanza []byte) ([]byte, error) {
+	if len(stanza) < 32 {
+		return nil, ErrCorrupt
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(stanza[:32])
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	shared, err := i.key.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+
+	kek, err := x25519KEK(shared, stanza[:32], i.key.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return open(kek, stanza[32:])
+}
+
+func x25519KEK(shared, ephemeral, recipient []byte) ([]byte, error) {
+	salt := append(append([]byte{}, ephemeral...), recipient...)
+	kek := make([]byte, keySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte("tincan x25519")), kek); err != nil {
+		return nil, err
+	}
+	return kek, nil
+}
+
+// seal encrypts the file key under kek with a random nonce prepended.
+func seal(kek, fileKey []byte) []byte {
+	aead, _ := newAEAD(kek)
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+	return aead.Seal(nonce, nonce, fileKey, nil)
+}
+
+func open(kek, sealed []byte) ([]byte, error) {
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrCorrupt
+	}
+	fileKey, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrWrongKey
+	}
+	return fileKey, nil
+}
@@ -0,0 +1,108 @@
+package s3client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"tincan/pkg/storage"
+)
+
+// claimPrefix holds one lock object per burn-after-reading file that is
+// being downloaded.
+const claimPrefix = storage.InternalPrefix + "claims/"
+
+// claim creates the lock object for key, failing with storage.ErrClaimed if
+// another download holds it. The conditional PUT lets exactly one of several
+// simultaneous downloads succeed. The returned ETag identifies this claim
+// when it is renewed.
+func (c *Client) claim(ctx context.Context, key string) (string, error) {
+	lockKey := c.objectKey(claimPrefix + key)
+	for attempt := 0; ; attempt++ {
+		etag, err := c.putClaim(ctx, key, s3.WithAPIOptions(smithyhttp.AddHeaderValue("If-None-Match", "*")))
+		if err == nil {
+			return etag, nil
+		}
+		if !conditionFailed(err) {
+			return "", fmt.Errorf("unable to claim %q: %w", key, err)
+		}
+		if attempt > 0 {
+			return "", fmt.Errorf("%q: %w", key, storage.ErrClaimed)
+		}
+
+		// Take over a claim left behind by a download that never finished
+		reqCtx, cancel := c.requestContext(ctx)
+		head, err := c.s3Client.HeadObject(reqCtx, &s3.HeadObjectInput{
+			Bucket: aws.String(c.bucketName),
+			Key:    aws.String(lockKey),
+		})
+		cancel()
+		if err != nil || head.LastModified == nil || time.Since(*head.LastModified) < storage.ClaimTimeout {
+			return "", fmt.Errorf("%q: %w", key, storage.ErrClaimed)
+		}
+		if err := c.release(ctx, key); err != nil {
+			return "", err
+		}
+	}
+}
+
+// renewClaim rewrites the lock object for key, which resets its age, and
+// returns its new ETag. The rewrite only succeeds while the lock still has
+// the given ETag: once another download took the claim over as stale, it
+// fails with storage.ErrClaimed instead of overwriting that claim.
+func (c *Client) renewClaim(ctx context.Context, key, etag string) (string, error) {
+	etag, err := c.putClaim(ctx, key, s3.WithAPIOptions(smithyhttp.AddHeaderValue("If-Match", etag)))
+	if err != nil {
+		if conditionFailed(err) || errors.Is(err, ErrNotFound) {
+			return "", fmt.Errorf("%q: lost claim: %w", key, storage.ErrClaimed)
+		}
+		return "", fmt.Errorf("unable to renew claim on %q: %w", key, err)
+	}
+	return etag, nil
+}
+
+// putClaim writes the lock object for key. Its content is unique, so that
+// no two claims share an ETag.
+func (c *Client) putClaim(ctx context.Context, key string, optFns ...func(*s3.Options)) (string, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+
+	body := fmt.Sprintf("%s %016x", time.Now().UTC().Format(time.RFC3339Nano), rand.Uint64())
+	out, err := c.s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(c.bucketName),
+		Key:    aws.String(c.objectKey(claimPrefix + key)),
+		Body:   strings.NewReader(body),
+	}, optFns...)
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(out.ETag), nil
+}
+
+// conditionFailed reports whether err is S3 refusing a conditional request.
+func conditionFailed(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && (apiErr.ErrorCode() == "PreconditionFailed" || apiErr.ErrorCode() == "ConditionalRequestConflict")
+}
+
+// release removes the lock object for key.
+func (c *Client) release(ctx context.Context, key string) error {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+
+	_, err := c.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(c.bucketName),
+		Key:    aws.String(c.objectKey(claimPrefix + key)),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to release claim on %q: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package s3client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"tincan/pkg/storage"
+)
+
+// blockingWriter holds up a download at its first write until released.
+type blockingWriter struct {
+	started chan struct{}
+	release chan struct{}
+	once    bool
+}
+
+func newBlockingWriter() *blockingWriter {
+	return &blockingWriter{started: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	if !w.once {
+		w.once = true
+		close(w.started)
+		<-w.release
+	}
+	return len(p), nil
+}
+
+func TestClaims(t *testing.T) {
+	client, fake := newTestClient(t, false)
+	if fake == nil {
+		t.Skip("needs the stand-in server to age a claim")
+	}
+	defer func(d time.Duration) { storage.ClaimRefresh = d }(storage.ClaimRefresh)
+	storage.ClaimRefresh = 10 * time.Millisecond
+
+	burn := storage.WithMetadata(map[string]string{storage.MetaBurn: "true"})
+	lockKey := client.objectKey(claimPrefix + "slow")
+	age := func(d time.Duration) {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		fake.objects[lockKey].modified = time.Now().Add(-d)
+	}
+
+	// A download that outlasts ClaimTimeout keeps renewing its claim, so
+	// nobody takes it over
+	if err := client.UploadStream(context.Background(), strings.NewReader("secret"), -1, "slow", burn); err != nil {
+		t.Fatalf("UploadStream: %v", err)
+	}
+	w := newBlockingWriter()
+	done := make(chan error, 1)
+	go func() { done <- client.DownloadStream(context.Background(), "slow", w) }()
+	<-w.started
+	age(2 * storage.ClaimTimeout)
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		fake.mu.Lock()
+		renewed := time.Since(fake.objects[lockKey].modified) < time.Minute
+		fake.mu.Unlock()
+		if renewed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the claim of a running download was not renewed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := client.DownloadStream(context.Background(), "slow", io.Discard); !errors.Is(err, storage.ErrClaimed) {
+		t.Fatalf("download during a long one = %v, want ErrClaimed", err)
+	}
+	close(w.release)
+	if err := <-done; err != nil {
+		t.Fatalf("long download: %v", err)
+	}
+	if _, err := client.Stat("slow"); !errors.Is(err, storage.ErrNotExist) {
+		t.Fatalf("Stat after the download = %v, want ErrNotExist", err)
+	}
+
+	// A claim left behind by a crashed download is taken over once stale
+	if err := client.UploadStream(context.Background(), strings.NewReader("secret"), -1, "slow", burn); err != nil {
+		t.Fatalf("UploadStream: %v", err)
+	}
+	if _, err := client.claim(context.Background(), "slow"); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if err := client.DownloadStream(context.Background(), "slow", io.Discard); !errors.Is(err, storage.ErrClaimed) {
+		t.Fatalf("download of a claimed file = %v, want ErrClaimed", err)
+	}
+	age(2 * storage.ClaimTimeout)
+	var buf bytes.Buffer
+	if err := client.DownloadStream(context.Background(), "slow", &buf); err != nil || buf.String() != "secret" {
+		t.Fatalf("download after the claim went stale = %q, %v", buf.String(), err)
+	}
+}
+
+func TestLostClaim(t *testing.T) {
+	client, fake := newTestClient(t, false)
+	if fake == nil {
+		t.Skip("needs the stand-in server to take a claim over")
+	}
+	defer func(d time.Duration) { storage.ClaimRefresh = d }(storage.ClaimRefresh)
+	storage.ClaimRefresh = 10 * time.Millisecond
+
+	burn := storage.WithMetadata(map[string]string{storage.MetaBurn: "true"})
+	if err := client.UploadStream(context.Background(), strings.NewReader("secret"), -1, "stalled", burn); err != nil {
+		t.Fatalf("UploadStream: %v", err)
+	}
+	w := newBlockingWriter()
+	done := make(chan error, 1)
+	go func() { done <- client.DownloadStream(context.Background(), "stalled", w) }()
+	<-w.started
+
+	// Another download takes the claim over while this one is stalled
+	lockKey := client.objectKey(claimPrefix + "stalled")
+	takeover := []byte("the new owner")
+	fake.mu.Lock()
+	fake.objects[lockKey] = &fakeObject{data: takeover, etag: etag(takeover), modified: time.Now()}
+	fake.mu.Unlock()
+	time.Sleep(5 * storage.ClaimRefresh)
+	close(w.release)
+
+	if err := <-done; !errors.Is(err, storage.ErrClaimed) {
+		t.Fatalf("download that lost its claim = %v, want ErrClaimed", err)
+	}
+	fake.mu.Lock()
+	lock := fake.objects[lockKey]
+	fake.mu.Unlock()
+	if lock == nil || !bytes.Equal(lock.data, takeover) {
+		t.Fatal("the new owner's claim was overwritten or released")
+	}
+	if _, err := client.Stat("stalled"); err != nil {
+		t.Fatalf("Stat after losing the claim = %v, want the file left for the new owner", err)
+	}
+}
@@ -2,121 +2,277 @@ package s3client
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	tincanconfig "tincan/internal/config"
 	embeddedcreds "tincan/internal/credentials"
+	"tincan/pkg/storage"
 )
 
-type FileInfo struct {
-	Name         string    `json:"name"`
-	Size         int64     `json:"size"`
-	LastModified time.Time `json:"lastModified"`
-}
+// FileInfo is kept as an alias so existing callers keep compiling.
+type FileInfo = storage.FileInfo
+
+var _ storage.Storage = (*Client)(nil)
+
+// maxListKeys is the most objects ListObjectsV2 returns in one response.
+const maxListKeys = 1000
 
 type Client struct {
+	// PartSize is the size of each part of a multipart upload.
+	PartSize int64
+	// Concurrency is the number of parts uploaded in parallel.
+	Concurrency int
+	// MultipartThreshold is the file size from which uploads use multipart
+	// instead of a single PutObject.
+	MultipartThreshold int64
+	// DownloadConcurrency is the number of ranged GETs run in parallel.
+	DownloadConcurrency int
+	// StateDir is where transfer progress is recorded so interrupted
+	// transfers can be resumed. Resuming is disabled when it is empty.
+	StateDir string
+	// RequestTimeout bounds each S3 request, which is a single part or
+	// range for large transfers. Zero means no timeout.
+	RequestTimeout time.Duration
+	// Retry is how the parts and ranges of transfers are retried. Other
+	// requests are retried by the SDK with the policy given to New.
+	Retry RetryPolicy
+	// Limiter bounds the bandwidth of uploads and downloads. It may be
+	// shared with other clients. Nil means no limit.
+	Limiter *storage.RateLimiter
+
 	s3Client   *s3.Client
 	bucketName string
+	prefix     string
 }
 
-func New() (*Client, error) {
-	var cfg aws.Config
-	var err error
-	var bucketName string
-
-	// Check if credentials are embedded at build time
-	if embeddedcreds.HasEmbeddedCredentials() {
-		// Use embedded credentials
-		cfg, err = config.LoadDefaultConfig(context.TODO(),
-			config.WithRegion(embeddedcreds.Region),
-			config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
-				embeddedcreds.AccessKey,
-				embeddedcreds.SecretKey,
-				"",
-			)),
-		)
-		bucketName = embeddedcreds.BucketName
-	} else {
-		// Fall back to environment variables or AWS credentials file
-		cfg, err = config.LoadDefaultConfig(context.TODO())
-		bucketName = os.Getenv("TINCAN_BUCKET")
-		if bucketName == "" {
-			return nil, fmt.Errorf("TINCAN_BUCKET environment variable is required when credentials are not embedded")
-		}
+func New(cfg *tincanconfig.Config) (*Client, error) {
+	if cfg.BucketName == "" {
+		return nil, fmt.Errorf("bucket_name is required (set TINCAN_BUCKET_NAME environment variable or add to config file)")
+	}
+
+	var opts []func(*config.LoadOptions) error
+	if cfg.AWSRegion != "" {
+		opts = append(opts, config.WithRegion(cfg.AWSRegion))
+	}
+	switch cfg.CredentialSource {
+	case tincanconfig.CredentialsEmbedded:
+		opts = append(opts, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
+			embeddedcreds.AccessKey,
+			embeddedcreds.SecretKey,
+			"",
+		)))
+	case tincanconfig.CredentialsStatic:
+		opts = append(opts, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
+			cfg.AWSAccessKeyID,
+			cfg.AWSSecretKey,
+			"",
+		)))
+	case tincanconfig.CredentialsShared:
+		opts = append(opts, config.WithSharedConfigProfile(cfg.AWSProfile))
+	}
+	if cfg.InsecureSkipVerify {
+		// For self-hosted stores with self-signed certificates
+		opts = append(opts, config.WithHTTPClient(awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+			if tr.TLSClientConfig == nil {
+				tr.TLSClientConfig = &tls.Config{}
+			}
+			tr.TLSClientConfig.InsecureSkipVerify = true
+		})))
 	}
 
+	// Anything not configured falls back to the AWS SDK defaults
+	// (environment variables, ~/.aws files, IAM roles)
+	awsCfg, err := config.LoadDefaultConfig(context.TODO(), opts...)
 	if err != nil {
 		return nil, fmt.Errorf("unable to load SDK config: %w", err)
 	}
+	if awsCfg.Region == "" {
+		awsCfg.Region = "us-east-1"
+	}
+
+	retryPolicy := DefaultRetryPolicy
+	if cfg.RetryMaxAttempts > 0 {
+		retryPolicy = RetryPolicy{MaxAttempts: cfg.RetryMaxAttempts, BaseDelay: cfg.RetryBaseDelay, MaxDelay: cfg.RetryMaxDelay}
+	}
+
+	s3Client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if cfg.EndpointURL != "" {
+			o.BaseEndpoint = aws.String(cfg.EndpointURL)
+		}
+		o.UsePathStyle = cfg.UsePathStyle
+		o.Retryer = retryPolicy.retryer()
+		o.APIOptions = append(o.APIOptions, classifyErrors)
+	})
 
 	return &Client{
-		s3Client:   s3.NewFromConfig(cfg),
-		bucketName: bucketName,
+		PartSize:            cfg.PartSizeMB << 20,
+		Concurrency:         cfg.UploadConcurrency,
+		MultipartThreshold:  cfg.MultipartThresholdMB << 20,
+		DownloadConcurrency: cfg.DownloadConcurrency,
+		StateDir:            cfg.StateDir,
+		RequestTimeout:      cfg.RequestTimeout,
+		Retry:               retryPolicy,
+		s3Client:            s3Client,
+		bucketName:          cfg.BucketName,
+		prefix:              cfg.Prefix,
 	}, nil
 }
 
-func (c *Client) Upload(filePath, key string) error {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return fmt.Errorf("unable to open file %q: %w", filePath, err)
+// requestContext applies the per-request timeout, if any, to ctx.
+func (c *Client) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.RequestTimeout > 0 {
+		return context.WithTimeout(ctx, c.RequestTimeout)
 	}
-	defer file.Close()
+	return context.WithCancel(ctx)
+}
 
-	_, err = c.s3Client.PutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket: aws.String(c.bucketName),
-		Key:    aws.String(key),
-		Body:   file,
-	})
-	if err != nil {
-		return fmt.Errorf("unable to upload %q to %q: %w", filePath, c.bucketName, err)
+// contentSHA256 returns the SHA-256 of the first size bytes of r.
+func contentSHA256(r io.ReaderAt, size int64) ([]byte, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, 0, size)); err != nil {
+		return nil, err
 	}
-
-	return nil
+	return h.Sum(nil), nil
 }
 
-func (c *Client) Download(key, filePath string) error {
-	result, err := c.s3Client.GetObject(context.TODO(), &s3.GetObjectInput{
-		Bucket: aws.String(c.bucketName),
-		Key:    aws.String(key),
-	})
-	if err != nil {
-		return fmt.Errorf("unable to download %q from %q: %w", key, c.bucketName, err)
+// tagging encodes tags as the query string S3 expects, or nil for none.
+func tagging(tags map[string]string) *string {
+	if len(tags) == 0 {
+		return nil
+	}
+	values := url.Values{}
+	for k, v := range tags {
+		values.Set(k, v)
 	}
-	defer result.Body.Close()
+	return aws.String(values.Encode())
+}
+
+// objectKey maps a TinCan key to the S3 key below the configured prefix.
+func (c *Client) objectKey(key string) string {
+	return c.prefix + key
+}
 
-	file, err := os.Create(filePath)
+func (c *Client) Upload(filePath, key string, opts ...storage.UploadOption) error {
+	return c.UploadContext(context.Background(), filePath, key, opts...)
+}
+
+// UploadContext is like Upload but stops when ctx is cancelled. An
+// interrupted multipart upload can be resumed later if StateDir is set.
+func (c *Client) UploadContext(ctx context.Context, filePath, key string, opts ...storage.UploadOption) error {
+	options := storage.NewUploadOptions(opts...)
+
+	file, err := os.Open(filePath)
 	if err != nil {
-		return fmt.Errorf("unable to create file %q: %w", filePath, err)
+		return fmt.Errorf("unable to open file %q: %w", filePath, err)
 	}
 	defer file.Close()
 
-	_, err = io.Copy(file, result.Body)
+	info, err := file.Stat()
 	if err != nil {
-		return fmt.Errorf("unable to write to file %q: %w", filePath, err)
+		return fmt.Errorf("unable to stat file %q: %w", filePath, err)
 	}
+	// The checksum goes into the metadata, which is sent before the content
+	sum, err := contentSHA256(file, info.Size())
+	if err != nil {
+		return fmt.Errorf("unable to read file %q: %w", filePath, err)
+	}
+	if options.Metadata == nil {
+		options.Metadata = make(map[string]string)
+	}
+	options.Metadata[storage.MetaContentSHA256] = hex.EncodeToString(sum)
 
+	progress := storage.TrackProgress(ctx, key, info.Size())
+	if info.Size() >= c.MultipartThreshold {
+		return c.uploadMultipart(ctx, file, info, key, options, progress)
+	}
+
+	if err := c.putObject(ctx, newProgressBody(c.limitBody(ctx, file), progress), sum, key, options); err != nil {
+		return fmt.Errorf("unable to upload %q to %q: %w", filePath, c.bucketName, err)
+	}
 	return nil
 }
 
+// putObject uploads body as key in a single request. S3 rejects the upload
+// if the content it receives does not match sum.
+func (c *Client) putObject(ctx context.Context, body io.Reader, sum []byte, key string, options storage.UploadOptions) error {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+
+	_, err := c.s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:         aws.String(c.bucketName),
+		Key:            aws.String(c.objectKey(key)),
+		Body:           body,
+		Metadata:       options.Metadata,
+		Tagging:        tagging(options.Tags),
+		ChecksumSHA256: aws.String(base64.StdEncoding.EncodeToString(sum)),
+	}, unsignedPayload)
+	return err
+}
+
 func (c *Client) List() ([]FileInfo, error) {
-	result, err := c.s3Client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
-		Bucket: aws.String(c.bucketName),
+	return c.ListContext(context.Background())
+}
+
+func (c *Client) ListContext(ctx context.Context) ([]FileInfo, error) {
+	var files []FileInfo
+	err := storage.Walk(ctx, c, "", func(f FileInfo) error {
+		files = append(files, f)
+		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// ListPage returns up to opts.Limit objects and common prefixes (at most
+// 1000, the S3 maximum), continuing from opts.Cursor, which is an S3
+// continuation token.
+func (c *Client) ListPage(ctx context.Context, opts storage.ListOptions) (*storage.Page, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(c.bucketName),
+		Prefix: aws.String(c.objectKey(opts.Prefix)),
+	}
+	if opts.Delimiter != "" {
+		input.Delimiter = aws.String(opts.Delimiter)
+	}
+	if opts.Cursor != "" {
+		input.ContinuationToken = aws.String(opts.Cursor)
+	}
+	if opts.Limit > 0 {
+		input.MaxKeys = aws.Int32(int32(min(opts.Limit, maxListKeys)))
+	}
+
+	result, err := c.s3Client.ListObjectsV2(ctx, input)
 	if err != nil {
 		return nil, fmt.Errorf("unable to list objects in %q: %w", c.bucketName, err)
 	}
 
-	var files []FileInfo
+	page := &storage.Page{Files: make([]FileInfo, 0, len(result.Contents))}
 	for _, obj := range result.Contents {
-		if obj.Key != nil {
+		if obj.Key != nil && !storage.Hidden(strings.TrimPrefix(*obj.Key, c.prefix), opts.Prefix) {
 			fileInfo := FileInfo{
-				Name: *obj.Key,
+				Name: strings.TrimPrefix(*obj.Key, c.prefix),
 				Size: 0,
 			}
 			if obj.Size != nil {
@@ -125,11 +281,57 @@ func (c *Client) List() ([]FileInfo, error) {
 			if obj.LastModified != nil {
 				fileInfo.LastModified = *obj.LastModified
 			}
-			files = append(files, fileInfo)
+			if obj.ETag != nil {
+				fileInfo.ETag = *obj.ETag
+			}
+			page.Files = append(page.Files, fileInfo)
 		}
 	}
+	for _, p := range result.CommonPrefixes {
+		if p.Prefix != nil && !storage.Hidden(strings.TrimPrefix(*p.Prefix, c.prefix), opts.Prefix) {
+			page.Prefixes = append(page.Prefixes, strings.TrimPrefix(*p.Prefix, c.prefix))
+		}
+	}
+	if aws.ToBool(result.IsTruncated) {
+		page.NextCursor = aws.ToString(result.NextContinuationToken)
+	}
 
-	return files, nil
+	return page, nil
+}
+
+func (c *Client) Stat(key string) (*FileInfo, error) {
+	return c.StatContext(context.Background(), key)
+}
+
+func (c *Client) StatContext(ctx context.Context, key string) (*FileInfo, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+
+	result, err := c.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(c.bucketName),
+		Key:    aws.String(c.objectKey(key)),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return nil, fmt.Errorf("%q in %q: %w", key, c.bucketName, storage.ErrNotExist)
+		}
+		return nil, fmt.Errorf("unable to stat %q in %q: %w", key, c.bucketName, err)
+	}
+
+	fileInfo := &FileInfo{Name: key}
+	if result.ContentLength != nil {
+		fileInfo.Size = *result.ContentLength
+	}
+	if result.LastModified != nil {
+		fileInfo.LastModified = *result.LastModified
+	}
+	if result.ETag != nil {
+		fileInfo.ETag = *result.ETag
+	}
+	fileInfo.Metadata = result.Metadata
+
+	return fileInfo, nil
 }
 
 // ListNames returns just the filenames for backward compatibility
@@ -148,13 +350,20 @@ func (c *Client) ListNames() ([]string, error) {
 }
 
 func (c *Client) Delete(key string) error {
-	_, err := c.s3Client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+	return c.DeleteContext(context.Background(), key)
+}
+
+func (c *Client) DeleteContext(ctx context.Context, key string) error {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+
+	_, err := c.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(c.bucketName),
-		Key:    aws.String(key),
+		Key:    aws.String(c.objectKey(key)),
 	})
 	if err != nil {
 		return fmt.Errorf("unable to delete %q from %q: %w", key, c.bucketName, err)
 	}
 
 	return nil
-}
\ No newline at end of file
+}
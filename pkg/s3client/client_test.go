@@ -0,0 +1,210 @@
+package s3client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tincan/pkg/storage"
+)
+
+func TestS3CompatibleEndpoint(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		useTLS bool
+	}{
+		{"http", false},
+		{"https with self-signed certificate", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, _ := newTestClient(t, tc.useTLS)
+			dir := t.TempDir()
+
+			small := writeRandomFile(t, dir, "small.bin", 1000)
+			large := writeRandomFile(t, dir, "large.bin", 13<<20)
+
+			metadata := map[string]string{storage.MetaCompression: "zstd"}
+			if err := client.Upload(filepath.Join(dir, "small.bin"), "small.bin", storage.WithMetadata(metadata)); err != nil {
+				t.Fatalf("Upload small: %v", err)
+			}
+			if err := client.Upload(filepath.Join(dir, "large.bin"), "dir/large.bin"); err != nil {
+				t.Fatalf("Upload large: %v", err)
+			}
+
+			files, err := client.List()
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			var names []string
+			for _, f := range files {
+				names = append(names, f.Name)
+			}
+			if got := strings.Join(names, ","); got != "dir/large.bin,small.bin" {
+				t.Fatalf("List = %s, want dir/large.bin,small.bin", got)
+			}
+
+			info, err := client.Stat("small.bin")
+			if err != nil {
+				t.Fatalf("Stat: %v", err)
+			}
+			if info.Size != 1000 || info.Compression() != "zstd" {
+				t.Fatalf("Stat = size %d, metadata %v", info.Size, info.Metadata)
+			}
+
+			for key, want := range map[string][]byte{"small.bin": small, "dir/large.bin": large} {
+				dest := filepath.Join(dir, "downloaded")
+				if err := client.Download(key, dest); err != nil {
+					t.Fatalf("Download %s: %v", key, err)
+				}
+				got, _ := os.ReadFile(dest)
+				if !bytes.Equal(got, want) {
+					t.Fatalf("Download %s: content differs", key)
+				}
+			}
+
+			for _, key := range []string{"small.bin", "dir/large.bin"} {
+				if err := client.Delete(key); err != nil {
+					t.Fatalf("Delete %s: %v", key, err)
+				}
+			}
+			if _, err := client.Stat("small.bin"); !errors.Is(err, storage.ErrNotExist) {
+				t.Fatalf("Stat after delete = %v, want ErrNotExist", err)
+			}
+		})
+	}
+}
+
+func TestListPagination(t *testing.T) {
+	client, _ := newTestClient(t, false)
+	dir := t.TempDir()
+	writeRandomFile(t, dir, "file", 10)
+
+	var want []string
+	for i := 0; i < 7; i++ {
+		key := fmt.Sprintf("page/%02d", i)
+		if err := client.Upload(filepath.Join(dir, "file"), key); err != nil {
+			t.Fatalf("Upload %s: %v", key, err)
+		}
+		want = append(want, key)
+	}
+
+	var got []string
+	var pages int
+	opts := storage.ListOptions{Limit: 3}
+	for {
+		page, err := client.ListPage(context.Background(), opts)
+		if err != nil {
+			t.Fatalf("ListPage: %v", err)
+		}
+		pages++
+		for _, f := range page.Files {
+			got = append(got, f.Name)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+	if pages != 3 || strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("ListPage returned %v in %d pages, want %v in 3", got, pages, want)
+	}
+
+	var walked int
+	err := storage.Walk(context.Background(), client, "page/", func(f storage.FileInfo) error {
+		walked++
+		if walked == 4 {
+			return storage.SkipAll
+		}
+		return nil
+	})
+	if err != nil || walked != 4 {
+		t.Fatalf("Walk stopped after %d files with %v, want 4 and no error", walked, err)
+	}
+}
+
+func TestListDelimiter(t *testing.T) {
+	client, _ := newTestClient(t, false)
+	dir := t.TempDir()
+	writeRandomFile(t, dir, "file", 10)
+
+	for _, key := range []string{"top.txt", "docs/a.txt", "docs/b/c.txt", "docs/b/d.txt", "docs/e/f.txt", "docs/g.txt"} {
+		if err := client.Upload(filepath.Join(dir, "file"), key); err != nil {
+			t.Fatalf("Upload %s: %v", key, err)
+		}
+	}
+
+	var files, prefixes []string
+	opts := storage.ListOptions{Prefix: "docs/", Delimiter: "/", Limit: 2}
+	for {
+		page, err := client.ListPage(context.Background(), opts)
+		if err != nil {
+			t.Fatalf("ListPage: %v", err)
+		}
+		for _, f := range page.Files {
+			files = append(files, f.Name)
+		}
+		prefixes = append(prefixes, page.Prefixes...)
+		if page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+
+	if got := strings.Join(files, ","); got != "docs/a.txt,docs/g.txt" {
+		t.Errorf("files = %s, want docs/a.txt,docs/g.txt", got)
+	}
+	if got := strings.Join(prefixes, ","); got != "docs/b/,docs/e/" {
+		t.Errorf("prefixes = %s, want docs/b/,docs/e/", got)
+	}
+}
+
+func TestChecksumVerification(t *testing.T) {
+	client, fake := newTestClient(t, false)
+	if fake == nil {
+		t.Skip("needs the stand-in server to corrupt a stored object")
+	}
+	dir := t.TempDir()
+
+	for _, tc := range []struct {
+		name string
+		size int
+	}{
+		{"single", 1000},
+		{"multipart", 13 << 20},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := writeRandomFile(t, dir, tc.name, tc.size)
+			if err := client.Upload(filepath.Join(dir, tc.name), tc.name); err != nil {
+				t.Fatalf("Upload: %v", err)
+			}
+
+			info, err := client.Stat(tc.name)
+			if err != nil {
+				t.Fatalf("Stat: %v", err)
+			}
+			sum := sha256.Sum256(data)
+			if got := info.SHA256(); got != hex.EncodeToString(sum[:]) {
+				t.Fatalf("SHA256 = %q, want %x", got, sum)
+			}
+
+			fake.mu.Lock()
+			fake.objects[client.prefix+tc.name].data[tc.size/2] ^= 1
+			fake.mu.Unlock()
+
+			dest := filepath.Join(dir, tc.name+".downloaded")
+			if err := client.Download(tc.name, dest); !errors.Is(err, storage.ErrChecksum) {
+				t.Fatalf("Download of corrupted object = %v, want ErrChecksum", err)
+			}
+			if _, err := os.Stat(dest); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("corrupted download was left at %s", dest)
+			}
+		})
+	}
+}
@@ -0,0 +1,332 @@
+package s3client
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"tincan/pkg/storage"
+)
+
+// downloadState is persisted in StateDir while a download is in progress so
+// that a later attempt only fetches the ranges that are still missing.
+type downloadState struct {
+	ID        string `json:"id"`
+	Key       string `json:"key"`
+	ETag      string `json:"etag"`
+	Size      int64  `json:"size"`
+	ChunkSize int64  `json:"chunkSize"`
+	Done      []bool `json:"done"`
+}
+
+func (c *Client) downloadStatePath(id string) string {
+	return filepath.Join(c.StateDir, "downloads", id+".json")
+}
+
+// Download fetches key into filePath. Data is written to filePath.partial
+// using parallel ranged GETs and only renamed into place once its size
+// and SHA-256 match the object. An interrupted download is resumed on the
+// next call.
+//
+// A burn-after-reading object is claimed for the duration of the download,
+// so that concurrent downloads fail with storage.ErrClaimed, and deleted
+// once it is in place.
+func (c *Client) Download(key, filePath string) error {
+	return c.DownloadContext(context.Background(), key, filePath)
+}
+
+// DownloadContext is like Download but stops when ctx is cancelled, leaving
+// the partial file to be resumed later.
+func (c *Client) DownloadContext(ctx context.Context, key, filePath string) error {
+	return c.read(ctx, key, func(ctx context.Context, info *FileInfo) error {
+		return c.downloadFile(ctx, info, filePath)
+	})
+}
+
+// read calls fetch with the current info of key. A burn-after-reading
+// object is claimed while fetch runs and deleted once it succeeds. Should
+// another download take the claim over, fetch is stopped and the object
+// left for the new owner.
+func (c *Client) read(ctx context.Context, key string, fetch func(ctx context.Context, info *FileInfo) error) error {
+	info, err := c.StatContext(ctx, key)
+	if err != nil {
+		return fmt.Errorf("unable to download %q from %q: %w", key, c.bucketName, err)
+	}
+
+	fetchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu   sync.Mutex
+		lost error
+	)
+	burn, keepClaim := info.BurnAfterReading(), false
+	if burn {
+		etag, err := c.claim(ctx, key)
+		if err != nil {
+			return err
+		}
+		stop := storage.HoldClaim(func() {
+			mu.Lock()
+			defer mu.Unlock()
+			if lost != nil {
+				return
+			}
+			renewed, err := c.renewClaim(ctx, key, etag)
+			switch {
+			case errors.Is(err, storage.ErrClaimed):
+				lost = err
+				cancel()
+			case err == nil:
+				etag = renewed
+			}
+		})
+		defer func() {
+			stop()
+			if !keepClaim {
+				c.release(context.Background(), key)
+			}
+		}()
+
+		// The download that held the claim before may have received and
+		// deleted the file since it was looked up; an empty file would not
+		// notice that on its own
+		if info, err = c.StatContext(ctx, key); err != nil {
+			return fmt.Errorf("unable to download %q from %q: %w", key, c.bucketName, err)
+		}
+	}
+
+	err = fetch(fetchCtx, info)
+	mu.Lock()
+	if lost != nil {
+		// The lock belongs to the new owner now
+		keepClaim = true
+		err = lost
+	}
+	mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if burn {
+		if err := c.DeleteContext(ctx, key); err != nil {
+			// Keep the claim while the object survives, so nobody else
+			// receives it
+			keepClaim = true
+			return fmt.Errorf("downloaded %q but unable to delete it: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func (c *Client) downloadFile(ctx context.Context, info *FileInfo, filePath string) error {
+	key := info.Name
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		absPath = filePath
+	}
+	partialPath := filePath + ".partial"
+
+	st := &downloadState{
+		ID:        sessionID(c.bucketName, c.objectKey(key), absPath),
+		Key:       c.objectKey(key),
+		ETag:      info.ETag,
+		Size:      info.Size,
+		ChunkSize: c.partSizeFor(info.Size),
+	}
+	resumed := c.resumeDownload(st, partialPath)
+
+	flags := os.O_RDWR | os.O_CREATE
+	if !resumed {
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(partialPath, flags, 0o644)
+	if err != nil {
+		return fmt.Errorf("unable to create file %q: %w", partialPath, err)
+	}
+	defer file.Close()
+
+	progress := storage.TrackProgress(ctx, key, info.Size)
+	for chunk, done := range st.Done {
+		if done {
+			progress.Resume(min(st.ChunkSize, st.Size-int64(chunk)*st.ChunkSize))
+		}
+	}
+	if err := c.downloadChunks(ctx, file, st, progress); err != nil {
+		return fmt.Errorf("unable to download %q from %q: %w", key, c.bucketName, err)
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("unable to stat file %q: %w", partialPath, err)
+	}
+	if stat.Size() != info.Size {
+		return fmt.Errorf("downloaded %d bytes of %q but object is %d bytes", stat.Size(), key, info.Size)
+	}
+	if want := info.Metadata[storage.MetaContentSHA256]; want != "" {
+		sum, err := contentSHA256(file, stat.Size())
+		if err != nil {
+			return fmt.Errorf("unable to read file %q: %w", partialPath, err)
+		}
+		if hex.EncodeToString(sum) != want {
+			// Start from scratch next time rather than resume corrupt data
+			file.Close()
+			os.Remove(partialPath)
+			if c.StateDir != "" {
+				os.Remove(c.downloadStatePath(st.ID))
+			}
+			return fmt.Errorf("downloaded %q: %w", key, storage.ErrChecksum)
+		}
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("unable to write to file %q: %w", partialPath, err)
+	}
+
+	if err := os.Rename(partialPath, filePath); err != nil {
+		return fmt.Errorf("unable to move %q into place: %w", partialPath, err)
+	}
+	if c.StateDir != "" {
+		os.Remove(c.downloadStatePath(st.ID))
+	}
+	return nil
+}
+
+// resumeDownload fills in the chunks already fetched by an earlier attempt
+// and reports whether the partial file can be reused.
+func (c *Client) resumeDownload(st *downloadState, partialPath string) bool {
+	numChunks := 0
+	if st.Size > 0 {
+		numChunks = int((st.Size + st.ChunkSize - 1) / st.ChunkSize)
+	}
+	st.Done = make([]bool, numChunks)
+
+	if c.StateDir == "" {
+		return false
+	}
+	if _, err := os.Stat(partialPath); err != nil {
+		return false
+	}
+
+	var saved downloadState
+	if err := loadState(c.downloadStatePath(st.ID), &saved); err != nil {
+		return false
+	}
+	// A changed object would leave a mix of old and new content
+	if saved.ETag != st.ETag || saved.Size != st.Size || saved.ChunkSize != st.ChunkSize || len(saved.Done) != numChunks {
+		return false
+	}
+
+	st.Done = saved.Done
+	return true
+}
+
+// downloadChunks fetches the chunks not yet marked done using
+// c.DownloadConcurrency workers, recording progress as each one lands.
+func (c *Client) downloadChunks(ctx context.Context, file *os.File, st *downloadState, progress *storage.ProgressTracker) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	workers := c.DownloadConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+
+	jobs := make(chan int)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range jobs {
+				err := c.downloadChunk(ctx, file, st, chunk, progress)
+
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+					}
+					cancel()
+				} else {
+					st.Done[chunk] = true
+					if c.StateDir != "" {
+						saveState(c.downloadStatePath(st.ID), st)
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+dispatch:
+	for chunk, done := range st.Done {
+		if done {
+			continue
+		}
+		select {
+		case jobs <- chunk:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+func (c *Client) downloadChunk(ctx context.Context, file *os.File, st *downloadState, chunk int, progress *storage.ProgressTracker) error {
+	offset := int64(chunk) * st.ChunkSize
+	length := min(st.ChunkSize, st.Size-offset)
+	return c.getRange(ctx, st.Key, st.ETag, offset, length, progress, func() io.Writer {
+		return io.NewOffsetWriter(file, offset)
+	})
+}
+
+// getRange fetches length bytes of the S3 key from offset into the writer
+// returned by dst, counting them in progress. Failed attempts, including
+// those cut off mid-body, are retried according to c.Retry, each with a
+// writer freshly returned by dst and with the bytes it counted taken back.
+func (c *Client) getRange(ctx context.Context, key, etag string, offset, length int64, progress *storage.ProgressTracker, dst func() io.Writer) error {
+	return c.withRetry(ctx, func(ctx context.Context) error {
+		ctx, cancel := c.requestContext(ctx)
+		defer cancel()
+
+		// If-Match makes S3 refuse the range if the object was replaced mid-way
+		result, err := c.s3Client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket:  aws.String(c.bucketName),
+			Key:     aws.String(key),
+			Range:   aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+			IfMatch: aws.String(etag),
+		}, noRetry)
+		if err != nil {
+			return fmt.Errorf("range %d-%d: %w", offset, offset+length-1, err)
+		}
+		defer result.Body.Close()
+
+		n, err := io.Copy(progress.Writer(dst()), c.Limiter.Reader(ctx, result.Body))
+		if err != nil || n != length {
+			progress.Add(-n)
+		}
+		if err != nil {
+			return fmt.Errorf("range %d-%d: %w", offset, offset+length-1, err)
+		}
+		if n != length {
+			return fmt.Errorf("range %d-%d: got %d bytes, expected %d: %w", offset, offset+length-1, n, length, io.ErrUnexpectedEOF)
+		}
+		return nil
+	})
+}
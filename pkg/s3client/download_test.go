@@ -0,0 +1,96 @@
+package s3client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func isRangedGet(r *http.Request) bool {
+	return r.Method == http.MethodGet && r.Header.Get("Range") != ""
+}
+
+// interruptDownload downloads key to path, cancelling the download once
+// one range went through.
+func interruptDownload(t *testing.T, client *Client, fake *fakeS3, key, path string) {
+	t.Helper()
+	client.DownloadConcurrency = 1
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	countRequests(fake, isRangedGet, 1, cancel)
+	if err := client.DownloadContext(ctx, key, path); err == nil {
+		t.Fatal("interrupted download succeeded")
+	}
+	countRequests(fake, func(*http.Request) bool { return false }, 0, nil)
+
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("interrupted download created %s: %v", path, err)
+	}
+	if _, err := os.Stat(path + ".partial"); err != nil {
+		t.Fatalf("interrupted download left no partial file: %v", err)
+	}
+}
+
+func TestResumeDownload(t *testing.T) {
+	client, fake := newTestClient(t, false)
+	if fake == nil {
+		t.Skip("needs the stand-in server to interrupt transfers")
+	}
+	dir := t.TempDir()
+	data := writeRandomFile(t, dir, "big", 16<<20)
+	if err := client.Upload(filepath.Join(dir, "big"), "big"); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := filepath.Join(dir, "downloaded")
+	interruptDownload(t, client, fake, "big", dest)
+
+	ranges := countRequests(fake, isRangedGet, 0, nil)
+	if err := client.Download("big", dest); err != nil {
+		t.Fatalf("resumed Download: %v", err)
+	}
+	if n := count(fake, ranges); n != 3 {
+		t.Errorf("resumed download fetched %d ranges, want the 3 missing", n)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, data) {
+		t.Fatal("resumed download: content differs")
+	}
+	if _, err := os.Stat(dest + ".partial"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("partial file left after the download: %v", err)
+	}
+}
+
+func TestResumeDownloadChangedObject(t *testing.T) {
+	client, fake := newTestClient(t, false)
+	if fake == nil {
+		t.Skip("needs the stand-in server to interrupt transfers")
+	}
+	dir := t.TempDir()
+	writeRandomFile(t, dir, "big", 16<<20)
+	if err := client.Upload(filepath.Join(dir, "big"), "big"); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := filepath.Join(dir, "downloaded")
+	interruptDownload(t, client, fake, "big", dest)
+
+	// A new ETag means the ranges already fetched are of another object
+	data := writeRandomFile(t, dir, "big", 16<<20)
+	if err := client.Upload(filepath.Join(dir, "big"), "big"); err != nil {
+		t.Fatal(err)
+	}
+	ranges := countRequests(fake, isRangedGet, 0, nil)
+	if err := client.Download("big", dest); err != nil {
+		t.Fatalf("Download of the changed object: %v", err)
+	}
+	if n := count(fake, ranges); n != 4 {
+		t.Errorf("download of the changed object fetched %d ranges, want all 4", n)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, data) {
+		t.Fatal("download of the changed object: content differs")
+	}
+}
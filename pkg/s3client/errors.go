@@ -0,0 +1,82 @@
+package s3client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	"tincan/pkg/storage"
+)
+
+// Errors that failed requests are classified as, for use with errors.Is.
+// They are the storage package's errors, so callers written against
+// storage.Storage can match them too. The original SDK error stays
+// available with errors.As.
+var (
+	ErrNotFound     = storage.ErrNotExist
+	ErrAccessDenied = storage.ErrAccessDenied
+	ErrNoBucket     = storage.ErrNoBucket
+	ErrThrottled    = storage.ErrThrottled
+	ErrChecksum     = storage.ErrChecksum
+)
+
+// kindError attaches the class of a failure to the error the SDK returned,
+// without changing its message.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string   { return e.err.Error() }
+func (e *kindError) Unwrap() []error { return []error{e.kind, e.err} }
+
+// errorKind returns the class of err, or nil if it is none of the above.
+func errorKind(err error) error {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		code := apiErr.ErrorCode()
+		if _, ok := retry.DefaultThrottleErrorCodes[code]; ok {
+			return ErrThrottled
+		}
+		switch code {
+		case "NoSuchKey", "NotFound", "NoSuchUpload":
+			return ErrNotFound
+		case "NoSuchBucket":
+			return ErrNoBucket
+		case "AccessDenied", "Forbidden", "AllAccessDisabled", "AccountProblem",
+			"InvalidAccessKeyId", "SignatureDoesNotMatch", "ExpiredToken", "InvalidToken":
+			return ErrAccessDenied
+		case "BadDigest", "InvalidDigest", "XAmzContentSHA256Mismatch":
+			return ErrChecksum
+		}
+	}
+
+	var statusErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &statusErr) {
+		switch statusErr.HTTPStatusCode() {
+		case http.StatusNotFound:
+			return ErrNotFound
+		case http.StatusForbidden:
+			return ErrAccessDenied
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			return ErrThrottled
+		}
+	}
+	return nil
+}
+
+// classifyErrors is an SDK stack option that classifies the errors of every
+// operation once the SDK has given up retrying it.
+func classifyErrors(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("TincanClassifyErrors",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			out, metadata, err := next.HandleInitialize(ctx, in)
+			if kind := errorKind(err); kind != nil {
+				err = &kindError{kind: kind, err: err}
+			}
+			return out, metadata, err
+		}), middleware.Before)
+}
@@ -0,0 +1,406 @@
+package s3client
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tincanconfig "tincan/internal/config"
+)
+
+// The tests run against an in-process S3 stand-in that only understands
+// path-style requests, the way a default MinIO deployment is reached. Set
+// TINCAN_TEST_ENDPOINT (plus TINCAN_TEST_BUCKET and AWS credentials) to run
+// them against a real S3-compatible server instead.
+
+type fakeObject struct {
+	data     []byte
+	etag     string
+	metadata map[string]string
+	modified time.Time
+}
+
+type fakeUpload struct {
+	parts    map[int][]byte
+	metadata map[string]string
+}
+
+type fakeS3 struct {
+	bucket string
+
+	mu      sync.Mutex
+	objects map[string]*fakeObject
+	uploads map[string]*fakeUpload
+	nextID  int
+	// lifecycle is the XML of the bucket lifecycle configuration, if any.
+	lifecycle []byte
+
+	// The next slowDowns requests are refused with 503 SlowDown, and the
+	// bodies of the next truncations GETs are cut off half-way.
+	slowDowns   int
+	truncations int
+
+	// onRequest, if set, is called with every request before it is served.
+	onRequest func(r *http.Request)
+}
+
+func newFakeS3(bucket string) *fakeS3 {
+	return &fakeS3{
+		bucket:  bucket,
+		objects: make(map[string]*fakeObject),
+		uploads: make(map[string]*fakeUpload),
+	}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		// Virtual-hosted requests put the bucket in the Host header
+		s3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.onRequest != nil {
+		f.onRequest(r)
+	}
+	if f.slowDowns > 0 {
+		f.slowDowns--
+		s3Error(w, http.StatusServiceUnavailable, "SlowDown")
+		return
+	}
+
+	q := r.URL.Query()
+	switch {
+	case key == "" && q.Has("lifecycle"):
+		f.serveLifecycle(w, r)
+	case r.Method == http.MethodGet && key == "":
+		f.list(w, q)
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		f.nextID++
+		id := strconv.Itoa(f.nextID)
+		f.uploads[id] = &fakeUpload{parts: make(map[int][]byte), metadata: metadataFrom(r.Header)}
+		writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadId string
+		}{Bucket: bucket, Key: key, UploadId: id})
+	case r.Method == http.MethodPut && q.Has("uploadId"):
+		upload, ok := f.uploads[q.Get("uploadId")]
+		if !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		n, _ := strconv.Atoi(q.Get("partNumber"))
+		data, _ := io.ReadAll(r.Body)
+		if !checksumMatches(r.Header, data) {
+			s3Error(w, http.StatusBadRequest, "BadDigest")
+			return
+		}
+		upload.parts[n] = data
+		w.Header().Set("ETag", etag(data))
+	case r.Method == http.MethodPost && q.Has("uploadId"):
+		f.completeUpload(w, r, key, q.Get("uploadId"))
+	case r.Method == http.MethodGet && q.Has("uploadId"):
+		if _, ok := f.uploads[q.Get("uploadId")]; !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"ListPartsResult"`
+		}{})
+	case r.Method == http.MethodDelete && q.Has("uploadId"):
+		delete(f.uploads, q.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		if r.Header.Get("If-None-Match") == "*" && f.objects[key] != nil {
+			s3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		if match := r.Header.Get("If-Match"); match != "" {
+			if f.objects[key] == nil {
+				s3Error(w, http.StatusNotFound, "NoSuchKey")
+				return
+			}
+			if match != f.objects[key].etag {
+				s3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+				return
+			}
+		}
+		data, _ := io.ReadAll(r.Body)
+		if !checksumMatches(r.Header, data) {
+			s3Error(w, http.StatusBadRequest, "BadDigest")
+			return
+		}
+		f.objects[key] = &fakeObject{data: data, etag: etag(data), metadata: metadataFrom(r.Header), modified: time.Now()}
+		w.Header().Set("ETag", etag(data))
+	case r.Method == http.MethodHead, r.Method == http.MethodGet:
+		f.get(w, r, key)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s3Error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (f *fakeS3) serveLifecycle(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if f.lifecycle == nil {
+			s3Error(w, http.StatusNotFound, "NoSuchLifecycleConfiguration")
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write(f.lifecycle)
+	case http.MethodPut:
+		f.lifecycle, _ = io.ReadAll(r.Body)
+	case http.MethodDelete:
+		f.lifecycle = nil
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, q url.Values) {
+	type content struct {
+		Key          string
+		Size         int64
+		ETag         string
+		LastModified time.Time
+	}
+	type commonPrefix struct {
+		Prefix string
+	}
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Name                  string
+		Prefix                string
+		Delimiter             string `xml:",omitempty"`
+		KeyCount              int
+		MaxKeys               int
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+		Contents              []content
+		CommonPrefixes        []commonPrefix
+	}{Name: f.bucket, Prefix: q.Get("prefix"), Delimiter: q.Get("delimiter"), MaxKeys: 1000}
+	if n, err := strconv.Atoi(q.Get("max-keys")); err == nil {
+		result.MaxKeys = n
+	}
+
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// Continuation tokens are simply the last key or prefix of the previous
+	// page
+	last := q.Get("continuation-token")
+	for _, key := range keys {
+		if !strings.HasPrefix(key, result.Prefix) {
+			continue
+		}
+		name, isPrefix := key, false
+		if i := strings.Index(key[len(result.Prefix):], result.Delimiter); result.Delimiter != "" && i >= 0 {
+			name, isPrefix = key[:len(result.Prefix)+i+len(result.Delimiter)], true
+		}
+		if name <= last {
+			continue
+		}
+		if result.KeyCount == result.MaxKeys {
+			result.IsTruncated = true
+			result.NextContinuationToken = last
+			break
+		}
+		if isPrefix {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{name})
+		} else {
+			obj := f.objects[key]
+			result.Contents = append(result.Contents, content{key, int64(len(obj.data)), obj.etag, obj.modified})
+		}
+		result.KeyCount++
+		last = name
+	}
+	writeXML(w, result)
+}
+
+func (f *fakeS3) completeUpload(w http.ResponseWriter, r *http.Request, key, id string) {
+	upload, ok := f.uploads[id]
+	if !ok {
+		s3Error(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+
+	var req struct {
+		Parts []struct{ PartNumber int } `xml:"Part"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		s3Error(w, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+
+	var data []byte
+	for _, p := range req.Parts {
+		data = append(data, upload.parts[p.PartNumber]...)
+	}
+	delete(f.uploads, id)
+
+	tag := fmt.Sprintf(`"%s-%d"`, strings.Trim(etag(data), `"`), len(req.Parts))
+	f.objects[key] = &fakeObject{data: data, etag: tag, metadata: upload.metadata, modified: time.Now()}
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Key     string
+		ETag    string
+	}{Key: key, ETag: tag})
+}
+
+func (f *fakeS3) get(w http.ResponseWriter, r *http.Request, key string) {
+	obj, ok := f.objects[key]
+	if !ok {
+		s3Error(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	if match := r.Header.Get("If-Match"); match != "" && match != obj.etag {
+		s3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+		return
+	}
+
+	for k, v := range obj.metadata {
+		w.Header().Set("X-Amz-Meta-"+k, v)
+	}
+	w.Header().Set("ETag", obj.etag)
+	w.Header().Set("Last-Modified", obj.modified.UTC().Format(http.TimeFormat))
+
+	data, status := obj.data, http.StatusOK
+	if rng := r.Header.Get("Range"); rng != "" {
+		var start, end int
+		fmt.Sscanf(rng, "bytes=%d-%d", &start, &end)
+		end = min(end, len(data)-1)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		data, status = data[start:end+1], http.StatusPartialContent
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if r.Method == http.MethodGet {
+		if f.truncations > 0 {
+			f.truncations--
+			data = data[:len(data)/2]
+		}
+		w.Write(data)
+	}
+}
+
+func metadataFrom(h http.Header) map[string]string {
+	metadata := make(map[string]string)
+	for k, v := range h {
+		if name, ok := strings.CutPrefix(strings.ToLower(k), "x-amz-meta-"); ok {
+			metadata[name] = v[0]
+		}
+	}
+	return metadata
+}
+
+// checksumMatches checks a body against its x-amz-checksum-sha256 header,
+// if one was sent.
+func checksumMatches(h http.Header, data []byte) bool {
+	want := h.Get("X-Amz-Checksum-Sha256")
+	if want == "" {
+		return true
+	}
+	sum := sha256.Sum256(data)
+	return want == base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func writeXML(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(v)
+}
+
+func s3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code></Error>", code)
+}
+
+// newTestClient returns a client for a fresh stand-in server, or for the
+// server named by TINCAN_TEST_ENDPOINT, in which case the returned fake is
+// nil.
+func newTestClient(t *testing.T, useTLS bool) (*Client, *fakeS3) {
+	t.Helper()
+
+	cfg := &tincanconfig.Config{
+		BucketName:           "tincan-test",
+		Prefix:               fmt.Sprintf("it-%d/", time.Now().UnixNano()),
+		AWSRegion:            "us-east-1",
+		CredentialSource:     tincanconfig.CredentialsStatic,
+		AWSAccessKeyID:       "minioadmin",
+		AWSSecretKey:         "minioadmin",
+		UsePathStyle:         true,
+		PartSizeMB:           5,
+		UploadConcurrency:    3,
+		DownloadConcurrency:  3,
+		MultipartThresholdMB: 6,
+		StateDir:             t.TempDir(),
+		RetryMaxAttempts:     4,
+		RetryBaseDelay:       time.Millisecond,
+		RetryMaxDelay:        10 * time.Millisecond,
+	}
+
+	var fake *fakeS3
+	if endpoint := os.Getenv("TINCAN_TEST_ENDPOINT"); endpoint != "" {
+		cfg.EndpointURL = endpoint
+		cfg.BucketName = os.Getenv("TINCAN_TEST_BUCKET")
+		cfg.CredentialSource = tincanconfig.CredentialsDefault
+	} else {
+		fake = newFakeS3(cfg.BucketName)
+		var server *httptest.Server
+		if useTLS {
+			server = httptest.NewTLSServer(fake)
+			cfg.InsecureSkipVerify = true
+		} else {
+			server = httptest.NewServer(fake)
+		}
+		t.Cleanup(server.Close)
+		cfg.EndpointURL = server.URL
+	}
+
+	client, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return client, fake
+}
+
+func writeRandomFile(t *testing.T, dir, name string, size int) []byte {
+	t.Helper()
+	data := make([]byte, size)
+	rand.Read(data)
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,93 @@
+package s3client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"tincan/pkg/storage"
+)
+
+// expiryRulePrefix starts the ID of every lifecycle rule installed by
+// InstallExpiryRules, so they can be replaced without touching others.
+const expiryRulePrefix = "tincan-expire-"
+
+var _ storage.ExpiryRules = (*Client)(nil)
+
+// InstallExpiryRules adds one lifecycle rule per entry of days to the
+// bucket, expiring objects below the configured prefix that carry the
+// matching storage.TagExpireDays tag. Rules installed earlier are replaced;
+// rules of other tools are kept.
+func (c *Client) InstallExpiryRules(ctx context.Context, days []int) error {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+
+	var rules []types.LifecycleRule
+	current, err := c.s3Client.GetBucketLifecycleConfiguration(ctx, &s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(c.bucketName),
+	})
+	var apiErr smithy.APIError
+	switch {
+	case errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchLifecycleConfiguration":
+	case err != nil:
+		return fmt.Errorf("unable to read lifecycle rules of %q: %w", c.bucketName, err)
+	default:
+		for _, rule := range current.Rules {
+			if !c.ownsRule(aws.ToString(rule.ID)) {
+				rules = append(rules, rule)
+			}
+		}
+	}
+
+	for _, d := range days {
+		if d < 1 {
+			return fmt.Errorf("invalid expiry of %d days", d)
+		}
+		tag := types.Tag{Key: aws.String(storage.TagExpireDays), Value: aws.String(strconv.Itoa(d))}
+		var filter types.LifecycleRuleFilter = &types.LifecycleRuleFilterMemberTag{Value: tag}
+		if c.prefix != "" {
+			filter = &types.LifecycleRuleFilterMemberAnd{Value: types.LifecycleRuleAndOperator{
+				Prefix: aws.String(c.prefix),
+				Tags:   []types.Tag{tag},
+			}}
+		}
+		rules = append(rules, types.LifecycleRule{
+			ID:         aws.String(fmt.Sprintf("%s%s%dd", expiryRulePrefix, c.prefix, d)),
+			Status:     types.ExpirationStatusEnabled,
+			Filter:     filter,
+			Expiration: &types.LifecycleExpiration{Days: aws.Int32(int32(d))},
+		})
+	}
+
+	if len(rules) == 0 {
+		_, err = c.s3Client.DeleteBucketLifecycle(ctx, &s3.DeleteBucketLifecycleInput{
+			Bucket: aws.String(c.bucketName),
+		})
+	} else {
+		_, err = c.s3Client.PutBucketLifecycleConfiguration(ctx, &s3.PutBucketLifecycleConfigurationInput{
+			Bucket:                 aws.String(c.bucketName),
+			LifecycleConfiguration: &types.BucketLifecycleConfiguration{Rules: rules},
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("unable to install lifecycle rules on %q: %w", c.bucketName, err)
+	}
+	return nil
+}
+
+// ownsRule reports whether id was installed by InstallExpiryRules for the
+// configured prefix.
+func (c *Client) ownsRule(id string) bool {
+	days, ok := strings.CutPrefix(id, expiryRulePrefix+c.prefix)
+	if !ok {
+		return false
+	}
+	_, err := strconv.Atoi(strings.TrimSuffix(days, "d"))
+	return strings.HasSuffix(days, "d") && err == nil
+}
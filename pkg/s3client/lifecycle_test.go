@@ -0,0 +1,128 @@
+package s3client
+
+import (
+	"context"
+	"encoding/xml"
+	"slices"
+	"strconv"
+	"testing"
+
+	"tincan/pkg/storage"
+)
+
+type lifecycleTag struct {
+	Key   string
+	Value string
+}
+
+type lifecycleRule struct {
+	ID     string
+	Status string
+	Filter struct {
+		Prefix string
+		Tag    *lifecycleTag
+		And    *struct {
+			Prefix string
+			Tags   []lifecycleTag `xml:"Tag"`
+		}
+	}
+	Expiration struct {
+		Days int
+	}
+}
+
+// lifecycleRules returns the rules stored on the fake server.
+func lifecycleRules(t *testing.T, fake *fakeS3) []lifecycleRule {
+	t.Helper()
+	fake.mu.Lock()
+	data := fake.lifecycle
+	fake.mu.Unlock()
+	if data == nil {
+		return nil
+	}
+	var config struct {
+		Rules []lifecycleRule `xml:"Rule"`
+	}
+	if err := xml.Unmarshal(data, &config); err != nil {
+		t.Fatalf("lifecycle configuration %s: %v", data, err)
+	}
+	return config.Rules
+}
+
+func ruleIDs(rules []lifecycleRule) []string {
+	var ids []string
+	for _, r := range rules {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
+
+func TestExpiryRules(t *testing.T) {
+	client, fake := newTestClient(t, false)
+	if fake == nil {
+		t.Skip("needs the stand-in server to inspect lifecycle rules")
+	}
+	ctx := context.Background()
+
+	// Rules of other tools survive
+	fake.mu.Lock()
+	fake.lifecycle = []byte(`<LifecycleConfiguration><Rule><ID>archive-logs</ID><Filter><Prefix>logs/</Prefix></Filter>` +
+		`<Status>Enabled</Status><Expiration><Days>90</Days></Expiration></Rule></LifecycleConfiguration>`)
+	fake.mu.Unlock()
+
+	if err := client.InstallExpiryRules(ctx, []int{1, 7}); err != nil {
+		t.Fatalf("InstallExpiryRules: %v", err)
+	}
+	rules := lifecycleRules(t, fake)
+	prefix := client.prefix
+	want := []string{"archive-logs", expiryRulePrefix + prefix + "1d", expiryRulePrefix + prefix + "7d"}
+	if !slices.Equal(ruleIDs(rules), want) {
+		t.Fatalf("rules %v, want %v", ruleIDs(rules), want)
+	}
+	for i, days := range []int{1, 7} {
+		r := rules[i+1]
+		and := r.Filter.And
+		if r.Status != "Enabled" || r.Expiration.Days != days || and == nil || and.Prefix != prefix ||
+			len(and.Tags) != 1 || and.Tags[0].Key != storage.TagExpireDays || and.Tags[0].Value != strconv.Itoa(days) {
+			t.Errorf("rule %s = %+v", r.ID, r)
+		}
+	}
+
+	// Installing again replaces the earlier rules
+	if err := client.InstallExpiryRules(ctx, []int{30}); err != nil {
+		t.Fatalf("InstallExpiryRules: %v", err)
+	}
+	if ids := ruleIDs(lifecycleRules(t, fake)); !slices.Equal(ids, []string{"archive-logs", expiryRulePrefix + prefix + "30d"}) {
+		t.Fatalf("rules after reinstalling = %v", ids)
+	}
+
+	// Without a prefix, rules filter on the tag alone, and leave the rules
+	// of prefixed clients alone
+	client.prefix = ""
+	if err := client.InstallExpiryRules(ctx, []int{2}); err != nil {
+		t.Fatalf("InstallExpiryRules without a prefix: %v", err)
+	}
+	rules = lifecycleRules(t, fake)
+	if ids := ruleIDs(rules); !slices.Equal(ids, []string{"archive-logs", expiryRulePrefix + prefix + "30d", expiryRulePrefix + "2d"}) {
+		t.Fatalf("rules after installing without a prefix = %v", ids)
+	}
+	if tag := rules[2].Filter.Tag; tag == nil || tag.Key != storage.TagExpireDays || tag.Value != "2" || rules[2].Filter.And != nil {
+		t.Fatalf("rule without a prefix = %+v", rules[2])
+	}
+
+	if err := client.InstallExpiryRules(ctx, []int{0}); err == nil {
+		t.Fatal("InstallExpiryRules accepted 0 days")
+	}
+
+	// Removing every rule removes the configuration
+	fake.mu.Lock()
+	fake.lifecycle = nil
+	fake.mu.Unlock()
+	client.InstallExpiryRules(ctx, []int{3})
+	if err := client.InstallExpiryRules(ctx, nil); err != nil {
+		t.Fatalf("InstallExpiryRules of nothing: %v", err)
+	}
+	if rules := lifecycleRules(t, fake); rules != nil {
+		t.Fatalf("rules after removing all = %v", ruleIDs(rules))
+	}
+}
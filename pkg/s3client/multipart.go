@@ -0,0 +1,262 @@
+package s3client
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"tincan/pkg/storage"
+)
+
+const (
+	// S3 rejects parts smaller than 5 MiB (except the last one) or larger
+	// than 5 GiB, and uploads with more than 10,000 parts.
+	minPartSize = 5 << 20
+	maxPartSize = 5 << 30
+	maxParts    = 10000
+)
+
+// partSizeFor returns the part size to use for a file of the given size,
+// growing the configured size when the file would need too many parts.
+func (c *Client) partSizeFor(size int64) int64 {
+	partSize := c.PartSize
+	if partSize < minPartSize {
+		partSize = minPartSize
+	}
+	for size/partSize >= maxParts {
+		partSize *= 2
+	}
+	return partSize
+}
+
+func (c *Client) uploadMultipart(ctx context.Context, file *os.File, info os.FileInfo, key string, options storage.UploadOptions, progress *storage.ProgressTracker) error {
+	filePath, err := filepath.Abs(file.Name())
+	if err != nil {
+		filePath = file.Name()
+	}
+
+	// Continue an interrupted upload of the same file if there is one
+	key = c.objectKey(key)
+	id := sessionID(c.bucketName, key, filePath)
+	var st *uploadState
+	if c.StateDir != "" {
+		if st, err = c.resumeUpload(ctx, id, info, options.Metadata); err != nil {
+			return fmt.Errorf("unable to resume upload of %q: %w", filePath, err)
+		}
+	}
+
+	if st == nil {
+		uploadID, err := c.createMultipartUpload(ctx, key, options)
+		if err != nil {
+			return fmt.Errorf("unable to start multipart upload of %q to %q: %w", filePath, c.bucketName, err)
+		}
+
+		st = &uploadState{
+			ID:       id,
+			Bucket:   c.bucketName,
+			Key:      key,
+			UploadID: uploadID,
+			FilePath: filePath,
+			Size:     info.Size(),
+			ModTime:  info.ModTime(),
+			PartSize: c.partSizeFor(info.Size()),
+			Metadata: options.Metadata,
+			Started:  time.Now(),
+		}
+	}
+
+	var saveErr error
+	save := func() {
+		if c.StateDir != "" && saveErr == nil {
+			saveErr = c.saveUploadState(st)
+		}
+	}
+	save()
+
+	for _, p := range st.Parts {
+		offset := int64(p.Number-1) * st.PartSize
+		progress.Resume(min(st.PartSize, st.Size-offset))
+	}
+
+	var mu sync.Mutex
+	parts, err := c.uploadParts(ctx, file, st, progress, func(p types.CompletedPart) {
+		mu.Lock()
+		defer mu.Unlock()
+		st.Parts = append(st.Parts, part{Number: *p.PartNumber, ETag: *p.ETag, Checksum: aws.ToString(p.ChecksumSHA256)})
+		save()
+	})
+	if err == nil {
+		err = c.completeMultipartUpload(ctx, st, parts)
+	}
+	if err != nil {
+		if c.StateDir != "" && saveErr == nil {
+			return fmt.Errorf("unable to upload %q to %q (run the upload again to resume): %w", filePath, c.bucketName, err)
+		}
+		// Incomplete uploads are billed until aborted, so clean up before
+		// reporting the failure when the upload cannot be resumed later.
+		c.abortUpload(st)
+		return fmt.Errorf("unable to upload %q to %q: %w", filePath, c.bucketName, err)
+	}
+
+	c.removeUploadState(st.ID)
+	return nil
+}
+
+// createMultipartUpload starts a multipart upload to the S3 key and returns
+// its ID.
+func (c *Client) createMultipartUpload(ctx context.Context, key string, options storage.UploadOptions) (string, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+
+	created, err := c.s3Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:   aws.String(c.bucketName),
+		Key:      aws.String(key),
+		Metadata: options.Metadata,
+		Tagging:  tagging(options.Tags),
+		// Each part is checked by S3 against its own checksum
+		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(created.UploadId), nil
+}
+
+func (c *Client) completeMultipartUpload(ctx context.Context, st *uploadState, parts []types.CompletedPart) error {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+
+	_, err := c.s3Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(st.Bucket),
+		Key:             aws.String(st.Key),
+		UploadId:        aws.String(st.UploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	return err
+}
+
+// uploadParts uploads the parts of file not yet recorded in st using
+// c.Concurrency workers, calling onPart as each one completes, and returns
+// all completed parts in order. The first failure cancels the rest.
+func (c *Client) uploadParts(ctx context.Context, file io.ReaderAt, st *uploadState, progress *storage.ProgressTracker, onPart func(types.CompletedPart)) ([]types.CompletedPart, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	size, partSize := st.Size, st.PartSize
+	numParts := int32((size + partSize - 1) / partSize)
+
+	done := make(map[int32]bool, len(st.Parts))
+	for _, p := range st.Parts {
+		done[p.Number] = true
+	}
+
+	workers := c.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	var (
+		mu       sync.Mutex
+		parts    = st.completedParts()
+		firstErr error
+		wg       sync.WaitGroup
+	)
+
+	jobs := make(chan int32)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for partNumber := range jobs {
+				offset := int64(partNumber-1) * partSize
+				length := min(partSize, size-offset)
+
+				completed, err := c.uploadPart(ctx, file, st, partNumber, offset, length, progress)
+
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = fmt.Errorf("part %d: %w", partNumber, err)
+					}
+					cancel()
+				} else {
+					parts = append(parts, completed)
+					onPart(completed)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+dispatch:
+	for partNumber := int32(1); partNumber <= numParts; partNumber++ {
+		if done[partNumber] {
+			continue
+		}
+		select {
+		case jobs <- partNumber:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(parts, func(i, j int) bool { return *parts[i].PartNumber < *parts[j].PartNumber })
+	return parts, nil
+}
+
+// uploadPart sends one part along with its SHA-256, which S3 checks. The
+// checksum is passed as a header rather than left to the SDK, which sends it
+// as a trailer that not every S3-compatible server understands. Failed
+// attempts are retried according to c.Retry, and take back the progress
+// they reported.
+func (c *Client) uploadPart(ctx context.Context, file io.ReaderAt, st *uploadState, partNumber int32, offset, length int64, progress *storage.ProgressTracker) (types.CompletedPart, error) {
+	sum, err := contentSHA256(io.NewSectionReader(file, offset, length), length)
+	if err != nil {
+		return types.CompletedPart{}, err
+	}
+	checksum := aws.String(base64.StdEncoding.EncodeToString(sum))
+
+	var result *s3.UploadPartOutput
+	err = c.withRetry(ctx, func(ctx context.Context) error {
+		ctx, cancel := c.requestContext(ctx)
+		defer cancel()
+
+		body := &progressBody{r: c.limitBody(ctx, io.NewSectionReader(file, offset, length)), t: progress}
+		var err error
+		result, err = c.s3Client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:         aws.String(st.Bucket),
+			Key:            aws.String(st.Key),
+			UploadId:       aws.String(st.UploadID),
+			PartNumber:     aws.Int32(partNumber),
+			Body:           body,
+			ContentLength:  aws.Int64(length),
+			ChecksumSHA256: checksum,
+		}, noRetry, unsignedPayload)
+		if err != nil {
+			body.discard()
+		}
+		return err
+	})
+	if err != nil {
+		return types.CompletedPart{}, err
+	}
+	return types.CompletedPart{ETag: result.ETag, PartNumber: aws.Int32(partNumber), ChecksumSHA256: checksum}, nil
+}
@@ -0,0 +1,60 @@
+package s3client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"tincan/pkg/storage"
+)
+
+// MaxPresignExpiry is the longest validity S3 accepts for a presigned URL.
+const MaxPresignExpiry = 7 * 24 * time.Hour
+
+var _ storage.Presigner = (*Client)(nil)
+
+func (c *Client) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	if err := checkPresignExpiry(expires); err != nil {
+		return "", err
+	}
+
+	req, err := s3.NewPresignClient(c.s3Client).PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(c.bucketName),
+		Key:    aws.String(c.objectKey(key)),
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", fmt.Errorf("unable to presign download of %q: %w", key, err)
+	}
+	return req.URL, nil
+}
+
+// PresignPut signs the PUT together with an If-None-Match: * header, so the
+// URL cannot overwrite key, whether it existed before or was uploaded
+// through the URL already.
+func (c *Client) PresignPut(ctx context.Context, key string, expires time.Duration) (string, error) {
+	if err := checkPresignExpiry(expires); err != nil {
+		return "", err
+	}
+
+	ifNoneMatch := func(o *s3.PresignOptions) {
+		o.ClientOptions = append(o.ClientOptions, s3.WithAPIOptions(smithyhttp.AddHeaderValue("If-None-Match", "*")))
+	}
+	req, err := s3.NewPresignClient(c.s3Client).PresignPutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(c.bucketName),
+		Key:    aws.String(c.objectKey(key)),
+	}, s3.WithPresignExpires(expires), ifNoneMatch)
+	if err != nil {
+		return "", fmt.Errorf("unable to presign upload of %q: %w", key, err)
+	}
+	return req.URL, nil
+}
+
+func checkPresignExpiry(expires time.Duration) error {
+	if expires <= 0 || expires > MaxPresignExpiry {
+		return fmt.Errorf("presigned URLs must expire within %s", MaxPresignExpiry)
+	}
+	return nil
+}
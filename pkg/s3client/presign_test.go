@@ -0,0 +1,103 @@
+package s3client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// putURL uploads content to a presigned upload link the way its users are
+// told to and returns the status code.
+func putURL(t *testing.T, link, content string) int {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPut, link, strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("If-None-Match", "*")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("PUT: %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestPresignExpiry(t *testing.T) {
+	client, _ := newTestClient(t, false)
+	ctx := context.Background()
+	for _, d := range []time.Duration{0, -time.Hour, MaxPresignExpiry + time.Second} {
+		if _, err := client.PresignGet(ctx, "x", d); err == nil {
+			t.Errorf("PresignGet with %v succeeded", d)
+		}
+		if _, err := client.PresignPut(ctx, "x", d); err == nil {
+			t.Errorf("PresignPut with %v succeeded", d)
+		}
+	}
+	link, err := client.PresignGet(ctx, "x", MaxPresignExpiry)
+	if err != nil {
+		t.Fatalf("PresignGet with the longest expiry: %v", err)
+	}
+	u, _ := url.Parse(link)
+	if got := u.Query().Get("X-Amz-Expires"); got != "604800" {
+		t.Errorf("X-Amz-Expires = %q, want 604800", got)
+	}
+}
+
+func TestPresignGet(t *testing.T) {
+	client, _ := newTestClient(t, false)
+	if err := client.UploadStream(context.Background(), strings.NewReader("shared content"), 14, "shared.txt"); err != nil {
+		t.Fatal(err)
+	}
+	link, err := client.PresignGet(context.Background(), "shared.txt", time.Hour)
+	if err != nil {
+		t.Fatalf("PresignGet: %v", err)
+	}
+	resp, err := http.Get(link)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "shared content" {
+		t.Fatalf("GET = %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestPresignPut(t *testing.T) {
+	client, _ := newTestClient(t, false)
+	ctx := context.Background()
+	link, err := client.PresignPut(ctx, "incoming.txt", time.Hour)
+	if err != nil {
+		t.Fatalf("PresignPut: %v", err)
+	}
+	u, _ := url.Parse(link)
+	if signed := u.Query().Get("X-Amz-SignedHeaders"); !strings.Contains(signed, "if-none-match") {
+		t.Fatalf("If-None-Match is not signed: %q", signed)
+	}
+
+	if code := putURL(t, link, "first"); code != http.StatusOK {
+		t.Fatalf("first PUT = %d", code)
+	}
+	// The link cannot replace what went through it
+	if code := putURL(t, link, "second"); code != http.StatusPreconditionFailed {
+		t.Fatalf("second PUT = %d, want %d", code, http.StatusPreconditionFailed)
+	}
+	var got strings.Builder
+	if err := client.DownloadStream(ctx, "incoming.txt", &got); err != nil || got.String() != "first" {
+		t.Fatalf("stored %q, %v; want the first upload", got.String(), err)
+	}
+
+	// Nor an object stored some other way
+	if err := client.UploadStream(ctx, strings.NewReader("existing"), 8, "existing.txt"); err != nil {
+		t.Fatal(err)
+	}
+	link, _ = client.PresignPut(ctx, "existing.txt", time.Hour)
+	if code := putURL(t, link, "replacement"); code != http.StatusPreconditionFailed {
+		t.Fatalf("PUT over an existing object = %d, want %d", code, http.StatusPreconditionFailed)
+	}
+}
@@ -0,0 +1,75 @@
+package s3client
+
+import (
+	"context"
+	"io"
+
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"tincan/pkg/storage"
+)
+
+// Progress and ProgressFunc are the storage package's, so that callers
+// written against storage.Storage observe transfers the same way.
+type (
+	Progress     = storage.Progress
+	ProgressFunc = storage.ProgressFunc
+)
+
+// WithProgress returns a context that makes the uploads and downloads
+// started with it report their progress to fn.
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return storage.WithProgress(ctx, fn)
+}
+
+// unsignedPayload stops the SDK from reading a body to sign it before it is
+// sent, which would count it twice. The body is still checked: its SHA-256
+// checksum is sent in a signed header.
+func unsignedPayload(o *s3.Options) {
+	o.APIOptions = append(o.APIOptions, v4.SwapComputePayloadSHA256ForUnsignedPayloadMiddleware)
+}
+
+// progressBody counts a request body as the SDK reads it. The SDK may seek
+// around it, to measure it or to send it again; only bytes read count, and
+// those past the position it seeks back to are taken back.
+type progressBody struct {
+	r       io.ReadSeeker
+	t       *storage.ProgressTracker
+	pos     int64
+	counted int64
+}
+
+func newProgressBody(r io.ReadSeeker, t *storage.ProgressTracker) io.ReadSeeker {
+	if t == nil {
+		return r
+	}
+	return &progressBody{r: r, t: t}
+}
+
+func (b *progressBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.pos += int64(n)
+	if b.pos > b.counted {
+		b.t.Add(b.pos - b.counted)
+		b.counted = b.pos
+	}
+	return n, err
+}
+
+func (b *progressBody) Seek(offset int64, whence int) (int64, error) {
+	pos, err := b.r.Seek(offset, whence)
+	if err == nil {
+		b.pos = pos
+		if pos < b.counted {
+			b.t.Add(pos - b.counted)
+			b.counted = pos
+		}
+	}
+	return pos, err
+}
+
+// discard takes back everything counted, for a request that failed.
+func (b *progressBody) discard() {
+	b.t.Add(-b.counted)
+	b.counted = 0
+}
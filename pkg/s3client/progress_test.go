@@ -0,0 +1,68 @@
+package s3client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"tincan/pkg/storage"
+)
+
+func TestProgress(t *testing.T) {
+	client, fake := newTestClient(t, false)
+	dir := t.TempDir()
+
+	// Each transfer must end with all of its bytes reported, however many
+	// attempts its parts and ranges took
+	var (
+		mu   sync.Mutex
+		last storage.Progress
+	)
+	ctx := storage.WithProgress(context.Background(), func(p storage.Progress) {
+		mu.Lock()
+		defer mu.Unlock()
+		last = p
+	})
+	check := func(op string, size int64) {
+		t.Helper()
+		mu.Lock()
+		defer mu.Unlock()
+		if last.Bytes != size || last.Total != size {
+			t.Fatalf("%s: last progress %d of %d bytes, want %d", op, last.Bytes, last.Total, size)
+		}
+		last = storage.Progress{}
+	}
+
+	for _, size := range []int{1000, 13 << 20} {
+		name := fmt.Sprintf("progress-%d", size)
+		writeRandomFile(t, dir, name, size)
+
+		if fake != nil {
+			fake.mu.Lock()
+			fake.slowDowns = 2
+			fake.mu.Unlock()
+		}
+		if err := client.UploadContext(ctx, filepath.Join(dir, name), name); err != nil {
+			t.Fatalf("UploadContext: %v", err)
+		}
+		check("UploadContext", int64(size))
+
+		if fake != nil {
+			fake.mu.Lock()
+			fake.truncations = 2
+			fake.mu.Unlock()
+		}
+		if err := client.DownloadContext(ctx, name, filepath.Join(dir, name+".downloaded")); err != nil {
+			t.Fatalf("DownloadContext: %v", err)
+		}
+		check("DownloadContext", int64(size))
+
+		if err := client.DownloadStream(ctx, name, io.Discard); err != nil {
+			t.Fatalf("DownloadStream: %v", err)
+		}
+		check("DownloadStream", int64(size))
+	}
+}
@@ -0,0 +1,23 @@
+package s3client
+
+import (
+	"context"
+	"io"
+)
+
+// limitedBody reads a request body no faster than the client's limit.
+type limitedBody struct {
+	io.ReadSeeker
+	r io.Reader
+}
+
+func (c *Client) limitBody(ctx context.Context, r io.ReadSeeker) io.ReadSeeker {
+	if c.Limiter == nil {
+		return r
+	}
+	return &limitedBody{ReadSeeker: r, r: c.Limiter.Reader(ctx, r)}
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	return b.r.Read(p)
+}
@@ -0,0 +1,54 @@
+package s3client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tincan/pkg/storage"
+)
+
+func TestRateLimit(t *testing.T) {
+	client, _ := newTestClient(t, false)
+	dir := t.TempDir()
+
+	// The parts and ranges running at once share the limit, so 13 MB at
+	// 10 MB/s take more than a second however many there are
+	const size, rate = 13 << 20, 10 << 20
+	client.Limiter = storage.NewRateLimiter(rate)
+	want := time.Duration(float64(size-rate/10) / rate * float64(time.Second))
+
+	data := writeRandomFile(t, dir, "limited", size)
+	start := time.Now()
+	if err := client.UploadContext(context.Background(), filepath.Join(dir, "limited"), "limited"); err != nil {
+		t.Fatalf("UploadContext: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < want {
+		t.Errorf("upload took %v, want at least %v", elapsed, want)
+	}
+
+	var buf bytes.Buffer
+	start = time.Now()
+	if err := client.DownloadStream(context.Background(), "limited", &buf); err != nil {
+		t.Fatalf("DownloadStream: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < want {
+		t.Errorf("download took %v, want at least %v", elapsed, want)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatal("downloaded content differs")
+	}
+
+	// Lifting the limit takes effect at once
+	client.Limiter.SetRate(0)
+	start = time.Now()
+	if err := client.DownloadStream(context.Background(), "limited", io.Discard); err != nil {
+		t.Fatalf("DownloadStream: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= want {
+		t.Errorf("unlimited download took %v", elapsed)
+	}
+}
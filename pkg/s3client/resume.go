@@ -0,0 +1,245 @@
+package s3client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"tincan/pkg/storage"
+)
+
+var _ storage.Resumable = (*Client)(nil)
+
+// uploadState is persisted in StateDir while a multipart upload is in
+// progress so an interrupted upload can be continued by a later process.
+type uploadState struct {
+	ID       string            `json:"id"`
+	Bucket   string            `json:"bucket"`
+	Key      string            `json:"key"`
+	UploadID string            `json:"uploadId"`
+	FilePath string            `json:"filePath"`
+	Size     int64             `json:"size"`
+	ModTime  time.Time         `json:"modTime"`
+	PartSize int64             `json:"partSize"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Parts    []part            `json:"parts"`
+	Started  time.Time         `json:"started"`
+}
+
+type part struct {
+	Number   int32  `json:"number"`
+	ETag     string `json:"etag"`
+	Checksum string `json:"checksum,omitempty"` // base64 SHA-256 of the part
+}
+
+// sessionID identifies a transfer between one key and one local file.
+func sessionID(bucket, key, filePath string) string {
+	sum := sha256.Sum256([]byte(bucket + "\x00" + key + "\x00" + filePath))
+	return hex.EncodeToString(sum[:6])
+}
+
+func loadState(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("corrupt transfer state %q: %w", path, err)
+	}
+	return nil
+}
+
+// saveState writes the state through a temporary file so a crash never
+// leaves a truncated state file behind.
+func saveState(path string, v any) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("unable to create state directory %q: %w", filepath.Dir(path), err)
+	}
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("unable to save transfer state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("unable to save transfer state: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) statePath(id string) string {
+	return filepath.Join(c.StateDir, "uploads", id+".json")
+}
+
+// matches reports whether the state was recorded for this exact file and
+// the same object metadata. The expiry is left out, as it moves with the
+// time the upload is retried; the resumed object keeps the original one.
+func (st *uploadState) matches(info os.FileInfo, metadata map[string]string) bool {
+	ignoreExpiry := func(k string, _ string) bool { return k == storage.MetaExpires }
+	a, b := maps.Clone(st.Metadata), maps.Clone(metadata)
+	maps.DeleteFunc(a, ignoreExpiry)
+	maps.DeleteFunc(b, ignoreExpiry)
+	return st.Size == info.Size() && st.ModTime.Equal(info.ModTime()) && maps.Equal(a, b)
+}
+
+func (st *uploadState) completedParts() []types.CompletedPart {
+	parts := make([]types.CompletedPart, 0, len(st.Parts))
+	for _, p := range st.Parts {
+		completed := types.CompletedPart{
+			ETag:       aws.String(p.ETag),
+			PartNumber: aws.Int32(p.Number),
+		}
+		if p.Checksum != "" {
+			completed.ChecksumSHA256 = aws.String(p.Checksum)
+		}
+		parts = append(parts, completed)
+	}
+	return parts
+}
+
+func (c *Client) loadUploadState(id string) (*uploadState, error) {
+	var st uploadState
+	if err := loadState(c.statePath(id), &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+func (c *Client) saveUploadState(st *uploadState) error {
+	return saveState(c.statePath(st.ID), st)
+}
+
+func (c *Client) removeUploadState(id string) {
+	os.Remove(c.statePath(id))
+}
+
+// resumeUpload returns the saved state for file if the multipart upload it
+// refers to can be continued. Stale state is cleaned up and nil is returned.
+// If S3 cannot be asked about the upload, the error is returned and the
+// state kept, so that a later attempt can still continue it.
+func (c *Client) resumeUpload(ctx context.Context, id string, info os.FileInfo, metadata map[string]string) (*uploadState, error) {
+	st, err := c.loadUploadState(id)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			c.removeUploadState(id)
+		}
+		return nil, nil
+	}
+
+	if st.matches(info, metadata) {
+		// Make sure S3 still knows about the upload; it may have been
+		// aborted by a lifecycle rule or from another machine.
+		reqCtx, cancel := c.requestContext(ctx)
+		defer cancel()
+		_, err := c.s3Client.ListParts(reqCtx, &s3.ListPartsInput{
+			Bucket:   aws.String(st.Bucket),
+			Key:      aws.String(st.Key),
+			UploadId: aws.String(st.UploadID),
+			MaxParts: aws.Int32(1),
+		})
+		if err == nil {
+			return st, nil
+		}
+		var noSuchUpload *types.NoSuchUpload
+		if !errors.As(err, &noSuchUpload) && !errors.Is(err, ErrNotFound) {
+			return nil, fmt.Errorf("unable to check upload of %q: %w", st.Key, err)
+		}
+	}
+
+	// The file changed or the upload is gone, so the parts are useless
+	c.abortUpload(st)
+	return nil, nil
+}
+
+func (c *Client) abortUpload(st *uploadState) error {
+	// Aborting must not inherit a cancelled context from the failed upload
+	ctx, cancel := c.requestContext(context.Background())
+	defer cancel()
+
+	_, err := c.s3Client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(st.Bucket),
+		Key:      aws.String(st.Key),
+		UploadId: aws.String(st.UploadID),
+	})
+	c.removeUploadState(st.ID)
+
+	var noSuchUpload *types.NoSuchUpload
+	if err != nil && !errors.As(err, &noSuchUpload) {
+		return fmt.Errorf("unable to abort upload of %q: %w", st.Key, err)
+	}
+	return nil
+}
+
+// PendingUploads lists the interrupted uploads recorded in StateDir.
+func (c *Client) PendingUploads() ([]storage.PendingUpload, error) {
+	if c.StateDir == "" {
+		return nil, nil
+	}
+
+	dir := filepath.Join(c.StateDir, "uploads")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to read state directory %q: %w", dir, err)
+	}
+
+	var pending []storage.PendingUpload
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+		st, err := c.loadUploadState(id)
+		if err != nil {
+			continue
+		}
+
+		uploaded := int64(len(st.Parts)) * st.PartSize
+		pending = append(pending, storage.PendingUpload{
+			ID:       st.ID,
+			Key:      st.Key,
+			FilePath: st.FilePath,
+			Size:     st.Size,
+			Uploaded: min(uploaded, st.Size),
+			Started:  st.Started,
+		})
+	}
+
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Started.Before(pending[j].Started) })
+	return pending, nil
+}
+
+// AbortUpload discards a pending upload and the parts already sent to S3.
+func (c *Client) AbortUpload(id string) error {
+	if c.StateDir == "" {
+		return fmt.Errorf("no pending upload %q", id)
+	}
+
+	st, err := c.loadUploadState(id)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("no pending upload %q", id)
+		}
+		return err
+	}
+
+	return c.abortUpload(st)
+}
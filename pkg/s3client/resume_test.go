@@ -0,0 +1,253 @@
+package s3client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func isPartUpload(r *http.Request) bool {
+	return r.Method == http.MethodPut && r.URL.Query().Has("uploadId")
+}
+
+// countRequests counts the requests matching match from now on, calling
+// cancel once the count goes past after, if cancel is not nil. The count is
+// read with fake.mu held.
+func countRequests(fake *fakeS3, match func(*http.Request) bool, after int, cancel context.CancelFunc) *int {
+	var n int
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.onRequest = func(r *http.Request) {
+		if !match(r) {
+			return
+		}
+		if n++; cancel != nil && n > after {
+			cancel()
+		}
+	}
+	return &n
+}
+
+func count(fake *fakeS3, n *int) int {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	return *n
+}
+
+// interruptUpload uploads path as key, cancelling the upload once one
+// part went through. Parts go one at a time so that the rest are left.
+func interruptUpload(t *testing.T, client *Client, fake *fakeS3, path, key string) {
+	t.Helper()
+	client.Concurrency = 1
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	countRequests(fake, isPartUpload, 1, cancel)
+	if err := client.UploadContext(ctx, path, key); err == nil {
+		t.Fatal("interrupted upload succeeded")
+	}
+	countRequests(fake, func(*http.Request) bool { return false }, 0, nil)
+}
+
+func TestResumeUpload(t *testing.T) {
+	client, fake := newTestClient(t, false)
+	if fake == nil {
+		t.Skip("needs the stand-in server to interrupt transfers")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "big")
+	data := writeRandomFile(t, dir, "big", 16<<20) // parts of 5, 5, 5 and 1 MiB
+
+	interruptUpload(t, client, fake, path, "big")
+
+	pending, err := client.PendingUploads()
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("PendingUploads = %v, %v", pending, err)
+	}
+	p := pending[0]
+	if p.Key != client.objectKey("big") || p.FilePath != path || p.Size != int64(len(data)) {
+		t.Fatalf("pending upload = %+v", p)
+	}
+	if p.Uploaded < 5<<20 || p.Uploaded >= p.Size {
+		t.Fatalf("pending upload has %d of %d bytes", p.Uploaded, p.Size)
+	}
+	if _, err := client.Stat("big"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Stat of an unfinished upload = %v", err)
+	}
+
+	// Only the missing parts go out again, to the same multipart upload
+	fake.mu.Lock()
+	started := fake.nextID
+	fake.mu.Unlock()
+	parts := countRequests(fake, isPartUpload, 0, nil)
+	if err := client.Upload(path, "big"); err != nil {
+		t.Fatalf("resumed Upload: %v", err)
+	}
+	if want := 4 - int(p.Uploaded/(5<<20)); count(fake, parts) != want {
+		t.Errorf("resumed upload sent %d parts, want %d", count(fake, parts), want)
+	}
+	fake.mu.Lock()
+	if fake.nextID != started {
+		t.Error("resumed upload started a new multipart upload")
+	}
+	got := fake.objects[client.objectKey("big")]
+	fake.mu.Unlock()
+	if got == nil || !bytes.Equal(got.data, data) {
+		t.Fatal("resumed upload stored the wrong content")
+	}
+	if pending, _ := client.PendingUploads(); len(pending) != 0 {
+		t.Fatalf("PendingUploads after the upload = %v", pending)
+	}
+}
+
+func TestResumeUploadChangedFile(t *testing.T) {
+	client, fake := newTestClient(t, false)
+	if fake == nil {
+		t.Skip("needs the stand-in server to interrupt transfers")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "big")
+	writeRandomFile(t, dir, "big", 16<<20)
+
+	interruptUpload(t, client, fake, path, "big")
+	fake.mu.Lock()
+	stale := len(fake.uploads)
+	fake.mu.Unlock()
+	if stale != 1 {
+		t.Fatalf("%d multipart uploads after the interruption, want 1", stale)
+	}
+
+	// The parts of the old content must not end up in the object
+	data := writeRandomFile(t, dir, "big", 16<<20)
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Minute))
+	parts := countRequests(fake, isPartUpload, 0, nil)
+	if err := client.Upload(path, "big"); err != nil {
+		t.Fatalf("Upload of the changed file: %v", err)
+	}
+	if n := count(fake, parts); n != 4 {
+		t.Errorf("upload of the changed file sent %d parts, want all 4", n)
+	}
+	fake.mu.Lock()
+	got := fake.objects[client.objectKey("big")]
+	left := len(fake.uploads)
+	fake.mu.Unlock()
+	if got == nil || !bytes.Equal(got.data, data) {
+		t.Fatal("upload of the changed file stored the wrong content")
+	}
+	if left != 0 {
+		t.Errorf("%d multipart uploads left, want the stale one aborted", left)
+	}
+
+	// So must those of an upload gone from the server
+	interruptUpload(t, client, fake, path, "big")
+	fake.mu.Lock()
+	clear(fake.uploads)
+	fake.mu.Unlock()
+	parts = countRequests(fake, isPartUpload, 0, nil)
+	if err := client.Upload(path, "big"); err != nil {
+		t.Fatalf("Upload after the multipart upload vanished: %v", err)
+	}
+	if n := count(fake, parts); n != 4 {
+		t.Errorf("upload after the multipart upload vanished sent %d parts, want all 4", n)
+	}
+}
+
+func TestAbortUpload(t *testing.T) {
+	client, fake := newTestClient(t, false)
+	if fake == nil {
+		t.Skip("needs the stand-in server to interrupt transfers")
+	}
+	dir := t.TempDir()
+	writeRandomFile(t, dir, "big", 16<<20)
+	writeRandomFile(t, dir, "other", 11<<20)
+	interruptUpload(t, client, fake, filepath.Join(dir, "big"), "big")
+	interruptUpload(t, client, fake, filepath.Join(dir, "other"), "other")
+
+	pending, err := client.PendingUploads()
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("PendingUploads = %v, %v", pending, err)
+	}
+	if !pending[0].Started.Before(pending[1].Started) {
+		t.Errorf("pending uploads are not in the order they started: %v", pending)
+	}
+
+	if err := client.AbortUpload(pending[0].ID); err != nil {
+		t.Fatalf("AbortUpload: %v", err)
+	}
+	if err := client.AbortUpload(pending[0].ID); err == nil {
+		t.Error("second AbortUpload of the same upload succeeded")
+	}
+	if err := client.AbortUpload("nonexistent"); err == nil {
+		t.Error("AbortUpload of an unknown ID succeeded")
+	}
+	if left, _ := client.PendingUploads(); len(left) != 1 || left[0].ID != pending[1].ID {
+		t.Fatalf("PendingUploads after the abort = %v", left)
+	}
+	fake.mu.Lock()
+	uploads := len(fake.uploads)
+	fake.mu.Unlock()
+	if uploads != 1 {
+		t.Fatalf("%d multipart uploads left on the server, want 1", uploads)
+	}
+
+	// An upload already gone from the server is still forgotten
+	fake.mu.Lock()
+	clear(fake.uploads)
+	fake.mu.Unlock()
+	if err := client.AbortUpload(pending[1].ID); err != nil {
+		t.Fatalf("AbortUpload of a vanished upload: %v", err)
+	}
+	if left, _ := client.PendingUploads(); len(left) != 0 {
+		t.Fatalf("PendingUploads after aborting everything = %v", left)
+	}
+}
+
+func TestResumeUploadCheckFails(t *testing.T) {
+	client, fake := newTestClient(t, false)
+	if fake == nil {
+		t.Skip("needs the stand-in server to interrupt transfers")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "big")
+	data := writeRandomFile(t, dir, "big", 16<<20)
+	interruptUpload(t, client, fake, path, "big")
+
+	// Neither a cancelled upload nor throttling that outlasts the retries
+	// may throw away the parts already sent
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := client.UploadContext(ctx, path, "big"); err == nil {
+		t.Fatal("cancelled Upload succeeded")
+	}
+	fake.mu.Lock()
+	fake.slowDowns = 100
+	fake.mu.Unlock()
+	if err := client.Upload(path, "big"); !errors.Is(err, ErrThrottled) {
+		t.Fatalf("throttled Upload = %v, want ErrThrottled", err)
+	}
+	fake.mu.Lock()
+	fake.slowDowns = 0
+	uploads := len(fake.uploads)
+	fake.mu.Unlock()
+	if pending, _ := client.PendingUploads(); len(pending) != 1 || uploads != 1 {
+		t.Fatalf("%d pending uploads and %d on the server after the failed checks, want 1 each", len(pending), uploads)
+	}
+
+	parts := countRequests(fake, isPartUpload, 0, nil)
+	if err := client.Upload(path, "big"); err != nil {
+		t.Fatalf("resumed Upload: %v", err)
+	}
+	if n := count(fake, parts); n == 4 {
+		t.Error("upload after the failed checks sent all parts again")
+	}
+	fake.mu.Lock()
+	got := fake.objects[client.objectKey("big")]
+	fake.mu.Unlock()
+	if got == nil || !bytes.Equal(got.data, data) {
+		t.Fatal("resumed upload stored the wrong content")
+	}
+}
@@ -0,0 +1,95 @@
+package s3client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// RetryPolicy controls how failed requests are retried. Throttling, server
+// errors, timeouts and dropped connections are retried after an
+// exponentially growing, jittered delay; other failures are returned at
+// once.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts per request, including the
+	// first. 1 disables retries.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, doubled for each one
+	// after it.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between attempts.
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy is used when the configuration does not set one.
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 5, BaseDelay: 200 * time.Millisecond, MaxDelay: 20 * time.Second}
+
+// BackoffDelay returns the delay before retry number attempt, counting from
+// 1. Half of it is random, so that parts failing together do not all retry
+// at the same moment. It implements retry.BackoffDelayer.
+func (p RetryPolicy) BackoffDelay(attempt int, err error) (time.Duration, error) {
+	d := p.MaxDelay
+	if attempt < 32 && p.BaseDelay<<(attempt-1) < d {
+		d = p.BaseDelay << (attempt - 1)
+	}
+	if d <= 0 {
+		return 0, nil
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1)), nil
+}
+
+// retryer returns an SDK retryer that follows p.
+func (p RetryPolicy) retryer() aws.Retryer {
+	return retry.NewStandard(func(o *retry.StandardOptions) {
+		o.MaxAttempts = max(p.MaxAttempts, 1)
+		o.MaxBackoff = p.MaxDelay
+		o.Backoff = p
+	})
+}
+
+var retryables = retry.IsErrorRetryables(retry.DefaultRetryables)
+
+// retryable reports whether a request that failed with err may succeed if
+// it is sent again.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	// The request timed out, or its body was cut off
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	return retryables.IsErrorRetryable(err) == aws.TrueTernary
+}
+
+// noRetry turns off the SDK's own retries for an operation that is retried
+// by withRetry, so the two do not multiply.
+func noRetry(o *s3.Options) {
+	o.Retryer = aws.NopRetryer{}
+}
+
+// withRetry calls fn until it succeeds, fails with an error that is not
+// worth retrying, or c.Retry runs out of attempts. It is used for the parts
+// and ranges of transfers, whose bodies the SDK cannot resend or re-read by
+// itself.
+func (c *Client) withRetry(ctx context.Context, fn func(ctx context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || attempt >= c.Retry.MaxAttempts || !retryable(ctx, err) {
+			return err
+		}
+
+		delay, _ := c.Retry.BackoffDelay(attempt, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
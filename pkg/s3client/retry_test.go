@@ -0,0 +1,51 @@
+package s3client
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRetries(t *testing.T) {
+	client, fake := newTestClient(t, false)
+	if fake == nil {
+		t.Skip("needs the stand-in server to inject failures")
+	}
+	dir := t.TempDir()
+	data := writeRandomFile(t, dir, "file", 13<<20)
+
+	fake.mu.Lock()
+	fake.slowDowns = 3
+	fake.mu.Unlock()
+	if err := client.Upload(filepath.Join(dir, "file"), "file"); err != nil {
+		t.Fatalf("Upload with throttled requests: %v", err)
+	}
+
+	fake.mu.Lock()
+	fake.slowDowns, fake.truncations = 2, 2
+	fake.mu.Unlock()
+	dest := filepath.Join(dir, "downloaded")
+	if err := client.Download("file", dest); err != nil {
+		t.Fatalf("Download with throttled requests and cut off ranges: %v", err)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, data) {
+		t.Fatal("Download: content differs")
+	}
+
+	fake.mu.Lock()
+	fake.slowDowns = 100
+	fake.mu.Unlock()
+	err := client.Download("file", dest)
+	if !errors.Is(err, ErrThrottled) {
+		t.Fatalf("Download once retries run out = %v, want ErrThrottled", err)
+	}
+	fake.mu.Lock()
+	fake.slowDowns = 0
+	fake.mu.Unlock()
+
+	if err := client.Download("missing", dest); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Download of a missing key = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,15 @@
+package s3client
+
+import (
+	"testing"
+
+	"tincan/pkg/storage"
+	"tincan/pkg/storage/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		client, _ := newTestClient(t, false)
+		return client
+	})
+}
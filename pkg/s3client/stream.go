@@ -0,0 +1,241 @@
+package s3client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"tincan/pkg/storage"
+)
+
+// UploadStream uploads what is read from r as key. A stream shorter than
+// MultipartThreshold is held in memory and sent like a small file, checksum
+// included. Longer ones are sent as a multipart upload, Concurrency parts of
+// PartSize at a time, which S3 checks part by part but which has no
+// checksum of the whole content recorded, and cannot be resumed. Parts of
+// a stream of unknown size grow as it goes on, so that it may reach the
+// largest size S3 accepts.
+//
+// If size is not -1, a stream of any other length fails the upload.
+func (c *Client) UploadStream(ctx context.Context, r io.Reader, size int64, key string, opts ...storage.UploadOption) error {
+	options := storage.NewUploadOptions(opts...)
+	progress := storage.TrackProgress(ctx, key, size)
+
+	head, err := io.ReadAll(io.LimitReader(r, c.MultipartThreshold))
+	if err != nil {
+		return fmt.Errorf("unable to upload %q to %q: %w", key, c.bucketName, err)
+	}
+	if int64(len(head)) < c.MultipartThreshold {
+		if size >= 0 && int64(len(head)) != size {
+			return fmt.Errorf("unable to upload %q to %q: read %d bytes, expected %d", key, c.bucketName, len(head), size)
+		}
+
+		sum := sha256.Sum256(head)
+		if options.Metadata == nil {
+			options.Metadata = make(map[string]string)
+		}
+		options.Metadata[storage.MetaContentSHA256] = hex.EncodeToString(sum[:])
+		if err := c.putObject(ctx, newProgressBody(c.limitBody(ctx, bytes.NewReader(head)), progress), sum[:], key, options); err != nil {
+			return fmt.Errorf("unable to upload %q to %q: %w", key, c.bucketName, err)
+		}
+		return nil
+	}
+
+	uploadID, err := c.createMultipartUpload(ctx, c.objectKey(key), options)
+	if err != nil {
+		return fmt.Errorf("unable to start multipart upload of %q to %q: %w", key, c.bucketName, err)
+	}
+	st := &uploadState{
+		Bucket:   c.bucketName,
+		Key:      c.objectKey(key),
+		UploadID: uploadID,
+		PartSize: c.partSizeFor(max(size, 0)),
+	}
+
+	parts, err := c.uploadStreamParts(ctx, io.MultiReader(bytes.NewReader(head), r), size, st, progress)
+	if err == nil {
+		err = c.completeMultipartUpload(ctx, st, parts)
+	}
+	if err != nil {
+		c.abortUpload(st)
+		return fmt.Errorf("unable to upload %q to %q: %w", key, c.bucketName, err)
+	}
+	return nil
+}
+
+// uploadStreamParts reads r one part at a time and uploads the parts using
+// c.Concurrency workers, returning them in order. The first failure cancels
+// the rest.
+func (c *Client) uploadStreamParts(ctx context.Context, r io.Reader, size int64, st *uploadState, progress *storage.ProgressTracker) ([]types.CompletedPart, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type job struct {
+		number int32
+		data   []byte
+	}
+
+	var (
+		mu       sync.Mutex
+		parts    []types.CompletedPart
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		cancel()
+	}
+
+	jobs := make(chan job)
+	for i := 0; i < max(c.Concurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				completed, err := c.uploadPart(ctx, bytes.NewReader(j.data), st, j.number, 0, int64(len(j.data)), progress)
+				if err != nil {
+					fail(fmt.Errorf("part %d: %w", j.number, err))
+					continue
+				}
+				mu.Lock()
+				parts = append(parts, completed)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	// The length of a stream of unknown size is only found at its end, so
+	// its parts grow as it goes on: twice as large every 1,000 parts
+	partSize := st.PartSize
+	var total int64
+read:
+	for number := int32(1); ; number++ {
+		if size < 0 && number%1000 == 1 && number > 1 {
+			partSize = min(partSize*2, maxPartSize)
+		}
+		data := make([]byte, partSize)
+		n, err := io.ReadFull(r, data)
+		total += int64(n)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			fail(err)
+			break
+		}
+		if number > maxParts {
+			fail(fmt.Errorf("stream is larger than %d parts", maxParts))
+			break
+		}
+
+		select {
+		case jobs <- job{number, data[:n]}:
+		case <-ctx.Done():
+			break read
+		}
+		if err != nil {
+			break // the last part was short
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if size >= 0 && total != size {
+		return nil, fmt.Errorf("read %d bytes, expected %d", total, size)
+	}
+
+	sort.Slice(parts, func(i, j int) bool { return aws.ToInt32(parts[i].PartNumber) < aws.ToInt32(parts[j].PartNumber) })
+	return parts, nil
+}
+
+// DownloadStream writes the content of key to w. Ranges are fetched up to
+// DownloadConcurrency at a time and held in memory until it is their turn
+// to be written. The checksum is checked once everything was written, and a
+// burn-after-reading object is deleted after that.
+func (c *Client) DownloadStream(ctx context.Context, key string, w io.Writer) error {
+	return c.read(ctx, key, func(ctx context.Context, info *FileInfo) error {
+		hash := sha256.New()
+		progress := storage.TrackProgress(ctx, key, info.Size)
+		if err := c.streamRanges(ctx, c.objectKey(key), info.ETag, info.Size, progress.Writer(io.MultiWriter(w, hash))); err != nil {
+			return fmt.Errorf("unable to download %q from %q: %w", key, c.bucketName, err)
+		}
+		if want := info.Metadata[storage.MetaContentSHA256]; want != "" && hex.EncodeToString(hash.Sum(nil)) != want {
+			return fmt.Errorf("downloaded %q: %w", key, storage.ErrChecksum)
+		}
+		return nil
+	})
+}
+
+// streamRanges writes size bytes of the S3 key to w in order.
+func (c *Client) streamRanges(ctx context.Context, key, etag string, size int64, w io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type chunk struct {
+		data []byte
+		err  error
+	}
+
+	chunkSize := c.partSizeFor(size)
+	numChunks := int((size + chunkSize - 1) / chunkSize)
+	results := make([]chan chunk, numChunks)
+	for i := range results {
+		results[i] = make(chan chunk, 1)
+	}
+
+	// ahead bounds the ranges that are fetched but not yet written
+	ahead := make(chan struct{}, max(c.DownloadConcurrency, 1))
+	go func() {
+		for i := 0; i < numChunks; i++ {
+			select {
+			case ahead <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			go func(i int) {
+				offset := int64(i) * chunkSize
+				buf := bytes.NewBuffer(make([]byte, 0, min(chunkSize, size-offset)))
+				err := c.getRange(ctx, key, etag, offset, int64(buf.Cap()), nil, func() io.Writer {
+					buf.Reset()
+					return buf
+				})
+				results[i] <- chunk{buf.Bytes(), err}
+			}(i)
+		}
+	}()
+
+	for i := 0; i < numChunks; i++ {
+		var result chunk
+		select {
+		case result = <-results[i]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if result.err != nil {
+			return result.err
+		}
+		if _, err := w.Write(result.data); err != nil {
+			return err
+		}
+		<-ahead
+	}
+	return nil
+}
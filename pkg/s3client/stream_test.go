@@ -0,0 +1,73 @@
+package s3client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	"tincan/pkg/storage"
+)
+
+func TestStreams(t *testing.T) {
+	client, fake := newTestClient(t, false)
+
+	for _, tc := range []struct {
+		name  string
+		size  int
+		known bool // tell UploadStream the size
+	}{
+		{"single", 1000, true},
+		{"single-unknown", 1000, false},
+		{"multipart", 13 << 20, true},
+		{"multipart-unknown", 13 << 20, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := make([]byte, tc.size)
+			rand.Read(data)
+			size := int64(-1)
+			if tc.known {
+				size = int64(tc.size)
+			}
+			key := "stream/" + tc.name
+			if err := client.UploadStream(context.Background(), bytes.NewReader(data), size, key); err != nil {
+				t.Fatalf("UploadStream: %v", err)
+			}
+
+			var got bytes.Buffer
+			if err := client.DownloadStream(context.Background(), key, &got); err != nil {
+				t.Fatalf("DownloadStream: %v", err)
+			}
+			if !bytes.Equal(got.Bytes(), data) {
+				t.Fatalf("streamed %d bytes back, want the %d uploaded", got.Len(), len(data))
+			}
+
+			if fake == nil || tc.size >= 6<<20 {
+				return // only whole uploads record a checksum
+			}
+			fake.mu.Lock()
+			fake.objects[client.prefix+key].data[tc.size/2] ^= 1
+			fake.mu.Unlock()
+			if err := client.DownloadStream(context.Background(), key, io.Discard); !errors.Is(err, storage.ErrChecksum) {
+				t.Fatalf("DownloadStream of corrupted object = %v, want ErrChecksum", err)
+			}
+		})
+	}
+
+	t.Run("wrong size", func(t *testing.T) {
+		for _, n := range []int{1000, 13 << 20} {
+			err := client.UploadStream(context.Background(), bytes.NewReader(make([]byte, n)), int64(n)+1, "stream/short")
+			if err == nil {
+				t.Fatalf("UploadStream of %d bytes announced as %d succeeded", n, n+1)
+			}
+		}
+		if _, err := client.Stat("stream/short"); !errors.Is(err, storage.ErrNotExist) {
+			t.Fatalf("Stat after failed uploads = %v, want ErrNotExist", err)
+		}
+		if fake != nil && len(fake.uploads) != 0 {
+			t.Fatalf("%d multipart uploads were left behind", len(fake.uploads))
+		}
+	})
+}
@@ -0,0 +1,455 @@
+// Package local implements storage.Storage on top of a directory on disk.
+package local
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"tincan/pkg/storage"
+)
+
+// metaDir holds a JSON sidecar with the metadata of each file that has any,
+// and claimDir a lock file for each burn-after-reading file being
+// downloaded. Names starting with .tincan- are never reported as files.
+const (
+	metaDir  = ".tincan-meta"
+	claimDir = ".tincan-claims"
+)
+
+type Storage struct {
+	// Limiter bounds the bandwidth of uploads and downloads, for a root on
+	// a network share. Nil means no limit.
+	Limiter *storage.RateLimiter
+
+	root string
+}
+
+var _ storage.Storage = (*Storage)(nil)
+
+// New returns a backend that stores every key as a file below root,
+// creating the directory if needed.
+func New(root string) (*Storage, error) {
+	if root == "" {
+		return nil, fmt.Errorf("local storage path is required")
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("unable to create storage directory %q: %w", root, err)
+	}
+	return &Storage{root: root}, nil
+}
+
+// path maps a key to a file below root, rejecting keys that would escape it.
+func (s *Storage) path(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if key == "" || cleaned == "/" || strings.HasSuffix(key, "/") || strings.Contains(cleaned, "/.tincan-") {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(cleaned[1:])), nil
+}
+
+func (s *Storage) Upload(filePath, key string, opts ...storage.UploadOption) error {
+	return s.UploadContext(context.Background(), filePath, key, opts...)
+}
+
+func (s *Storage) UploadContext(ctx context.Context, filePath, key string, opts ...storage.UploadOption) error {
+	src, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("unable to open file %q: %w", filePath, err)
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("unable to stat file %q: %w", filePath, err)
+	}
+	return s.UploadStream(ctx, src, info.Size(), key, opts...)
+}
+
+func (s *Storage) UploadStream(ctx context.Context, r io.Reader, size int64, key string, opts ...storage.UploadOption) error {
+	options := storage.NewUploadOptions(opts...)
+
+	dest, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return fmt.Errorf("unable to create directory for %q: %w", key, err)
+	}
+
+	progress := storage.TrackProgress(ctx, key, size)
+	hash := sha256.New()
+	var written countingWriter
+	// A stream that ends early or runs long never replaces dest
+	verify := func() error {
+		if size >= 0 && written.n != size {
+			return fmt.Errorf("unable to upload %q: read %d bytes, expected %d", key, written.n, size)
+		}
+		return nil
+	}
+	if err := writeFile(dest, io.TeeReader(progress.Reader(s.Limiter.Reader(ctx, &contextReader{ctx, r})), io.MultiWriter(hash, &written)), verify); err != nil {
+		return err
+	}
+
+	metadata := make(map[string]string, len(options.Metadata)+1)
+	for k, v := range options.Metadata {
+		metadata[k] = v
+	}
+	metadata[storage.MetaContentSHA256] = hex.EncodeToString(hash.Sum(nil))
+	return s.writeMetadata(key, metadata)
+}
+
+func (s *Storage) Download(key, filePath string) error {
+	return s.DownloadContext(context.Background(), key, filePath)
+}
+
+// DownloadContext copies key to filePath. A burn-after-reading file is
+// claimed while it is copied and deleted once the copy is complete.
+func (s *Storage) DownloadContext(ctx context.Context, key, filePath string) error {
+	return s.read(ctx, key, func(r io.Reader, verify func() error) error {
+		// The copy is checked before it replaces filePath
+		return writeFile(filePath, r, verify)
+	})
+}
+
+func (s *Storage) DownloadStream(ctx context.Context, key string, w io.Writer) error {
+	return s.read(ctx, key, func(r io.Reader, verify func() error) error {
+		if _, err := io.Copy(w, r); err != nil {
+			return fmt.Errorf("unable to copy %q: %w", key, err)
+		}
+		return verify()
+	})
+}
+
+// read passes the content of key to write, along with a function that
+// checks what was read against the file's size and checksum. A
+// burn-after-reading file is claimed while it is read and deleted once
+// write succeeds.
+func (s *Storage) read(ctx context.Context, key string, write func(r io.Reader, verify func() error) error) error {
+	src, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	metadata, err := s.readMetadata(key)
+	if err != nil {
+		return err
+	}
+	burn, keepClaim := metadata[storage.MetaBurn] == "true", false
+	if burn {
+		if err := s.claim(key); err != nil {
+			return err
+		}
+		stop := storage.HoldClaim(func() {
+			now := time.Now()
+			os.Chtimes(s.claimPath(key), now, now)
+		})
+		defer func() {
+			stop()
+			if !keepClaim {
+				os.Remove(s.claimPath(key))
+			}
+		}()
+	}
+
+	file, err := os.Open(src)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("%q: %w", key, storage.ErrNotExist)
+		}
+		return fmt.Errorf("unable to open %q: %w", key, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("unable to stat %q: %w", key, err)
+	}
+
+	progress := storage.TrackProgress(ctx, key, info.Size())
+	hash := sha256.New()
+	verify := func() error {
+		info, err := file.Stat()
+		if err != nil {
+			return fmt.Errorf("unable to stat %q: %w", key, err)
+		}
+		if read, err := file.Seek(0, io.SeekCurrent); err != nil || read != info.Size() {
+			return fmt.Errorf("unable to verify the download of %q", key)
+		}
+		if want := metadata[storage.MetaContentSHA256]; want != "" && hex.EncodeToString(hash.Sum(nil)) != want {
+			return fmt.Errorf("downloaded %q: %w", key, storage.ErrChecksum)
+		}
+		return nil
+	}
+	if err := write(io.TeeReader(progress.Reader(s.Limiter.Reader(ctx, &contextReader{ctx, file})), hash), verify); err != nil {
+		return err
+	}
+	if !burn {
+		return nil
+	}
+
+	if err := s.DeleteContext(context.Background(), key); err != nil {
+		keepClaim = true
+		return fmt.Errorf("downloaded %q but unable to delete it: %w", key, err)
+	}
+	return nil
+}
+
+func (s *Storage) claimPath(key string) string {
+	return filepath.Join(s.root, claimDir, filepath.FromSlash(path.Clean("/" + key)[1:]))
+}
+
+// claim creates the lock file for key, failing with storage.ErrClaimed if
+// another download holds it.
+func (s *Storage) claim(key string) error {
+	p := s.claimPath(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return fmt.Errorf("unable to claim %q: %w", key, err)
+	}
+	for attempt := 0; ; attempt++ {
+		f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			return f.Close()
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("unable to claim %q: %w", key, err)
+		}
+
+		// Take over a claim left behind by a download that never finished
+		info, err := os.Stat(p)
+		if attempt > 0 || err != nil || time.Since(info.ModTime()) < storage.ClaimTimeout {
+			return fmt.Errorf("%q: %w", key, storage.ErrClaimed)
+		}
+		os.Remove(p)
+	}
+}
+
+func (s *Storage) List() ([]storage.FileInfo, error) {
+	return s.ListContext(context.Background())
+}
+
+func (s *Storage) ListContext(ctx context.Context) ([]storage.FileInfo, error) {
+	page, err := s.ListPage(ctx, storage.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return page.Files, nil
+}
+
+// ListPage lists the files below root. Without a limit every remaining file
+// is returned.
+func (s *Storage) ListPage(ctx context.Context, opts storage.ListOptions) (*storage.Page, error) {
+	var files []storage.FileInfo
+	err := filepath.WalkDir(s.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".tincan-") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if d.IsDir() {
+			// Skip directories that cannot contain keys with the prefix
+			if dir := key + "/"; key != "." && !strings.HasPrefix(dir, opts.Prefix) && !strings.HasPrefix(opts.Prefix, dir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasPrefix(key, opts.Prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, storage.FileInfo{
+			Name:         key,
+			Size:         info.Size(),
+			LastModified: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list %q: %w", s.root, err)
+	}
+
+	page := storage.NewPage(files, opts)
+	for i := range page.Files {
+		if page.Files[i].Metadata, err = s.readMetadata(page.Files[i].Name); err != nil {
+			return nil, err
+		}
+	}
+
+	return page, nil
+}
+
+func (s *Storage) Stat(key string) (*storage.FileInfo, error) {
+	return s.StatContext(context.Background(), key)
+}
+
+func (s *Storage) StatContext(ctx context.Context, key string) (*storage.FileInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(p)
+	if err != nil || info.IsDir() {
+		if err == nil || errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%q: %w", key, storage.ErrNotExist)
+		}
+		return nil, fmt.Errorf("unable to stat %q: %w", key, err)
+	}
+
+	metadata, err := s.readMetadata(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &storage.FileInfo{
+		Name:         key,
+		Size:         info.Size(),
+		LastModified: info.ModTime(),
+		Metadata:     metadata,
+	}, nil
+}
+
+func (s *Storage) Delete(key string) error {
+	return s.DeleteContext(context.Background(), key)
+}
+
+func (s *Storage) DeleteContext(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("unable to delete %q: %w", key, err)
+	}
+
+	return s.writeMetadata(key, nil)
+}
+
+func (s *Storage) metadataPath(key string) string {
+	return filepath.Join(s.root, metaDir, filepath.FromSlash(path.Clean("/" + key)[1:])+".json")
+}
+
+func (s *Storage) readMetadata(key string) (map[string]string, error) {
+	data, err := os.ReadFile(s.metadataPath(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read metadata of %q: %w", key, err)
+	}
+
+	var metadata map[string]string
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("unable to read metadata of %q: %w", key, err)
+	}
+	return metadata, nil
+}
+
+// writeMetadata replaces the metadata of key, removing the sidecar when
+// there is none.
+func (s *Storage) writeMetadata(key string, metadata map[string]string) error {
+	p := s.metadataPath(key)
+	if len(metadata) == 0 {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("unable to remove metadata of %q: %w", key, err)
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return fmt.Errorf("unable to write metadata of %q: %w", key, err)
+	}
+	return writeFile(p, bytes.NewReader(data), nil)
+}
+
+// writeFile copies r into a temporary file next to dest and renames it into
+// place, so readers never observe a half-written file. If verify is not nil,
+// the copy is only renamed into place when it returns nil.
+func writeFile(dest string, r io.Reader, verify func() error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".tincan-*")
+	if err != nil {
+		return fmt.Errorf("unable to create file for %q: %w", dest, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write to file %q: %w", dest, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write to file %q: %w", dest, err)
+	}
+	if verify != nil {
+		if err := verify(); err != nil {
+			return err
+		}
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return fmt.Errorf("unable to write to file %q: %w", dest, err)
+	}
+
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return fmt.Errorf("unable to write to file %q: %w", dest, err)
+	}
+
+	return nil
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// contextReader fails reads once ctx is cancelled, so long copies stop early.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,101 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"tincan/pkg/storage"
+	"tincan/pkg/storage/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		s, err := New(t.TempDir())
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		return s
+	})
+}
+
+// blockingWriter holds up a download at its first write until released.
+type blockingWriter struct {
+	started chan struct{}
+	release chan struct{}
+	once    bool
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	if !w.once {
+		w.once = true
+		close(w.started)
+		<-w.release
+	}
+	return len(p), nil
+}
+
+func TestClaims(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer func(d time.Duration) { storage.ClaimRefresh = d }(storage.ClaimRefresh)
+	storage.ClaimRefresh = 10 * time.Millisecond
+
+	burn := storage.WithMetadata(map[string]string{storage.MetaBurn: "true"})
+	age := func(d time.Duration) {
+		old := time.Now().Add(-d)
+		if err := os.Chtimes(s.claimPath("slow"), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A download that outlasts ClaimTimeout keeps renewing its claim, so
+	// nobody takes it over
+	if err := s.UploadStream(context.Background(), strings.NewReader("secret"), -1, "slow", burn); err != nil {
+		t.Fatalf("UploadStream: %v", err)
+	}
+	w := &blockingWriter{started: make(chan struct{}), release: make(chan struct{})}
+	done := make(chan error, 1)
+	go func() { done <- s.DownloadStream(context.Background(), "slow", w) }()
+	<-w.started
+	age(2 * storage.ClaimTimeout)
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		if info, err := os.Stat(s.claimPath("slow")); err == nil && time.Since(info.ModTime()) < time.Minute {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the claim of a running download was not renewed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := s.DownloadStream(context.Background(), "slow", io.Discard); !errors.Is(err, storage.ErrClaimed) {
+		t.Fatalf("download during a long one = %v, want ErrClaimed", err)
+	}
+	close(w.release)
+	if err := <-done; err != nil {
+		t.Fatalf("long download: %v", err)
+	}
+
+	// A claim left behind by a crashed download is taken over once stale
+	if err := s.UploadStream(context.Background(), strings.NewReader("secret"), -1, "slow", burn); err != nil {
+		t.Fatalf("UploadStream: %v", err)
+	}
+	if err := s.claim("slow"); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if err := s.DownloadStream(context.Background(), "slow", io.Discard); !errors.Is(err, storage.ErrClaimed) {
+		t.Fatalf("download of a claimed file = %v, want ErrClaimed", err)
+	}
+	age(2 * storage.ClaimTimeout)
+	var buf bytes.Buffer
+	if err := s.DownloadStream(context.Background(), "slow", &buf); err != nil || buf.String() != "secret" {
+		t.Fatalf("download after the claim went stale = %q, %v", buf.String(), err)
+	}
+}
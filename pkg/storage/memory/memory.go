@@ -0,0 +1,209 @@
+// Package memory implements storage.Storage in process memory. It is meant
+// for tests and throwaway web sessions; nothing survives a restart.
+package memory
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"tincan/pkg/storage"
+)
+
+type object struct {
+	data         []byte
+	lastModified time.Time
+	metadata     map[string]string
+}
+
+type Storage struct {
+	mu      sync.RWMutex
+	objects map[string]object
+}
+
+var _ storage.Storage = (*Storage)(nil)
+
+func New() *Storage {
+	return &Storage{objects: make(map[string]object)}
+}
+
+func (s *Storage) Upload(filePath, key string, opts ...storage.UploadOption) error {
+	return s.UploadContext(context.Background(), filePath, key, opts...)
+}
+
+func (s *Storage) UploadContext(ctx context.Context, filePath, key string, opts ...storage.UploadOption) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("unable to open file %q: %w", filePath, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("unable to stat file %q: %w", filePath, err)
+	}
+	if err := s.UploadStream(ctx, file, info.Size(), key, opts...); err != nil {
+		return fmt.Errorf("unable to read file %q: %w", filePath, err)
+	}
+	return nil
+}
+
+func (s *Storage) UploadStream(ctx context.Context, r io.Reader, size int64, key string, opts ...storage.UploadOption) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	options := storage.NewUploadOptions(opts...)
+
+	data, err := io.ReadAll(storage.TrackProgress(ctx, key, size).Reader(r))
+	if err != nil {
+		return err
+	}
+	if size >= 0 && int64(len(data)) != size {
+		return fmt.Errorf("unable to upload %q: read %d bytes, expected %d", key, len(data), size)
+	}
+
+	metadata := maps.Clone(options.Metadata)
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
+	sum := sha256.Sum256(data)
+	metadata[storage.MetaContentSHA256] = hex.EncodeToString(sum[:])
+
+	s.mu.Lock()
+	s.objects[key] = object{data: data, lastModified: time.Now(), metadata: metadata}
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *Storage) Download(key, filePath string) error {
+	return s.DownloadContext(context.Background(), key, filePath)
+}
+
+func (s *Storage) DownloadContext(ctx context.Context, key, filePath string) error {
+	return s.read(ctx, key, func(data []byte) error {
+		if err := os.WriteFile(filePath, data, 0o644); err != nil {
+			return fmt.Errorf("unable to write to file %q: %w", filePath, err)
+		}
+		return nil
+	})
+}
+
+func (s *Storage) DownloadStream(ctx context.Context, key string, w io.Writer) error {
+	return s.read(ctx, key, func(data []byte) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// read passes the content of key to write. Burn-after-reading objects are
+// taken out under the lock, so only one download can get them, and put back
+// if write fails.
+func (s *Storage) read(ctx context.Context, key string, write func([]byte) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	obj, ok := s.objects[key]
+	burn := ok && obj.metadata[storage.MetaBurn] == "true"
+	if burn {
+		delete(s.objects, key)
+	}
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%q: %w", key, storage.ErrNotExist)
+	}
+
+	if err := write(obj.data); err != nil {
+		if burn {
+			s.mu.Lock()
+			if _, replaced := s.objects[key]; !replaced {
+				s.objects[key] = obj
+			}
+			s.mu.Unlock()
+		}
+		return err
+	}
+	storage.TrackProgress(ctx, key, int64(len(obj.data))).Add(int64(len(obj.data)))
+
+	return nil
+}
+
+func (s *Storage) List() ([]storage.FileInfo, error) {
+	return s.ListContext(context.Background())
+}
+
+func (s *Storage) ListContext(ctx context.Context) ([]storage.FileInfo, error) {
+	page, err := s.ListPage(ctx, storage.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return page.Files, nil
+}
+
+func (s *Storage) ListPage(ctx context.Context, opts storage.ListOptions) (*storage.Page, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	files := make([]storage.FileInfo, 0, len(s.objects))
+	for key, obj := range s.objects {
+		if strings.HasPrefix(key, opts.Prefix) {
+			files = append(files, fileInfo(key, obj))
+		}
+	}
+
+	return storage.NewPage(files, opts), nil
+}
+
+func (s *Storage) Stat(key string) (*storage.FileInfo, error) {
+	return s.StatContext(context.Background(), key)
+}
+
+func (s *Storage) StatContext(ctx context.Context, key string) (*storage.FileInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	obj, ok := s.objects[key]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%q: %w", key, storage.ErrNotExist)
+	}
+
+	info := fileInfo(key, obj)
+	return &info, nil
+}
+
+func (s *Storage) Delete(key string) error {
+	return s.DeleteContext(context.Background(), key)
+}
+
+func (s *Storage) DeleteContext(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	delete(s.objects, key)
+	s.mu.Unlock()
+
+	return nil
+}
+
+func fileInfo(key string, obj object) storage.FileInfo {
+	return storage.FileInfo{
+		Name:         key,
+		Size:         int64(len(obj.data)),
+		LastModified: obj.lastModified,
+		Metadata:     maps.Clone(obj.metadata),
+	}
+}
@@ -0,0 +1,12 @@
+package memory
+
+import (
+	"testing"
+
+	"tincan/pkg/storage"
+	"tincan/pkg/storage/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage { return New() })
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Progress is a snapshot of a running upload or download.
+type Progress struct {
+	Key   string `json:"key"`
+	Bytes int64  `json:"bytes"` // transferred so far
+	Total int64  `json:"total"` // -1 if the size is not known in advance
+	// Rate is the average number of bytes per second since the transfer
+	// started, and ETA the time left at that rate. Both are 0 during the
+	// first second, which mostly measures setting up connections.
+	Rate float64       `json:"rate"`
+	ETA  time.Duration `json:"eta"`
+}
+
+// ProgressFunc receives the progress of a transfer. It is called from the
+// goroutines doing the transfer, one call at a time, and should return
+// quickly.
+type ProgressFunc func(Progress)
+
+type progressKey struct{}
+
+// WithProgress returns a context that makes the transfers started with it
+// report their progress to fn, at most every ProgressInterval and once more
+// when all bytes were transferred.
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// ProgressInterval is the shortest time between two reports of a transfer.
+const ProgressInterval = 100 * time.Millisecond
+
+// ProgressTracker counts the bytes of one transfer for the ProgressFunc of
+// the context it was made from. Its methods do nothing on a nil tracker,
+// which is what TrackProgress returns for a context without one.
+type ProgressTracker struct {
+	fn    ProgressFunc
+	key   string
+	total int64
+
+	mu       sync.Mutex
+	bytes    int64
+	resumed  int64
+	start    time.Time
+	reported time.Time
+}
+
+// TrackProgress returns a tracker for a transfer of total bytes (or -1) of
+// key, or nil if ctx has no ProgressFunc.
+func TrackProgress(ctx context.Context, key string, total int64) *ProgressTracker {
+	fn, _ := ctx.Value(progressKey{}).(ProgressFunc)
+	if fn == nil {
+		return nil
+	}
+	return &ProgressTracker{fn: fn, key: key, total: total, start: time.Now()}
+}
+
+// Resume counts n bytes transferred by an earlier, interrupted attempt.
+// They are not counted towards the rate.
+func (t *ProgressTracker) Resume(n int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.resumed += n
+	t.mu.Unlock()
+	t.Add(n)
+}
+
+// Add counts n more bytes. A negative n takes back bytes of a request that
+// failed and is sent again.
+func (t *ProgressTracker) Add(n int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.bytes += n
+	now := time.Now()
+	if now.Sub(t.reported) < ProgressInterval && t.bytes != t.total {
+		return
+	}
+	t.reported = now
+
+	p := Progress{Key: t.key, Bytes: t.bytes, Total: t.total}
+	if elapsed := now.Sub(t.start); elapsed >= time.Second {
+		p.Rate = float64(t.bytes-t.resumed) / elapsed.Seconds()
+	}
+	if p.Rate > 0 && t.total >= 0 {
+		p.ETA = time.Duration(float64(t.total-t.bytes) / p.Rate * float64(time.Second))
+	}
+	t.fn(p)
+}
+
+// Reader returns a reader that counts what is read from r.
+func (t *ProgressTracker) Reader(r io.Reader) io.Reader {
+	if t == nil {
+		return r
+	}
+	return &progressReader{r: r, t: t}
+}
+
+// Writer returns a writer that counts what is written to w.
+func (t *ProgressTracker) Writer(w io.Writer) io.Writer {
+	if t == nil {
+		return w
+	}
+	return &progressWriter{w: w, t: t}
+}
+
+type progressReader struct {
+	r io.Reader
+	t *ProgressTracker
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.t.Add(int64(n))
+	return n, err
+}
+
+type progressWriter struct {
+	w io.Writer
+	t *ProgressTracker
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.t.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket bounding the bytes per second of all the
+// transfers that share it, however many parts and ranges they run at once.
+// A rate of 0 means no limit. Its methods do nothing on a nil limiter.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   int64 // bytes per second
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a limiter allowing rate bytes per second.
+func NewRateLimiter(rate int64) *RateLimiter {
+	return &RateLimiter{rate: max(rate, 0), last: time.Now()}
+}
+
+// SetRate changes the limit of the transfers already running as well as
+// the ones started later.
+func (l *RateLimiter) SetRate(rate int64) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate == 0 {
+		l.tokens, l.last = 0, time.Now()
+	}
+	l.rate = max(rate, 0)
+	l.tokens = min(l.tokens, l.burst())
+}
+
+// Rate returns the current limit in bytes per second, 0 for none.
+func (l *RateLimiter) Rate() int64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// burst is how many bytes may go out at once after the limiter was idle:
+// a tenth of a second's worth.
+func (l *RateLimiter) burst() float64 {
+	return float64(l.rate) / 10
+}
+
+// chunk is the most bytes taken at a time, so that transfers sharing the
+// limiter take turns in small steps.
+func (l *RateLimiter) chunk() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate == 0 {
+		return 0
+	}
+	return int(min(max(l.rate/10, 1<<10), 32<<10))
+}
+
+// Wait takes n bytes from the bucket, waiting for them to be refilled if it
+// is empty. Callers may run ahead of the rate by at most one wait.
+func (l *RateLimiter) Wait(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	if l.rate == 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*float64(l.rate), l.burst())
+	l.last = now
+	l.tokens -= float64(n)
+	wait := time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Reader returns a reader that reads from r no faster than the limit.
+func (l *RateLimiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &limitedReader{ctx: ctx, r: r, l: l}
+}
+
+type limitedReader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *RateLimiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if chunk := r.l.chunk(); chunk > 0 && len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err := r.r.Read(p)
+	if werr := r.l.Wait(r.ctx, n); werr != nil && err == nil {
+		err = werr
+	}
+	return n, err
+}
+
+// ParseRate parses a transfer rate such as "5MB/s", "500K" or "1.5 MiB/s"
+// into bytes per second. Units are powers of 1024, as in the sizes tincan
+// prints. "", "0" and "unlimited" mean no limit.
+func ParseRate(s string) (int64, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	if v == "" || v == "UNLIMITED" {
+		return 0, nil
+	}
+	v = strings.TrimSuffix(v, "/S")
+	v = strings.TrimSuffix(v, "B")
+	v = strings.TrimSuffix(v, "I")
+	v = strings.TrimSpace(v)
+
+	unit := int64(1)
+	if i := len(v) - 1; i >= 0 {
+		if exp := strings.IndexByte("KMGT", v[i]); exp >= 0 {
+			unit = 1 << (10 * (exp + 1))
+			v = strings.TrimSpace(v[:i])
+		}
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n < 0 || math.IsInf(n, 0) || math.IsNaN(n) {
+		return 0, fmt.Errorf("invalid rate %q (expected a size per second such as 5MB/s)", s)
+	}
+	return int64(n * float64(unit)), nil
+}
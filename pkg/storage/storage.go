@@ -0,0 +1,345 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNotExist is returned when a key is not present in the backend.
+var ErrNotExist = errors.New("file does not exist")
+
+// ErrChecksum is returned when downloaded content does not match the
+// checksum recorded at upload.
+var ErrChecksum = errors.New("checksum mismatch")
+
+// ErrAccessDenied is returned when the credentials do not allow an
+// operation.
+var ErrAccessDenied = errors.New("access denied")
+
+// ErrNoBucket is returned when the bucket, or the directory of the local
+// backend, does not exist.
+var ErrNoBucket = errors.New("bucket does not exist")
+
+// ErrThrottled is returned when the backend kept asking to slow down until
+// the retries ran out.
+var ErrThrottled = errors.New("request rate exceeded")
+
+// ErrClaimed is returned when downloading a burn-after-reading file that
+// another download is already receiving.
+var ErrClaimed = errors.New("file is being downloaded by someone else")
+
+// ClaimTimeout is how long a download may hold the claim on a
+// burn-after-reading file. Claims older than this are treated as left behind
+// by a crashed download and taken over.
+const ClaimTimeout = time.Hour
+
+// ClaimRefresh is how often a download renews its claim, so that one taking
+// longer than ClaimTimeout is not taken for a crashed one.
+var ClaimRefresh = ClaimTimeout / 4
+
+// HoldClaim calls renew every ClaimRefresh until the returned function is
+// called. That function waits for a renew in progress, so that the claim
+// can be released right after it.
+func HoldClaim(renew func()) (stop func()) {
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(ClaimRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				renew()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+type FileInfo struct {
+	Name         string    `json:"name"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+	ETag         string    `json:"etag,omitempty"`
+	// Metadata holds the user metadata recorded at upload time. Backends
+	// that cannot return it cheaply leave it nil in List; use Stat.
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Metadata keys recorded on uploaded objects
+const (
+	MetaEncryption  = "tincan-encryption"
+	MetaCompression = "tincan-compression"
+	MetaModTime     = "tincan-mtime"  // RFC 3339 modification time of the source file
+	MetaSHA256      = "tincan-sha256" // hex SHA-256 of the original content
+	// Hex SHA-256 of the bytes as stored, which differ from the original when
+	// compressed or encrypted. Every backend records it on upload and checks
+	// it before a download is put in place.
+	MetaContentSHA256 = "tincan-content-sha256"
+	MetaExpires       = "tincan-expires" // RFC 3339 time after which prune deletes the file
+	MetaBurn          = "tincan-burn"    // "true" to delete the file after its first download
+)
+
+// TagExpireDays is the object tag recording a file's time to live in whole
+// days, for backends that expire files themselves; see ExpiryRules.
+const TagExpireDays = "tincan-expire-days"
+
+// Encrypted reports whether the file was encrypted on the client.
+func (f *FileInfo) Encrypted() bool {
+	return f.Metadata[MetaEncryption] != ""
+}
+
+// Compression returns the codec the file was compressed with, if any.
+func (f *FileInfo) Compression() string {
+	return f.Metadata[MetaCompression]
+}
+
+// ModTime returns the modification time of the original file if it was
+// recorded at upload, or LastModified otherwise.
+func (f *FileInfo) ModTime() time.Time {
+	if t, err := time.Parse(time.RFC3339Nano, f.Metadata[MetaModTime]); err == nil {
+		return t
+	}
+	return f.LastModified
+}
+
+// SHA256 returns the hex SHA-256 of the original content, or "" if it is
+// not known.
+func (f *FileInfo) SHA256() string {
+	if sum := f.Metadata[MetaSHA256]; sum != "" {
+		return sum
+	}
+	if !f.Encrypted() && f.Compression() == "" {
+		return f.Metadata[MetaContentSHA256]
+	}
+	return ""
+}
+
+// BurnAfterReading reports whether the first complete download deletes the
+// file. Backends let only one download of such a file proceed at a time.
+func (f *FileInfo) BurnAfterReading() bool {
+	return f.Metadata[MetaBurn] == "true"
+}
+
+// Expires returns when the file expires, or false if it never does.
+func (f *FileInfo) Expires() (time.Time, bool) {
+	t, err := time.Parse(time.RFC3339Nano, f.Metadata[MetaExpires])
+	return t, err == nil
+}
+
+type UploadOptions struct {
+	Metadata map[string]string
+	// Tags are attached to the object by backends that support tagging and
+	// ignored by the others.
+	Tags map[string]string
+}
+
+type UploadOption func(*UploadOptions)
+
+// WithMetadata attaches user metadata to the uploaded object.
+func WithMetadata(metadata map[string]string) UploadOption {
+	return func(o *UploadOptions) {
+		if o.Metadata == nil {
+			o.Metadata = make(map[string]string)
+		}
+		for k, v := range metadata {
+			o.Metadata[k] = v
+		}
+	}
+}
+
+// WithTags attaches tags to the uploaded object.
+func WithTags(tags map[string]string) UploadOption {
+	return func(o *UploadOptions) {
+		if o.Tags == nil {
+			o.Tags = make(map[string]string)
+		}
+		for k, v := range tags {
+			o.Tags[k] = v
+		}
+	}
+}
+
+// NewUploadOptions applies opts to a zero UploadOptions.
+func NewUploadOptions(opts ...UploadOption) UploadOptions {
+	var o UploadOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// InternalPrefix holds TinCan's own bookkeeping objects, such as the index
+// of share codes. Listings leave it out unless it is asked for by prefix.
+const InternalPrefix = ".tincan/"
+
+// Hidden reports whether a listing of prefix leaves out the key or prefix
+// name.
+func Hidden(name, prefix string) bool {
+	return strings.HasPrefix(name, InternalPrefix) && !strings.HasPrefix(prefix, InternalPrefix)
+}
+
+// ListOptions selects one page of a listing.
+type ListOptions struct {
+	// Prefix restricts the listing to keys starting with it.
+	Prefix string
+	// Delimiter, usually "/", groups keys that contain it after Prefix into
+	// a single entry in Prefixes, like the subdirectories of a directory.
+	Delimiter string
+	// Cursor continues a listing after the page that returned it as
+	// NextCursor. It is opaque and only valid for the same backend.
+	Cursor string
+	// Limit caps the number of files and prefixes in the page. Zero lets
+	// the backend choose, which may mean everything.
+	Limit int
+}
+
+// Page is one batch of a listing, in key order.
+type Page struct {
+	Files []FileInfo `json:"files"`
+	// Prefixes holds the grouped keys when a Delimiter was given, each
+	// ending in the delimiter.
+	Prefixes []string `json:"prefixes,omitempty"`
+	// NextCursor is empty on the last page.
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// NewPage applies opts to a complete listing, for backends that hold all of
+// their keys at hand. The cursor it returns is the last key or prefix of the
+// page.
+func NewPage(files []FileInfo, opts ListOptions) *Page {
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	page := &Page{}
+	count, last := 0, opts.Cursor
+	for _, f := range files {
+		if !strings.HasPrefix(f.Name, opts.Prefix) {
+			continue
+		}
+		name := f.Name
+		isPrefix := false
+		if opts.Delimiter != "" {
+			if i := strings.Index(name[len(opts.Prefix):], opts.Delimiter); i >= 0 {
+				name = name[:len(opts.Prefix)+i+len(opts.Delimiter)]
+				isPrefix = true
+			}
+		}
+		if name <= last || Hidden(name, opts.Prefix) {
+			continue
+		}
+		if opts.Limit > 0 && count == opts.Limit {
+			page.NextCursor = last
+			break
+		}
+		if isPrefix {
+			page.Prefixes = append(page.Prefixes, name)
+		} else {
+			page.Files = append(page.Files, f)
+		}
+		count++
+		last = name
+	}
+
+	return page
+}
+
+// Storage is implemented by every backend TinCan can transfer files through.
+// The Context variants stop when the context is cancelled; the others use
+// context.Background.
+type Storage interface {
+	Upload(filePath, key string, opts ...UploadOption) error
+	Download(key, filePath string) error
+	List() ([]FileInfo, error)
+	Stat(key string) (*FileInfo, error)
+	Delete(key string) error
+
+	UploadContext(ctx context.Context, filePath, key string, opts ...UploadOption) error
+	DownloadContext(ctx context.Context, key, filePath string) error
+	ListContext(ctx context.Context) ([]FileInfo, error)
+	StatContext(ctx context.Context, key string) (*FileInfo, error)
+	DeleteContext(ctx context.Context, key string) error
+
+	ListPage(ctx context.Context, opts ListOptions) (*Page, error)
+
+	// UploadStream stores what is read from r until EOF as key. size is the
+	// number of bytes r holds, or -1 if that is not known in advance.
+	UploadStream(ctx context.Context, r io.Reader, size int64, key string, opts ...UploadOption) error
+	// DownloadStream writes the content of key to w. Unlike Download it
+	// cannot hold back corrupted content: it is found at the end, after it
+	// was written, and reported as ErrChecksum.
+	DownloadStream(ctx context.Context, key string, w io.Writer) error
+}
+
+// SkipAll can be returned by a Walk callback to stop early without an error.
+var SkipAll = errors.New("skip everything and stop the walk")
+
+// Walk calls fn for every file whose key starts with prefix, fetching one
+// page at a time so that large buckets never have to be held in memory at
+// once.
+func Walk(ctx context.Context, s Storage, prefix string, fn func(FileInfo) error) error {
+	opts := ListOptions{Prefix: prefix}
+	for {
+		page, err := s.ListPage(ctx, opts)
+		if err != nil {
+			return err
+		}
+		for _, f := range page.Files {
+			if err := fn(f); err != nil {
+				if errors.Is(err, SkipAll) {
+					return nil
+				}
+				return err
+			}
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+// PendingUpload describes an interrupted upload that can still be resumed.
+type PendingUpload struct {
+	ID       string    `json:"id"`
+	Key      string    `json:"key"`
+	FilePath string    `json:"filePath"`
+	Size     int64     `json:"size"`
+	Uploaded int64     `json:"uploaded"`
+	Started  time.Time `json:"started"`
+}
+
+// Resumable is implemented by backends that keep interrupted uploads so that
+// uploading the same file again continues where it stopped.
+type Resumable interface {
+	PendingUploads() ([]PendingUpload, error)
+	AbortUpload(id string) error
+}
+
+// Presigner is implemented by backends that can hand out URLs granting
+// temporary access to a single key without credentials.
+type Presigner interface {
+	// PresignGet returns a URL that downloads key until it expires.
+	PresignGet(ctx context.Context, key string, expires time.Duration) (string, error)
+	// PresignPut returns a URL that accepts an HTTP PUT of the content of
+	// key until it expires, as long as key does not exist. The PUT must
+	// carry the header "If-None-Match: *".
+	PresignPut(ctx context.Context, key string, expires time.Duration) (string, error)
+}
+
+// ExpiryRules is implemented by backends that can delete expired files on
+// their own, without running prune.
+type ExpiryRules interface {
+	// InstallExpiryRules makes the backend delete files tagged with
+	// TagExpireDays set to one of days once that many days have passed
+	// since their upload.
+	InstallExpiryRules(ctx context.Context, days []int) error
+}
@@ -0,0 +1,318 @@
+// Package storagetest checks that a storage.Storage behaves the way TinCan
+// relies on, so that every backend can be tested against the same contract.
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tincan/pkg/storage"
+)
+
+// Run tests the backends returned by newStorage, which is called once per
+// subtest and must return an empty store.
+func Run(t *testing.T, newStorage func(t *testing.T) storage.Storage) {
+	t.Run("UploadDownload", func(t *testing.T) { testUploadDownload(t, newStorage(t)) })
+	t.Run("Streams", func(t *testing.T) { testStreams(t, newStorage(t)) })
+	t.Run("Metadata", func(t *testing.T) { testMetadata(t, newStorage(t)) })
+	t.Run("ListPage", func(t *testing.T) { testListPage(t, newStorage(t)) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, newStorage(t)) })
+	t.Run("Burn", func(t *testing.T) { testBurn(t, newStorage(t)) })
+	t.Run("BurnConcurrently", func(t *testing.T) { testBurnConcurrently(t, newStorage(t)) })
+}
+
+func randomBytes(n int) []byte {
+	data := make([]byte, n)
+	rand.Read(data)
+	return data
+}
+
+func writeFile(t *testing.T, data []byte) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(p, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func upload(t *testing.T, s storage.Storage, key string, data []byte, opts ...storage.UploadOption) {
+	t.Helper()
+	if err := s.UploadStream(context.Background(), bytes.NewReader(data), int64(len(data)), key, opts...); err != nil {
+		t.Fatalf("UploadStream %s: %v", key, err)
+	}
+}
+
+func download(t *testing.T, s storage.Storage, key string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := s.DownloadStream(context.Background(), key, &buf); err != nil {
+		t.Fatalf("DownloadStream %s: %v", key, err)
+	}
+	return buf.Bytes()
+}
+
+func testUploadDownload(t *testing.T, s storage.Storage) {
+	for _, size := range []int{0, 1000, 100 << 10} {
+		data := randomBytes(size)
+		key := "files/" + strings.Repeat("x", size%7+1)
+		if err := s.Upload(writeFile(t, data), key); err != nil {
+			t.Fatalf("Upload of %d bytes: %v", size, err)
+		}
+
+		info, err := s.Stat(key)
+		if err != nil {
+			t.Fatalf("Stat: %v", err)
+		}
+		sum := sha256.Sum256(data)
+		if info.Name != key || info.Size != int64(size) || info.SHA256() != hex.EncodeToString(sum[:]) {
+			t.Fatalf("Stat = %s, %d bytes, SHA-256 %q; want %s, %d bytes, %x", info.Name, info.Size, info.SHA256(), key, size, sum)
+		}
+
+		dest := filepath.Join(t.TempDir(), "downloaded")
+		if err := s.Download(key, dest); err != nil {
+			t.Fatalf("Download of %d bytes: %v", size, err)
+		}
+		if got, _ := os.ReadFile(dest); !bytes.Equal(got, data) {
+			t.Fatalf("Download of %d bytes: content differs", size)
+		}
+	}
+
+	dest := filepath.Join(t.TempDir(), "missing")
+	if err := s.Download("missing", dest); !errors.Is(err, storage.ErrNotExist) {
+		t.Fatalf("Download of a missing key = %v, want ErrNotExist", err)
+	}
+	if _, err := os.Stat(dest); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("failed download left %s behind", dest)
+	}
+	if _, err := s.Stat("missing"); !errors.Is(err, storage.ErrNotExist) {
+		t.Fatalf("Stat of a missing key = %v, want ErrNotExist", err)
+	}
+}
+
+func testStreams(t *testing.T, s storage.Storage) {
+	data := randomBytes(200 << 10)
+	for _, size := range []int64{int64(len(data)), -1} {
+		if err := s.UploadStream(context.Background(), bytes.NewReader(data), size, "stream"); err != nil {
+			t.Fatalf("UploadStream with size %d: %v", size, err)
+		}
+		if got := download(t, s, "stream"); !bytes.Equal(got, data) {
+			t.Fatalf("DownloadStream after upload with size %d: content differs", size)
+		}
+	}
+
+	if err := s.DownloadStream(context.Background(), "missing", &bytes.Buffer{}); !errors.Is(err, storage.ErrNotExist) {
+		t.Fatalf("DownloadStream of a missing key = %v, want ErrNotExist", err)
+	}
+
+	// A stream that does not hold the size given stores nothing, and leaves
+	// what was stored before in place
+	for _, size := range []int64{int64(len(data)) - 1, int64(len(data)) + 1} {
+		if err := s.UploadStream(context.Background(), bytes.NewReader(data), size, "sized"); err == nil {
+			t.Fatalf("UploadStream of %d bytes with size %d succeeded", len(data), size)
+		}
+		if _, err := s.StatContext(context.Background(), "sized"); !errors.Is(err, storage.ErrNotExist) {
+			t.Fatalf("Stat after an upload with size %d = %v, want ErrNotExist", size, err)
+		}
+		if err := s.UploadStream(context.Background(), bytes.NewReader(data[:10]), size, "stream"); err == nil {
+			t.Fatalf("UploadStream of 10 bytes with size %d succeeded", size)
+		}
+		if got := download(t, s, "stream"); !bytes.Equal(got, data) {
+			t.Fatalf("UploadStream with size %d replaced the stored content", size)
+		}
+	}
+}
+
+func testMetadata(t *testing.T, s storage.Storage) {
+	metadata := map[string]string{
+		storage.MetaCompression: "zstd",
+		storage.MetaModTime:     "2024-05-01T12:00:00Z",
+	}
+	upload(t, s, "meta", []byte("content"), storage.WithMetadata(metadata), storage.WithTags(map[string]string{"t": "v"}))
+
+	info, err := s.Stat("meta")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	for k, v := range metadata {
+		if info.Metadata[k] != v {
+			t.Errorf("metadata %s = %q, want %q", k, info.Metadata[k], v)
+		}
+	}
+	if info.Compression() != "zstd" || info.ModTime().Year() != 2024 {
+		t.Errorf("Compression = %q, ModTime = %v", info.Compression(), info.ModTime())
+	}
+	// The checksum of compressed content says nothing about the original
+	if info.Metadata[storage.MetaContentSHA256] == "" || info.SHA256() != "" {
+		t.Errorf("content SHA-256 %q, SHA256() %q", info.Metadata[storage.MetaContentSHA256], info.SHA256())
+	}
+
+	// Uploading again replaces the metadata along with the content
+	upload(t, s, "meta", []byte("plain"))
+	if info, err = s.Stat("meta"); err != nil || info.Compression() != "" {
+		t.Fatalf("Stat after replacing = %v, %v", info, err)
+	}
+}
+
+func testListPage(t *testing.T, s storage.Storage) {
+	for _, key := range []string{"top.txt", "docs/a.txt", "docs/b/c.txt", "docs/b/d.txt", "docs/e/f.txt", "docs/g.txt", storage.InternalPrefix + "index"} {
+		upload(t, s, key, []byte(key))
+	}
+
+	// Pages may hold fewer than Limit entries, so only their sum is checked
+	list := func(opts storage.ListOptions) (files, prefixes []string) {
+		t.Helper()
+		for {
+			page, err := s.ListPage(context.Background(), opts)
+			if err != nil {
+				t.Fatalf("ListPage: %v", err)
+			}
+			for _, f := range page.Files {
+				files = append(files, f.Name)
+			}
+			prefixes = append(prefixes, page.Prefixes...)
+			if page.NextCursor == "" {
+				return files, prefixes
+			}
+			opts.Cursor = page.NextCursor
+		}
+	}
+
+	files, prefixes := list(storage.ListOptions{Limit: 2})
+	if got := strings.Join(files, ","); got != "docs/a.txt,docs/b/c.txt,docs/b/d.txt,docs/e/f.txt,docs/g.txt,top.txt" || len(prefixes) != 0 {
+		t.Errorf("listing everything = %s, prefixes %v", got, prefixes)
+	}
+
+	files, prefixes = list(storage.ListOptions{Prefix: "docs/", Delimiter: "/", Limit: 1})
+	if got := strings.Join(files, ","); got != "docs/a.txt,docs/g.txt" {
+		t.Errorf("files of docs/ = %s, want docs/a.txt,docs/g.txt", got)
+	}
+	if got := strings.Join(prefixes, ","); got != "docs/b/,docs/e/" {
+		t.Errorf("prefixes of docs/ = %s, want docs/b/,docs/e/", got)
+	}
+
+	files, _ = list(storage.ListOptions{Prefix: storage.InternalPrefix})
+	if got := strings.Join(files, ","); got != storage.InternalPrefix+"index" {
+		t.Errorf("listing %s = %s", storage.InternalPrefix, got)
+	}
+
+	all, err := s.List()
+	if err != nil || len(all) != 6 {
+		t.Errorf("List = %d files, %v; want 6", len(all), err)
+	}
+}
+
+func testDelete(t *testing.T, s storage.Storage) {
+	upload(t, s, "dir/doomed", []byte("bye"))
+	upload(t, s, "dir/kept", []byte("hi"))
+
+	if err := s.Delete("dir/doomed"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Stat("dir/doomed"); !errors.Is(err, storage.ErrNotExist) {
+		t.Fatalf("Stat after Delete = %v, want ErrNotExist", err)
+	}
+	if got := download(t, s, "dir/kept"); string(got) != "hi" {
+		t.Fatalf("neighbour of a deleted file reads %q", got)
+	}
+	page, err := s.ListPage(context.Background(), storage.ListOptions{Prefix: "dir/"})
+	if err != nil || len(page.Files) != 1 {
+		t.Fatalf("ListPage after Delete = %v, %v", page, err)
+	}
+}
+
+func testBurn(t *testing.T, s storage.Storage) {
+	burn := storage.WithMetadata(map[string]string{storage.MetaBurn: "true"})
+	for _, size := range []int{0, 1000} {
+		data := randomBytes(size)
+		upload(t, s, "secret", data, burn)
+
+		info, err := s.Stat("secret")
+		if err != nil || !info.BurnAfterReading() {
+			t.Fatalf("Stat of a burn-after-reading file = %v, %v", info, err)
+		}
+		if got := download(t, s, "secret"); !bytes.Equal(got, data) {
+			t.Fatalf("burn-after-reading file of %d bytes: content differs", size)
+		}
+		if _, err := s.Stat("secret"); !errors.Is(err, storage.ErrNotExist) {
+			t.Fatalf("Stat after reading = %v, want ErrNotExist", err)
+		}
+		if err := s.DownloadStream(context.Background(), "secret", &bytes.Buffer{}); !errors.Is(err, storage.ErrNotExist) {
+			t.Fatalf("second download = %v, want ErrNotExist", err)
+		}
+	}
+
+	// A failed download leaves the file for the next one
+	upload(t, s, "secret", []byte("again"), burn)
+	if err := s.DownloadStream(context.Background(), "secret", failingWriter{}); err == nil {
+		t.Fatal("DownloadStream into a failing writer succeeded")
+	}
+	if got := download(t, s, "secret"); string(got) != "again" {
+		t.Fatalf("download after a failed one = %q", got)
+	}
+}
+
+func testBurnConcurrently(t *testing.T, s storage.Storage) {
+	// However the downloads interleave, exactly one receives the file, and
+	// the others learn that it is taken or gone
+	for round := 0; round < 5; round++ {
+		for _, size := range []int{0, 64 << 10} {
+			upload(t, s, "secret", randomBytes(size), storage.WithMetadata(map[string]string{storage.MetaBurn: "true"}))
+
+			const downloads = 8
+			var (
+				wg    sync.WaitGroup
+				start = make(chan struct{})
+				errs  = make(chan error, downloads)
+			)
+			for i := 0; i < downloads; i++ {
+				wg.Add(1)
+				go func(delay time.Duration) {
+					defer wg.Done()
+					<-start
+					// Staggered, so that some look the file up while
+					// another is finishing with it
+					time.Sleep(delay)
+					errs <- s.DownloadStream(context.Background(), "secret", io.Discard)
+				}(time.Duration(i) * 300 * time.Microsecond)
+			}
+			close(start)
+			wg.Wait()
+			close(errs)
+
+			received := 0
+			for err := range errs {
+				switch {
+				case err == nil:
+					received++
+				case errors.Is(err, storage.ErrClaimed), errors.Is(err, storage.ErrNotExist):
+				default:
+					t.Fatalf("concurrent download of %d bytes: %v", size, err)
+				}
+			}
+			if received != 1 {
+				t.Fatalf("%d of %d concurrent downloads received a burn-after-reading file of %d bytes", received, downloads, size)
+			}
+			if _, err := s.Stat("secret"); !errors.Is(err, storage.ErrNotExist) {
+				t.Fatalf("Stat after the downloads = %v, want ErrNotExist", err)
+			}
+		}
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}